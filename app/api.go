@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// apiRequest is the body of a /resolve request
+type apiRequest struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // defaults to A
+}
+
+// apiRecord is one answer record in a /resolve response
+type apiRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"` // address for A/AAAA, presentation form for SVCB/HTTPS, hex RDATA otherwise
+}
+
+// apiResponse is the body of a /resolve response
+type apiResponse struct {
+	RCode   uint16      `json:"rcode"`
+	Answers []apiRecord `json:"answers"`
+}
+
+// serveAPI starts the JSON query API on addr, answering POSTs to
+// /resolve by running them through HandleQuery
+func (s *DNSServer) serveAPI(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for API: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /resolve", s.serveResolve)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logf("API server error: %v\n", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// serveResolve answers a single JSON query
+func (s *DNSServer) serveResolve(w http.ResponseWriter, r *http.Request) {
+	var req apiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Type == "" {
+		req.Type = "A"
+	}
+
+	qtype, err := dns.ParseType(req.Type)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := dns.EncodeName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := s.lookup(r.Context(), req.Name, qtype)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := apiResponse{RCode: response.RCode(), Answers: []apiRecord{}}
+	for _, answer := range response.Answers {
+		record := apiRecord{
+			Name: dns.NameString(answer.Name),
+			Type: dns.TypeString(answer.Type),
+			TTL:  answer.TTL,
+			Data: hex.EncodeToString(answer.RData),
+		}
+		if ip, ok := answer.IP(); ok {
+			record.Data = ip.String()
+		}
+		if answer.Type == dns.TypeSVCB || answer.Type == dns.TypeHTTPS {
+			if svcb, err := dns.ParseSVCB(answer.RData); err == nil {
+				record.Data = svcb.String()
+			}
+		}
+		result.Answers = append(result.Answers, record)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
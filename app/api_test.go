@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveAPI(t *testing.T) {
+	server := newTestServer(t)
+	server.zone = testZone(t, "www.example.com 300 A 192.0.2.1\nwww.example.com 300 A 192.0.2.2\n")
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantData   []string
+	}{
+		{"A records", `{"name":"www.example.com","type":"A"}`, http.StatusOK, []string{"192.0.2.1", "192.0.2.2"}},
+		{"default type", `{"name":"www.example.com"}`, http.StatusOK, []string{"192.0.2.1", "192.0.2.2"}},
+		{"no data", `{"name":"www.example.com","type":"AAAA"}`, http.StatusOK, nil},
+		{"bad type", `{"name":"www.example.com","type":"BOGUS"}`, http.StatusBadRequest, nil},
+		{"bad JSON", `{"name":`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.serveResolve(recorder, httptest.NewRequest("POST", "/resolve", strings.NewReader(tt.body)))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var result apiResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil {
+				t.Fatalf("invalid JSON response: %v", err)
+			}
+			if result.RCode != 0 || len(result.Answers) != len(tt.wantData) {
+				t.Fatalf("rcode %d with %d answers, want 0 with %d", result.RCode, len(result.Answers), len(tt.wantData))
+			}
+			for i, record := range result.Answers {
+				if record.Name != "www.example.com" || record.Type != "A" || record.TTL != 300 || record.Data != tt.wantData[i] {
+					t.Errorf("answer %d = %+v, want www.example.com A 300 %s", i, record, tt.wantData[i])
+				}
+			}
+		})
+	}
+}
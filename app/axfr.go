@@ -0,0 +1,61 @@
+package main
+
+import "github.com/codecrafters-io/dns-server-starter-go/app/dns"
+
+// axfrMessageSize is the size a zone transfer message is filled up to
+// before the next record starts a new one, well under the 65535-byte
+// TCP message limit
+const axfrMessageSize = 16384
+
+// transfer answers an AXFR query received over TCP with the messages
+// of the zone transfer. ok is false for any other query, which is then
+// handled as usual.
+func (s *DNSServer) transfer(query []byte) (messages [][]byte, ok bool) {
+	var request dns.DNSMessage
+	if err := request.Parse(query); err != nil || request.Header.IsResponse() {
+		return nil, false
+	}
+	if request.Header.Opcode() != dns.OpcodeQuery || len(request.Questions) != 1 ||
+		request.Questions[0].QType != dns.TypeAXFR {
+		return nil, false
+	}
+
+	q := request.Questions[0]
+	s.logf("Zone transfer of %s requested\n", q.Name())
+
+	if !s.AllowTransfer || s.zone == nil {
+		return [][]byte{errorResponse(&request, dns.RCodeRefused).Encode()}, true
+	}
+	records, ok := s.zone.Transfer(q.QName, q.QClass)
+	if !ok {
+		return [][]byte{errorResponse(&request, dns.RCodeNotAuth).Encode()}, true
+	}
+
+	return transferMessages(&request, records), true
+}
+
+// transferMessages packs the records of a zone transfer into as few
+// messages as fit axfrMessageSize. Only the first repeats the question.
+func transferMessages(request *dns.DNSMessage, records []dns.DNSAnswer) [][]byte {
+	header := request.Header.BuildResponse()
+	header.Flags |= dns.FlagAA
+
+	response := dns.DNSMessage{Header: header, Questions: request.Questions}
+	response.SyncCounts()
+	size := len(response.Encode())
+
+	var messages [][]byte
+	for _, rr := range records {
+		n := len(rr.Encode())
+		if len(response.Answers) > 0 && size+n > axfrMessageSize {
+			messages = append(messages, response.Encode())
+			response = dns.DNSMessage{Header: header}
+			response.SyncCounts()
+			size = len(response.Encode())
+		}
+		response.AddAnswer(rr)
+		size += n
+	}
+
+	return append(messages, response.Encode())
+}
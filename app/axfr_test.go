@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// transferZone returns an example.com zone with an SOA and hosts A
+// records besides it
+func transferZone(t *testing.T, hosts int) *dns.Zone {
+	t.Helper()
+	zone := testZone(t, "example.com NS ns.example.com\n")
+	if err := zone.AddRaw("example.com", dns.TypeSOA, soaRecord(3600, 300).RData); err != nil {
+		t.Fatalf("AddRaw: %v", err)
+	}
+	for i := range hosts {
+		if err := zone.AddA(fmt.Sprintf("host%d.example.com", i), net.IPv4(192, 0, byte(i>>8), byte(i))); err != nil {
+			t.Fatalf("AddA: %v", err)
+		}
+	}
+	return zone
+}
+
+func TestZoneTransferOverTCP(t *testing.T) {
+	server := newTestServer(t)
+	server.ListenTCP = true
+	server.AllowTransfer = true
+	server.zone = transferZone(t, 3)
+	startServer(t, server)
+
+	conn := dialTCP(t, server)
+	writeTCPMessage(conn, newQuery(t, "example.com", dns.TypeAXFR).Encode())
+
+	// Read messages until the closing SOA
+	var records []dns.DNSAnswer
+	for len(records) < 2 || records[len(records)-1].Type != dns.TypeSOA {
+		response := readTCPResponse(t, conn)
+		if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+			t.Fatalf("RCODE = %d, want NOERROR", rcode)
+		}
+		records = append(records, response.Answers...)
+	}
+
+	if records[0].Type != dns.TypeSOA {
+		t.Errorf("transfer starts with type %d, want SOA", records[0].Type)
+	}
+	// SOA, NS, three A records and the SOA again
+	if len(records) != 6 {
+		t.Errorf("transferred %d records, want 6", len(records))
+	}
+}
+
+func TestZoneTransferIsRefusedByDefault(t *testing.T) {
+	server := newTestServer(t)
+	server.zone = transferZone(t, 1)
+
+	messages, ok := server.transfer(newQuery(t, "example.com", dns.TypeAXFR).Encode())
+	if !ok || len(messages) != 1 {
+		t.Fatalf("transfer() = %d messages, %t; want one refusal", len(messages), ok)
+	}
+	var response dns.DNSMessage
+	if err := response.Parse(messages[0]); err != nil || response.Header.RCode() != dns.RCodeRefused {
+		t.Errorf("transfer response RCODE %d (%v), want REFUSED", response.Header.RCode(), err)
+	}
+}
+
+func TestLargeTransferIsSplit(t *testing.T) {
+	zone := transferZone(t, 2000)
+	records, ok := zone.Transfer([]byte("\x07example\x03com\x00"), dns.ClassIN)
+	if !ok {
+		t.Fatal("zone has no SOA")
+	}
+
+	request := newQuery(t, "example.com", dns.TypeAXFR)
+	messages := transferMessages(request, records)
+	if len(messages) < 2 {
+		t.Fatalf("%d records fit in %d message", len(records), len(messages))
+	}
+
+	total := 0
+	for i, data := range messages {
+		if len(data) > axfrMessageSize {
+			t.Errorf("message %d is %d bytes", i, len(data))
+		}
+		var msg dns.DNSMessage
+		if err := msg.ParseComplete(data); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		wantQuestions := 0
+		if i == 0 {
+			wantQuestions = 1 // only the first repeats the question
+		}
+		if len(msg.Questions) != wantQuestions {
+			t.Errorf("message %d has %d questions, want %d", i, len(msg.Questions), wantQuestions)
+		}
+		total += len(msg.Answers)
+	}
+	if total != len(records) {
+		t.Errorf("messages carry %d records, want %d", total, len(records))
+	}
+}
+
+func TestZoneTransferTakesAnInFlightSlot(t *testing.T) {
+	const limit = 1
+	server := newTestServer(t, WithMaxInFlight(limit))
+	server.ListenTCP = true
+	server.AllowTransfer = true
+	server.zone = transferZone(t, 3)
+	startSaturated(t, server, limit)
+
+	conn := dialTCP(t, server)
+	writeTCPMessage(conn, newQuery(t, "example.com", dns.TypeAXFR).Encode())
+	response := readTCPResponse(t, conn)
+	if rcode := response.Header.RCode(); rcode != dns.RCodeRefused || len(response.Answers) != 0 {
+		t.Errorf("transfer beyond the limit got RCODE %d with %d records, want REFUSED", rcode, len(response.Answers))
+	}
+}
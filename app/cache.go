@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// soaMinimumOffset is where the MINIMUM field starts, counted back from
+// the end of SOA RDATA (it is the last of five 32-bit fields)
+const soaMinimumOffset = 4
+
+// Cache stores forwarded responses under a key identifying the question.
+// Implementations may be shared between server instances (e.g. backed
+// by Redis) and must be safe for concurrent use.
+type Cache interface {
+	// Get returns the live entry stored under key
+	Get(key string) (CacheEntry, bool)
+
+	// Set stores entry under key for ttl; a zero ttl means the entry
+	// must not be cached
+	Set(key string, entry CacheEntry, ttl time.Duration)
+}
+
+// CacheEntry is a cached forwarded response: its answers, or for a
+// negative response the RCODE and the SOA from the authority section
+type CacheEntry struct {
+	RCode       uint16
+	Flags       uint16 // the upstream's RA and AD bits
+	Answers     []dns.DNSAnswer
+	Authorities []dns.DNSAnswer
+	Stored      time.Time // when the entry was cached, to age its TTLs
+
+	// TTL is how long the entry is fresh for. With serve-stale the
+	// cache holds it longer, as a fallback for when the upstream fails.
+	TTL time.Duration
+}
+
+// staleAnswerTTL is the TTL of stale answers, so clients soon ask again
+// (RFC 8767 section 4 recommends 30 seconds)
+const staleAnswerTTL = 30
+
+// cacheKey returns the cache key for the request's first question.
+// Names are compared case-insensitively, and DO=1 responses (which may
+// carry DNSSEC records) and CD=1 ones (which may not have been
+// validated) are kept apart from the rest, as are answers tailored to
+// a forwarded client subnet.
+func cacheKey(request *dns.DNSMessage) string {
+	q := request.Questions[0]
+	do := false
+	var subnet []byte
+	if opt, err := request.OPT(); err == nil && opt != nil {
+		do = opt.DO()
+		subnet, _ = opt.Option(dns.OptionClientSubnet)
+	}
+	cd := request.Header.Flags&dns.FlagCD != 0
+	return fmt.Sprintf("%x/%d/%d/%t/%t/%x", dns.CanonicalName(q.QName), q.QType, q.QClass, do, cd, subnet)
+}
+
+// cachedResponse builds a response to request from a fresh cache
+// entry, with TTLs reduced by the time the entry has been cached
+func (s *DNSServer) cachedResponse(request *dns.DNSMessage) (*dns.DNSMessage, bool) {
+	entry, ok := s.Cache.Get(cacheKey(request))
+	if !ok || (entry.TTL > 0 && time.Since(entry.Stored) >= entry.TTL) {
+		return nil, false
+	}
+
+	elapsed := uint32(time.Since(entry.Stored) / time.Second)
+	return entryResponse(request, entry, func(ttl uint32) uint32 { return ttl - min(ttl, elapsed) }), true
+}
+
+// staleResponse builds a response to request from a cache entry that
+// has expired but is still within the serve-stale window, with every
+// TTL set to staleAnswerTTL (RFC 8767)
+func (s *DNSServer) staleResponse(request *dns.DNSMessage) (*dns.DNSMessage, bool) {
+	entry, ok := s.Cache.Get(cacheKey(request))
+	if !ok {
+		return nil, false
+	}
+	return entryResponse(request, entry, func(uint32) uint32 { return staleAnswerTTL }), true
+}
+
+// entryResponse builds a response to request from a cache entry, with
+// each record's TTL mapped by ttl
+func entryResponse(request *dns.DNSMessage, entry CacheEntry, ttl func(uint32) uint32) *dns.DNSMessage {
+	response := dns.DNSMessage{Header: request.Header.BuildResponse()}
+	response.Header.Flags |= entry.Flags
+	response.Header.SetRCode(entry.RCode)
+	response.SyncCounts()
+	response.AddQuestion(request.Questions[0])
+	for _, answer := range cloneAnswers(entry.Answers) {
+		answer.TTL = ttl(answer.TTL)
+		response.AddAnswer(answer)
+	}
+	for _, authority := range cloneAnswers(entry.Authorities) {
+		authority.TTL = ttl(authority.TTL)
+		response.AddAuthority(authority)
+	}
+
+	return &response
+}
+
+// cacheResponse caches the response to request. Answers are kept for
+// their lowest TTL, so a single TTL 0 record keeps the response out of
+// the cache; NXDOMAIN and NODATA responses are kept for the negative
+// TTL of their SOA record (RFC 2308), and not at all without one.
+func (s *DNSServer) cacheResponse(request, response *dns.DNSMessage) {
+	// A truncated response is incomplete, so never serve it again
+	if response.Header.Truncated() {
+		return
+	}
+
+	entry := CacheEntry{
+		RCode:  response.Header.RCode(),
+		Flags:  response.Header.Flags & (dns.FlagRA | dns.FlagAD),
+		Stored: time.Now(),
+	}
+
+	var ttl uint32
+	switch {
+	case entry.RCode == dns.RCodeNoError && len(response.Answers) > 0:
+		entry.Answers = cloneAnswers(response.Answers)
+		ttl = minTTL(response.Answers)
+	case entry.RCode == dns.RCodeNoError || entry.RCode == dns.RCodeNXDomain:
+		soa, ok := negativeSOA(response)
+		if !ok {
+			return
+		}
+		entry.Authorities = cloneAnswers([]dns.DNSAnswer{soa})
+		ttl = soa.TTL
+	default:
+		return
+	}
+	if ttl == 0 {
+		return
+	}
+
+	entry.TTL = time.Duration(ttl) * time.Second
+	s.Cache.Set(cacheKey(request), entry, entry.TTL+s.ServeStale)
+}
+
+// cloneAnswers copies records along with their names and RDATA, so a
+// cache entry never shares memory with a response middleware may edit
+func cloneAnswers(records []dns.DNSAnswer) []dns.DNSAnswer {
+	clone := slices.Clone(records)
+	for i := range clone {
+		clone[i].Name = bytes.Clone(clone[i].Name)
+		clone[i].RData = bytes.Clone(clone[i].RData)
+	}
+	return clone
+}
+
+// minTTL returns the lowest TTL among records
+func minTTL(records []dns.DNSAnswer) uint32 {
+	ttl := records[0].TTL
+	for _, r := range records[1:] {
+		ttl = min(ttl, r.TTL)
+	}
+	return ttl
+}
+
+// negativeSOA returns the authority section's SOA record with its TTL
+// lowered to the negative caching TTL: the lesser of the record's TTL
+// and its MINIMUM field (RFC 2308 section 5)
+func negativeSOA(response *dns.DNSMessage) (dns.DNSAnswer, bool) {
+	for _, rr := range response.Authorities {
+		if rr.Type != dns.TypeSOA || len(rr.RData) < soaMinimumOffset {
+			continue
+		}
+		minimum := binary.BigEndian.Uint32(rr.RData[len(rr.RData)-soaMinimumOffset:])
+		rr.TTL = min(rr.TTL, minimum)
+		return rr, true
+	}
+	return dns.DNSAnswer{}, false
+}
+
+// memoryEntry is a CacheEntry with its expiry time
+type memoryEntry struct {
+	CacheEntry
+	expires time.Time
+}
+
+// MemoryCache is the default Cache, holding at most size entries in
+// memory
+type MemoryCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemoryCache creates a cache holding at most size entries
+func NewMemoryCache(size int) *MemoryCache {
+	return &MemoryCache{
+		size:    size,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+// Get returns the entry stored under key unless it has expired
+func (c *MemoryCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(c.entries, key)
+		return CacheEntry{}, false
+	}
+	return entry.CacheEntry, true
+}
+
+// Set stores entry under key for ttl, evicting an entry if the cache
+// is full. Entries with no TTL are not stored.
+func (c *MemoryCache) Set(key string, entry CacheEntry, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		c.evict(now)
+	}
+	c.entries[key] = memoryEntry{CacheEntry: entry, expires: now.Add(ttl)}
+}
+
+// evict makes room for one entry, preferring expired ones. Called with
+// c.mu held.
+func (c *MemoryCache) evict(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.size {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// soaRecord returns the example.com SOA record with the given TTL and
+// MINIMUM field
+func soaRecord(ttl, minimum uint32) dns.DNSAnswer {
+	rdata := []byte("\x02ns\x07example\x03com\x00\x05admin\x07example\x03com\x00")
+	for _, field := range []uint32{1, 3600, 600, 86400, minimum} {
+		rdata = binary.BigEndian.AppendUint32(rdata, field)
+	}
+	return dns.DNSAnswer{Name: []byte("\x07example\x03com\x00"), Type: dns.TypeSOA, Class: dns.ClassIN,
+		TTL: ttl, RDLength: uint16(len(rdata)), RData: rdata}
+}
+
+// nxdomainResolver answers every query with NXDOMAIN, plus soa in the
+// authority section when given, counting the queries it sees
+func nxdomainResolver(queries *int, soa ...dns.DNSAnswer) resolverFunc {
+	return func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		*queries++
+		response := reply(query)
+		response.Header.SetRCode(dns.RCodeNXDomain)
+		for _, rr := range soa {
+			response.AddAuthority(rr)
+		}
+		return response, nil
+	}
+}
+
+// mapCache is a Cache backed by a plain map, recording its calls
+type mapCache struct {
+	mu      sync.Mutex
+	entries map[string]CacheEntry
+	gets    int
+	sets    int
+}
+
+// Get returns the entry stored under key
+func (c *mapCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets++
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+// Set stores entry under key, ignoring ttl
+func (c *mapCache) Set(key string, entry CacheEntry, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sets++
+	c.entries[key] = entry
+}
+
+func TestServerUsesPluggedCache(t *testing.T) {
+	var queries int
+	cache := &mapCache{entries: make(map[string]CacheEntry)}
+	server := newTestServer(t, WithCache(cache))
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		queries++
+		return reply(query, aRecord(t, "example.com", "192.0.2.1", 300)), nil
+	})
+
+	for range 2 {
+		response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+		if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.1" {
+			t.Fatalf("answers = %v, want [192.0.2.1]", ips)
+		}
+	}
+	if queries != 1 || cache.sets != 1 || cache.gets != 2 {
+		t.Errorf("%d upstream queries, %d cache sets, %d gets; want 1, 1, 2", queries, cache.sets, cache.gets)
+	}
+	if entry, ok := cache.entries[cacheKey(newQuery(t, "example.com", dns.TypeA))]; !ok || len(entry.Answers) != 1 {
+		t.Errorf("cache holds %+v under the question's key", cache.entries)
+	}
+}
+
+func TestCachedAnswersAreNotSharedWithResponses(t *testing.T) {
+	var queries, responses int
+	rewrite := func(next Handler) Handler {
+		return func(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+			response := next(ctx, request)
+			if responses++; responses == 1 {
+				for i := range response.Answers {
+					copy(response.Answers[i].RData, net.IPv4(198, 51, 100, 1).To4())
+				}
+			}
+			return response
+		}
+	}
+	server := newTestServer(t, WithCache(NewMemoryCache(16)), WithMiddleware(rewrite))
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		queries++
+		return reply(query, aRecord(t, "example.com", "192.0.2.1", 300)), nil
+	})
+
+	if ips := answerIPs(exchange(t, server, newQuery(t, "example.com", dns.TypeA))); len(ips) != 1 || ips[0] != "198.51.100.1" {
+		t.Fatalf("first answers = %v, want the rewritten [198.51.100.1]", ips)
+	}
+	if ips := answerIPs(exchange(t, server, newQuery(t, "example.com", dns.TypeA))); len(ips) != 1 || ips[0] != "192.0.2.1" {
+		t.Errorf("cached answers = %v, want the upstream's [192.0.2.1]", ips)
+	}
+	if queries != 1 {
+		t.Errorf("upstream saw %d queries, want 1", queries)
+	}
+}
+
+func TestNXDomainIsCachedForSOAMinimum(t *testing.T) {
+	var queries int
+	server := newTestServer(t, WithCache(NewMemoryCache(16)))
+	server.resolver = nxdomainResolver(&queries, soaRecord(3600, 300))
+
+	var response *dns.DNSMessage
+	for range 2 {
+		response = exchange(t, server, newQuery(t, "missing.example.com", dns.TypeA))
+		if rcode := response.Header.RCode(); rcode != dns.RCodeNXDomain {
+			t.Fatalf("RCODE = %d, want NXDOMAIN", rcode)
+		}
+	}
+	// The cached SOA carries the negative TTL, the lesser of its TTL and MINIMUM
+	if len(response.Authorities) != 1 || response.Authorities[0].TTL > 300 {
+		t.Errorf("cached authorities = %+v, want the SOA with TTL <= 300", response.Authorities)
+	}
+	if queries != 1 {
+		t.Errorf("upstream saw %d queries, want 1", queries)
+	}
+}
+
+func TestNXDomainWithoutSOAIsNotCached(t *testing.T) {
+	var queries int
+	server := newTestServer(t, WithCache(NewMemoryCache(16)))
+	server.resolver = nxdomainResolver(&queries)
+
+	for range 2 {
+		exchange(t, server, newQuery(t, "missing.example.com", dns.TypeA))
+	}
+	if queries != 2 {
+		t.Errorf("upstream saw %d queries, want 2", queries)
+	}
+}
+
+func TestZeroTTLIsNotCached(t *testing.T) {
+	var queries int
+	server := newTestServer(t, WithCache(NewMemoryCache(16)))
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		queries++
+		return reply(query,
+			aRecord(t, "example.com", "192.0.2.1", 300),
+			aRecord(t, "example.com", "192.0.2.2", 0)), nil
+	})
+
+	for range 2 {
+		response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+		if len(response.Answers) != 2 {
+			t.Fatalf("got %d answers, want 2", len(response.Answers))
+		}
+	}
+	if queries != 2 {
+		t.Errorf("upstream saw %d queries, want 2", queries)
+	}
+
+	cache := NewMemoryCache(16)
+	cache.Set("key", CacheEntry{RCode: dns.RCodeNoError}, 0)
+	if _, ok := cache.Get("key"); ok {
+		t.Error("MemoryCache stored an entry with no TTL")
+	}
+}
+
+func TestNameVariantsShareCacheEntry(t *testing.T) {
+	var queries int
+	server := newTestServer(t, WithCache(NewMemoryCache(16)))
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		queries++
+		return reply(query, aRecord(t, "example.com", "192.0.2.1", 300)), nil
+	})
+
+	for _, name := range []string{"Example.COM", "example.com.", "EXAMPLE.com"} {
+		response := exchange(t, server, newQuery(t, name, dns.TypeA))
+		if ips := answerIPs(response); len(ips) != 1 {
+			t.Fatalf("%s: answers = %v", name, ips)
+		}
+		if got, want := response.Questions[0].QName, newQuery(t, name, dns.TypeA).Questions[0].QName; !bytes.Equal(got, want) {
+			t.Errorf("%s: question came back as %q", name, got)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("upstream saw %d queries, want 1", queries)
+	}
+}
+
+func TestStaleAnswerServedWhenUpstreamFails(t *testing.T) {
+	query := newQuery(t, "example.com", dns.TypeA)
+	expired := CacheEntry{
+		RCode:   dns.RCodeNoError,
+		Answers: []dns.DNSAnswer{aRecord(t, "example.com", "192.0.2.1", 300)},
+		Stored:  time.Now().Add(-10 * time.Minute),
+		TTL:     5 * time.Minute,
+	}
+
+	for _, serveStale := range []time.Duration{0, time.Hour} {
+		cache := &mapCache{entries: map[string]CacheEntry{cacheKey(query): expired}}
+		server := newTestServer(t, WithCache(cache))
+		server.resolver = failingResolver
+		server.ServeStale = serveStale
+
+		response := exchange(t, server, query)
+		if serveStale == 0 {
+			if rcode := response.Header.RCode(); rcode != dns.RCodeServFail {
+				t.Errorf("without serve-stale: RCODE = %d, want SERVFAIL", rcode)
+			}
+			continue
+		}
+		if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+			t.Fatalf("serve-stale: RCODE = %d, want NOERROR", rcode)
+		}
+		if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.1" {
+			t.Errorf("serve-stale: answers = %v, want the stale [192.0.2.1]", ips)
+		}
+		if ttl := response.Answers[0].TTL; ttl != staleAnswerTTL {
+			t.Errorf("stale answer TTL = %d, want %d", ttl, staleAnswerTTL)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"math/rand/v2"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// 0x20 encoding (draft-vixie-dnsext-dns0x20): randomizing the case of the
+// letters in a forwarded QNAME adds entropy a spoofed response must guess,
+// since a well-behaved upstream echoes the question byte for byte.
+
+// randomizeCase returns a copy of a wire-format name with the case of
+// each letter flipped at random
+func randomizeCase(name []byte) []byte {
+	out := bytes.Clone(name)
+	for offset := 0; offset < len(out) && out[offset] != 0; offset += int(out[offset]) + 1 {
+		end := min(offset+1+int(out[offset]), len(out))
+		for i := offset + 1; i < end; i++ {
+			c := out[i] | 0x20
+			if c >= 'a' && c <= 'z' && rand.IntN(2) == 1 {
+				out[i] ^= 0x20
+			}
+		}
+	}
+	return out
+}
+
+// restoreCase rewrites every name in the response that the upstream
+// echoed in the randomized casing back to the client's original casing
+func restoreCase(response *dns.DNSMessage, sent, original []byte) {
+	for i := range response.Questions {
+		if bytes.Equal(response.Questions[i].QName, sent) {
+			response.Questions[i].QName = original
+		}
+	}
+	for _, section := range [][]dns.DNSAnswer{response.Answers, response.Authorities, response.Additionals} {
+		for i := range section {
+			if bytes.Equal(section[i].Name, sent) {
+				section[i].Name = original
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestRandomizeCaseOnlyChangesCase(t *testing.T) {
+	name := []byte("\x03www\x07example\x03com\x00")
+	changed := false
+	for range 20 {
+		randomized := randomizeCase(name)
+		if !bytes.EqualFold(randomized, name) {
+			t.Fatalf("randomizeCase(%q) = %q", name, randomized)
+		}
+		changed = changed || !bytes.Equal(randomized, name)
+	}
+	if !changed {
+		t.Error("20 randomizations left the name's case alone")
+	}
+}
+
+func TestRandomizedCaseMustBeEchoed(t *testing.T) {
+	var sent []byte
+	echo := resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		sent = query.Questions[0].QName
+		return reply(query, aRecord(t, "www.example.com", "192.0.2.1", 300)), nil
+	})
+	lowercase := resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		response := reply(query.Clone(), aRecord(t, "www.example.com", "192.0.2.1", 300))
+		response.Questions[0].QName = dns.CanonicalName(query.Questions[0].QName)
+		return response, nil
+	})
+
+	server := newTestServer(t)
+	server.RandomizeCase = true
+	// A name long enough that an all-lowercase randomization is unlikely
+	query := newQuery(t, "www.randomized-casing.example.com", dns.TypeA)
+
+	server.resolver = echo
+	response := exchange(t, server, query)
+	if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+		t.Fatalf("echoing upstream: RCODE = %d, want NOERROR", rcode)
+	}
+	if !bytes.EqualFold(sent, query.Questions[0].QName) {
+		t.Errorf("forwarded %q for %q", sent, query.Questions[0].QName)
+	}
+	if got := response.Questions[0].QName; !bytes.Equal(got, query.Questions[0].QName) {
+		t.Errorf("client's question came back as %q", got)
+	}
+
+	server.resolver = lowercase
+	if bytes.Equal(dns.CanonicalName(sent), sent) {
+		t.Skip("randomization produced an all-lowercase name")
+	}
+	response = exchange(t, server, query)
+	if rcode := response.Header.RCode(); rcode != dns.RCodeServFail {
+		t.Errorf("upstream ignoring the casing: RCODE = %d, want SERVFAIL", rcode)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// maxCNAMEChase bounds how many CNAMEs ChaseCNAME follows for a query
+const maxCNAMEChase = 8
+
+// chaseCNAME completes a forwarded response whose answer ends in a
+// CNAME without the records asked for, by looking up the CNAME's
+// target and appending what it returns. Lookups go through
+// forwardSingleQuery, so a cached target isn't asked of the upstream
+// again. The response takes the RCODE of the last query, so a
+// dangling target gives NXDOMAIN.
+func (s *DNSServer) chaseCNAME(ctx context.Context, request, response *dns.DNSMessage) {
+	q := request.Questions[0]
+	if !s.ChaseCNAME || q.QType == dns.TypeCNAME || q.QType == dns.TypeANY {
+		return
+	}
+
+	for hops := 0; hops < maxCNAMEChase && response.Header.RCode() == dns.RCodeNoError; hops++ {
+		target, ok := danglingCNAME(response.Answers, q)
+		if !ok {
+			return
+		}
+
+		query := dns.DNSMessage{
+			Header:      dns.DNSHeader{ID: request.Header.ID, Flags: request.Header.Flags},
+			Additionals: request.Additionals,
+		}
+		query.SyncCounts()
+		query.AddQuestion(dns.Question{QName: target, QType: q.QType, QClass: q.QClass})
+
+		next, err := s.forwardSingleQuery(ctx, &query)
+		if err != nil {
+			s.logf("Error chasing CNAME to %s: %v\n", dns.NameString(target), err)
+			return
+		}
+		for _, a := range next.Answers {
+			response.AddAnswer(a)
+		}
+		response.Header.SetRCode(next.Header.RCode())
+	}
+}
+
+// danglingCNAME follows the CNAME chain in answers from the question's
+// name and returns its target if no answer of the question's type
+// follows it
+func danglingCNAME(answers []dns.DNSAnswer, q dns.Question) ([]byte, bool) {
+	name := q.QName
+	for range answers {
+		var cname []byte
+		for _, a := range answers {
+			if !bytes.Equal(dns.CanonicalName(a.Name), dns.CanonicalName(name)) {
+				continue
+			}
+			if a.Type == q.QType {
+				return nil, false
+			}
+			if a.Type == dns.TypeCNAME {
+				cname = a.RData
+			}
+		}
+		if cname == nil {
+			break
+		}
+		name = cname
+	}
+
+	if bytes.Equal(name, q.QName) {
+		return nil, false
+	}
+	return name, true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestChaseCNAME(t *testing.T) {
+	owner, _ := dns.EncodeName("www.example.com")
+	target, _ := dns.EncodeName("web.example.net")
+	cname := dns.DNSAnswer{Name: owner, Type: dns.TypeCNAME, Class: dns.ClassIN, TTL: 300, RDLength: uint16(len(target)), RData: target}
+
+	var queried []string
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		name := query.Questions[0].Name()
+		queried = append(queried, name)
+		if name == "www.example.com" {
+			return reply(query, cname), nil
+		}
+		return reply(query, aRecord(t, name, "192.0.2.7", 300)), nil
+	})
+
+	tests := []struct {
+		chase   bool
+		queries int
+		types   []uint16
+	}{
+		{true, 2, []uint16{dns.TypeCNAME, dns.TypeA}},
+		{false, 1, []uint16{dns.TypeCNAME}},
+	}
+	for _, tt := range tests {
+		server.ChaseCNAME = tt.chase
+		queried = nil
+
+		response := exchange(t, server, newQuery(t, "www.example.com", dns.TypeA))
+		if len(queried) != tt.queries {
+			t.Errorf("chase %t: upstream queried for %v, want %d queries", tt.chase, queried, tt.queries)
+		}
+		if len(response.Answers) != len(tt.types) {
+			t.Fatalf("chase %t: %d answers, want %d", tt.chase, len(response.Answers), len(tt.types))
+		}
+		for i, a := range response.Answers {
+			if a.Type != tt.types[i] {
+				t.Errorf("chase %t: answer %d has type %d, want %d", tt.chase, i, a.Type, tt.types[i])
+			}
+		}
+		if tt.chase {
+			if queried[1] != "web.example.net" {
+				t.Errorf("second query was for %s, want the CNAME's target", queried[1])
+			}
+			if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.7" {
+				t.Errorf("answers = %v, want the target's 192.0.2.7", ips)
+			}
+		}
+	}
+}
+
+func TestChaseCNAMEUsesTheCache(t *testing.T) {
+	owner, _ := dns.EncodeName("www.example.com")
+	target, _ := dns.EncodeName("web.example.net")
+	cname := dns.DNSAnswer{Name: owner, Type: dns.TypeCNAME, Class: dns.ClassIN, TTL: 300, RDLength: uint16(len(target)), RData: target}
+
+	var queried []string
+	server := newTestServer(t, WithCache(NewMemoryCache(16)), WithChaseCNAME(true))
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		name := query.Questions[0].Name()
+		queried = append(queried, name)
+		if name == "www.example.com" {
+			return reply(query, cname), nil
+		}
+		return reply(query, aRecord(t, name, "192.0.2.7", 300)), nil
+	})
+
+	for i := 0; i < 2; i++ {
+		response := exchange(t, server, newQuery(t, "www.example.com", dns.TypeA))
+		if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.7" {
+			t.Errorf("query %d: answers = %v, want the target's 192.0.2.7", i, ips)
+		}
+	}
+	if len(queried) != 2 {
+		t.Errorf("upstream queried for %v, want only the first query's 2 lookups", queried)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DNS cookies (RFC 7873): a client sends an 8-byte client cookie and the
+// server echoes it followed by a server cookie derived from a server
+// secret, the client cookie and the client's address.
+const (
+	clientCookieLen    = 8
+	minServerCookieLen = 8
+	maxServerCookieLen = 32
+)
+
+// parseCookie splits a COOKIE option into its client and server cookies
+func parseCookie(data []byte) (client, server []byte, err error) {
+	serverLen := len(data) - clientCookieLen
+	if serverLen < 0 || (serverLen > 0 && (serverLen < minServerCookieLen || serverLen > maxServerCookieLen)) {
+		return nil, nil, fmt.Errorf("invalid cookie length %d", len(data))
+	}
+	return data[:clientCookieLen], data[clientCookieLen:], nil
+}
+
+// cookieSecretLen is the size of the random server secrets
+const cookieSecretLen = 16
+
+// cookieSecrets holds the secret server cookies are derived from and,
+// after a rotation, the secret it replaced, so cookies handed out just
+// before the rotation stay valid for one more interval
+type cookieSecrets struct {
+	mu       sync.Mutex
+	current  []byte
+	previous []byte
+	rotated  time.Time
+}
+
+// get returns the current and previous secrets, rotating them first if
+// interval has passed (a zero interval never rotates)
+func (c *cookieSecrets) get(interval time.Duration) (current, previous []byte) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.current == nil:
+		c.current = newCookieSecret()
+		c.rotated = now
+	case interval > 0 && now.Sub(c.rotated) >= 2*interval:
+		// Idle for a whole grace window: the old secrets are both stale
+		c.current, c.previous = newCookieSecret(), nil
+		c.rotated = now
+	case interval > 0 && now.Sub(c.rotated) >= interval:
+		c.current, c.previous = newCookieSecret(), c.current
+		c.rotated = now
+	}
+	return c.current, c.previous
+}
+
+// newCookieSecret returns a random server secret
+func newCookieSecret() []byte {
+	secret := make([]byte, cookieSecretLen)
+	rand.Read(secret)
+	return secret
+}
+
+// serverCookie derives the server cookie for a client cookie and address
+// from the current secret
+func (s *DNSServer) serverCookie(client []byte, addr net.Addr) []byte {
+	current, _ := s.cookies.get(s.CookieRotation)
+	return cookieFor(current, client, addr)
+}
+
+// validServerCookie reports whether a server cookie sent back by a
+// client is one we issued, under the current or the previous secret
+func (s *DNSServer) validServerCookie(client, server []byte, addr net.Addr) bool {
+	current, previous := s.cookies.get(s.CookieRotation)
+	if hmac.Equal(server, cookieFor(current, client, addr)) {
+		return true
+	}
+	return previous != nil && hmac.Equal(server, cookieFor(previous, client, addr))
+}
+
+// cookieFor derives a server cookie from a secret, the client cookie and
+// the client's address. Only the IP counts, whatever the transport, so
+// a client keeps its cookie when it retries over TCP.
+func cookieFor(secret, client []byte, addr net.Addr) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(client)
+	if addr != nil {
+		if addrPort, err := netip.ParseAddrPort(addr.String()); err == nil {
+			mac.Write(addrPort.Addr().Unmap().AsSlice())
+		}
+	}
+	return mac.Sum(nil)[:minServerCookieLen]
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// cookieQuery builds an EDNS query carrying a COOKIE option
+func cookieQuery(t *testing.T, cookie []byte) *dns.DNSMessage {
+	t.Helper()
+	query := newQuery(t, "example.com", dns.TypeA)
+	opt := &dns.OPT{UDPSize: 1232}
+	opt.SetOption(dns.OptionCookie, cookie)
+	query.SetOPT(opt)
+	return query
+}
+
+// responseCookie returns the COOKIE option of a response
+func responseCookie(t *testing.T, response *dns.DNSMessage) []byte {
+	t.Helper()
+	opt, err := response.OPT()
+	if err != nil || opt == nil {
+		t.Fatalf("response has no OPT record (err %v)", err)
+	}
+	cookie, ok := opt.Option(dns.OptionCookie)
+	if !ok {
+		t.Fatal("response has no COOKIE option")
+	}
+	return cookie
+}
+
+func TestClientCookieIsReflected(t *testing.T) {
+	server := newTestServer(t)
+	clientCookie := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	response := exchange(t, server, cookieQuery(t, clientCookie))
+	if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+		t.Fatalf("RCODE = %d, want NOERROR", rcode)
+	}
+	cookie := responseCookie(t, response)
+	if !bytes.Equal(cookie[:clientCookieLen], clientCookie) {
+		t.Errorf("client cookie echoed as %x, want %x", cookie[:clientCookieLen], clientCookie)
+	}
+	if len(cookie) != clientCookieLen+minServerCookieLen {
+		t.Errorf("cookie is %d bytes, want a %d-byte server cookie appended", len(cookie), minServerCookieLen)
+	}
+}
+
+func TestMalformedCookieIsFormErr(t *testing.T) {
+	server := newTestServer(t)
+
+	response := exchange(t, server, cookieQuery(t, []byte{1, 2, 3}))
+	if rcode := response.Header.RCode(); rcode != dns.RCodeFormErr {
+		t.Errorf("RCODE = %d, want FORMERR", rcode)
+	}
+}
+
+func TestCookieBindsClientAddress(t *testing.T) {
+	secret := newCookieSecret()
+	client := []byte("clientck")
+
+	udp := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5300}
+	tcp := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5400}
+	otherTCP := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5400}
+
+	if !bytes.Equal(cookieFor(secret, client, udp), cookieFor(secret, client, tcp)) {
+		t.Error("the same client gets different cookies over UDP and TCP")
+	}
+	if bytes.Equal(cookieFor(secret, client, tcp), cookieFor(secret, client, otherTCP)) {
+		t.Error("TCP clients at different addresses get the same cookie")
+	}
+}
+
+func TestServerCookieGraceWindow(t *testing.T) {
+	server := newTestServer(t)
+	server.CookieRotation = time.Hour
+	server.RequireCookies = true
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		return reply(query, aRecord(t, "example.com", "192.0.2.1", 300)), nil
+	})
+	clientCookie := []byte("clientck")
+
+	issued := responseCookie(t, exchange(t, server, cookieQuery(t, clientCookie)))
+	// rotate pretends an interval has passed, so the next lookup rotates
+	rotate := func() {
+		server.cookies.mu.Lock()
+		server.cookies.rotated = server.cookies.rotated.Add(-server.CookieRotation)
+		server.cookies.mu.Unlock()
+	}
+
+	// One rotation later the cookie is under the previous secret: still good
+	rotate()
+	response := exchange(t, server, cookieQuery(t, issued))
+	if rcode := response.RCode(); rcode != dns.RCodeNoError {
+		t.Fatalf("cookie within the grace window: RCODE = %d, want NOERROR", rcode)
+	}
+	if len(response.Answers) == 0 {
+		t.Error("cookie within the grace window: query wasn't answered")
+	}
+	fresh := responseCookie(t, response)
+	if bytes.Equal(fresh, issued) {
+		t.Error("the response after a rotation kept the old server cookie")
+	}
+
+	// Another rotation retires that secret too
+	rotate()
+	response = exchange(t, server, cookieQuery(t, issued))
+	if rcode := response.RCode(); rcode != dns.RCodeBadCookie {
+		t.Fatalf("cookie past the grace window: RCODE = %d, want BADCOOKIE", rcode)
+	}
+	if len(response.Answers) != 0 {
+		t.Error("a BADCOOKIE response carried answers")
+	}
+	retry := responseCookie(t, response)
+	if !bytes.Equal(retry[:clientCookieLen], clientCookie) || bytes.Equal(retry, issued) {
+		t.Errorf("BADCOOKIE carried cookie %x, want a fresh one for %x", retry, clientCookie)
+	}
+	if rcode := exchange(t, server, cookieQuery(t, retry)).RCode(); rcode != dns.RCodeNoError {
+		t.Errorf("retry with the fresh cookie: RCODE = %d, want NOERROR", rcode)
+	}
+
+	// Without RequireCookies a stale cookie is answered, with a fresh one
+	server.RequireCookies = false
+	rotate()
+	rotate()
+	response = exchange(t, server, cookieQuery(t, retry))
+	if rcode := response.RCode(); rcode != dns.RCodeNoError {
+		t.Errorf("stale cookie without RequireCookies: RCODE = %d, want NOERROR", rcode)
+	}
+	if bytes.Equal(responseCookie(t, response), retry) {
+		t.Error("stale cookie without RequireCookies wasn't replaced")
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// delay holds back a response by ResponseDelay, for a DelayProbability
+// fraction of queries, to exercise client retry behavior. It returns
+// early if ctx is done.
+func (s *DNSServer) delay(ctx context.Context) {
+	if s.ResponseDelay <= 0 {
+		return
+	}
+	if s.DelayProbability > 0 && rand.Float64() >= s.DelayProbability {
+		return
+	}
+
+	timer := time.NewTimer(s.ResponseDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
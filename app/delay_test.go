@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestResponseDelay(t *testing.T) {
+	server := newTestServer(t)
+	server.ResponseDelay = 100 * time.Millisecond
+	query := newQuery(t, "example.com", dns.TypeA)
+
+	start := time.Now()
+	exchange(t, server, query)
+	if elapsed := time.Since(start); elapsed < server.ResponseDelay {
+		t.Errorf("response took %v, want at least %v", elapsed, server.ResponseDelay)
+	}
+
+	// A probability too small to ever hit leaves responses alone
+	server.DelayProbability = 1e-12
+	start = time.Now()
+	exchange(t, server, query)
+	if elapsed := time.Since(start); elapsed >= server.ResponseDelay {
+		t.Errorf("unlucky query still took %v", elapsed)
+	}
+
+	// The delay gives way to the query's deadline
+	server.DelayProbability = 0
+	server.ResponseDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	server.HandleQuery(ctx, query.Encode())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled query took %v", elapsed)
+	}
+}
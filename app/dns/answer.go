@@ -3,10 +3,13 @@ package dns
 import (
 	"encoding/binary"
 	"fmt"
+	"net"
 )
 
 // DNSAnswer represents a DNS answer section
 type DNSAnswer struct {
+	// Name is always stored uncompressed (pointers are expanded by
+	// Parse), so a parsed record can be re-encoded into any message
 	Name     []byte
 	Type     uint16
 	Class    uint16
@@ -18,17 +21,23 @@ type DNSAnswer struct {
 // Parse extracts answer section from DNS message
 // Returns the number of bytes consumed
 func (a *DNSAnswer) Parse(data []byte, offset int) (int, error) {
-	// Parse name (can be compressed)
-	name, bytesConsumed, err := DecodeName(data, offset)
+	return a.parse(data, offset, nil)
+}
+
+// parse is Parse charging the decoding of the record's names to budget
+func (a *DNSAnswer) parse(data []byte, offset int, budget *nameBudget) (int, error) {
+	// Parse name (can be compressed) - decodeName expands any pointers
+	// so the stored name does not depend on this message's layout
+	name, bytesConsumed, err := decodeName(data, offset, budget)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse answer name: %v", err)
+		return 0, fmt.Errorf("failed to parse answer name: %w", err)
 	}
 
 	currentOffset := offset + bytesConsumed
 
 	// Need 10 more bytes: Type(2) + Class(2) + TTL(4) + RDLength(2)
 	if currentOffset+10 > len(data) {
-		return 0, fmt.Errorf("insufficient data for answer fields")
+		return 0, parseErrorf(Truncated, "insufficient data for answer fields")
 	}
 
 	a.Name = name
@@ -43,17 +52,92 @@ func (a *DNSAnswer) Parse(data []byte, offset int) (int, error) {
 
 	// Read RData
 	if currentOffset+int(a.RDLength) > len(data) {
-		return 0, fmt.Errorf("insufficient data for RData")
+		return 0, parseErrorf(Truncated, "insufficient data for RData")
 	}
 
-	a.RData = make([]byte, a.RDLength)
-	copy(a.RData, data[currentOffset:currentOffset+int(a.RDLength)])
+	rdata, err := expandRData(data, currentOffset, a.Type, int(a.RDLength), budget)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s RData: %w", TypeString(a.Type), err)
+	}
 	currentOffset += int(a.RDLength)
+	a.RData = rdata
+	a.RDLength = uint16(len(rdata))
 
 	return currentOffset, nil
 }
 
+// soaFixedSize is the length of the SOA fields after its two names:
+// SERIAL, REFRESH, RETRY, EXPIRE and MINIMUM
+const soaFixedSize = 20
+
+// expandRData copies the length bytes of RDATA at offset. The names in
+// NS, CNAME, PTR, MX and SOA RDATA may be compressed against the rest of
+// the message (RFC 1035 section 4.1.4), so they are expanded and the
+// record can be re-encoded into any other message. Empty RDATA, as in
+// UPDATE deletions, is left alone.
+func expandRData(data []byte, offset int, rtype uint16, length int, budget *nameBudget) ([]byte, error) {
+	end := offset + length
+	rdata := data[:end] // names mustn't run past the record
+
+	if length == 0 {
+		return []byte{}, nil
+	}
+
+	switch rtype {
+	case TypeNS, TypeCNAME, TypePTR:
+		name, n, err := decodeName(rdata, offset, budget)
+		if err != nil {
+			return nil, err
+		}
+		if n != length {
+			return nil, parseErrorf(BadRData, "%d bytes after the name", length-n)
+		}
+		return name, nil
+	case TypeMX:
+		if length < 3 {
+			return nil, parseErrorf(Truncated, "MX RData too short")
+		}
+		name, n, err := decodeName(rdata, offset+2, budget)
+		if err != nil {
+			return nil, err
+		}
+		if 2+n != length {
+			return nil, parseErrorf(BadRData, "%d bytes after the exchange", length-2-n)
+		}
+		return append(append([]byte{}, data[offset:offset+2]...), name...), nil
+	case TypeSOA:
+		mname, n1, err := decodeName(rdata, offset, budget)
+		if err != nil {
+			return nil, err
+		}
+		rname, n2, err := decodeName(rdata, offset+n1, budget)
+		if err != nil {
+			return nil, err
+		}
+		if n1+n2+soaFixedSize != length {
+			return nil, parseErrorf(BadRData, "SOA RData is %d bytes, not %d", length, n1+n2+soaFixedSize)
+		}
+		expanded := append(mname, rname...)
+		return append(expanded, data[end-soaFixedSize:end]...), nil
+	}
+
+	return append([]byte{}, data[offset:end]...), nil
+}
+
+// IP returns the address carried by an A or AAAA record; other types,
+// or RDATA of the wrong length, report false
+func (a DNSAnswer) IP() (net.IP, bool) {
+	switch {
+	case a.Type == TypeA && len(a.RData) == net.IPv4len:
+		return net.IP(a.RData).To16(), true
+	case a.Type == TypeAAAA && len(a.RData) == net.IPv6len:
+		return net.IP(a.RData), true
+	}
+	return nil, false
+}
+
 // Encode converts a DNS Answer to bytes
+// RDLENGTH is always written as len(RData); the RDLength field is ignored
 func (a *DNSAnswer) Encode() []byte {
 	// total length: Name + Type(2) + Class(2) + TTL(4) + RDLength(2) + RData
 	buf := make([]byte, len(a.Name)+10+len(a.RData))
@@ -68,9 +152,64 @@ func (a *DNSAnswer) Encode() []byte {
 	offset += 2
 	binary.BigEndian.PutUint32(buf[offset:], a.TTL)
 	offset += 4
-	binary.BigEndian.PutUint16(buf[offset:], a.RDLength)
+	binary.BigEndian.PutUint16(buf[offset:], uint16(len(a.RData)))
 	offset += 2
 	copy(buf[offset:], a.RData)
 
 	return buf
 }
+
+// appendCompressed appends the record to buf, compressing its owner
+// name and the names in its RDATA where RFC 3597 allows it
+func (a *DNSAnswer) appendCompressed(buf []byte, c *NameCompressor) []byte {
+	buf = c.AppendName(buf, a.Name)
+	buf = binary.BigEndian.AppendUint16(buf, a.Type)
+	buf = binary.BigEndian.AppendUint16(buf, a.Class)
+	buf = binary.BigEndian.AppendUint32(buf, a.TTL)
+
+	lengthAt := len(buf)
+	buf = binary.BigEndian.AppendUint16(buf, 0) // RDLENGTH, set below
+	buf = a.appendRData(buf, c)
+	binary.BigEndian.PutUint16(buf[lengthAt:], uint16(len(buf)-lengthAt-2))
+	return buf
+}
+
+// appendRData appends the record's RDATA to buf. The names in NS, CNAME,
+// PTR, MX and SOA RDATA are compressed, the only types RFC 3597 section
+// 4 lets us compress; anything malformed is written as is.
+func (a *DNSAnswer) appendRData(buf []byte, c *NameCompressor) []byte {
+	rdata := a.RData
+	switch a.Type {
+	case TypeNS, TypeCNAME, TypePTR:
+		if nameLength(rdata) == len(rdata) {
+			return c.AppendName(buf, rdata)
+		}
+	case TypeMX:
+		if len(rdata) > 2 && 2+nameLength(rdata[2:]) == len(rdata) {
+			return c.AppendName(append(buf, rdata[:2]...), rdata[2:])
+		}
+	case TypeSOA:
+		mname := nameLength(rdata)
+		rname := nameLength(rdata[mname:])
+		if mname > 0 && rname > 0 && mname+rname+soaFixedSize == len(rdata) {
+			buf = c.AppendName(buf, rdata[:mname])
+			buf = c.AppendName(buf, rdata[mname:mname+rname])
+			return append(buf, rdata[mname+rname:]...)
+		}
+	}
+	return append(buf, rdata...)
+}
+
+// nameLength returns the length of the uncompressed name data starts
+// with, or 0 if it doesn't start with one
+func nameLength(data []byte) int {
+	for offset := 0; offset < len(data); offset += int(data[offset]) + 1 {
+		if data[offset] == 0 {
+			return offset + 1
+		}
+		if data[offset] > 63 {
+			return 0
+		}
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestEncodeWritesActualRDLength(t *testing.T) {
+	answer := DNSAnswer{
+		Name:     mustName(t, "example.com"),
+		Type:     TypeTXT,
+		Class:    ClassIN,
+		TTL:      60,
+		RDLength: 2, // stale: RData was replaced
+		RData:    []byte("\x05hello"),
+	}
+
+	encoded := answer.Encode()
+	rdlengthAt := len(answer.Name) + 8
+	if got := binary.BigEndian.Uint16(encoded[rdlengthAt:]); got != 6 {
+		t.Errorf("encoded RDLENGTH = %d, want 6", got)
+	}
+	if len(encoded) != rdlengthAt+2+6 {
+		t.Errorf("encoded record is %d bytes, want %d", len(encoded), rdlengthAt+2+6)
+	}
+
+	// The same holds inside a message, which must parse back cleanly
+	msg := &DNSMessage{Header: DNSHeader{ID: 1, Flags: FlagQR}}
+	msg.AddAnswer(answer)
+	var parsed DNSMessage
+	if err := parsed.ParseComplete(msg.Encode()); err != nil {
+		t.Fatalf("ParseComplete: %v", err)
+	}
+	if got := parsed.Answers[0]; got.RDLength != 6 || string(got.RData) != "\x05hello" {
+		t.Errorf("parsed RDLength %d RData %q", got.RDLength, got.RData)
+	}
+}
+
+func TestAnswerIP(t *testing.T) {
+	tests := []struct {
+		name  string
+		rtype uint16
+		rdata []byte
+		want  string
+	}{
+		{"A", TypeA, []byte{192, 0, 2, 1}, "192.0.2.1"},
+		{"AAAA", TypeAAAA, net.ParseIP("2001:db8::1"), "2001:db8::1"},
+		{"MX", TypeMX, []byte{0, 10, 0}, ""},
+		{"short A", TypeA, []byte{192, 0, 2}, ""},
+		{"A with AAAA data", TypeA, net.ParseIP("2001:db8::1"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, ok := DNSAnswer{Type: tt.rtype, RData: tt.rdata}.IP()
+			if ok != (tt.want != "") {
+				t.Fatalf("IP() ok = %t, want %t", ok, tt.want != "")
+			}
+			if ok && ip.String() != tt.want {
+				t.Errorf("IP() = %v, want %s", ip, tt.want)
+			}
+		})
+	}
+}
+
+func TestRDataNamesAreExpanded(t *testing.T) {
+	// Each record follows a question for example.com at offset 12 and
+	// points back into it
+	tests := []struct {
+		rtype uint16
+		rdata string
+		want  string
+	}{
+		{TypeCNAME, "\x03web\xc0\x0c", "\x03web\x07example\x03com\x00"},
+		{TypeNS, "\xc0\x0c", "\x07example\x03com\x00"},
+		{TypeMX, "\x00\x0a\x04mail\xc0\x0c", "\x00\x0a\x04mail\x07example\x03com\x00"},
+		{TypeSOA, "\x02ns\xc0\x0c\x04host\xc0\x0c" + strings.Repeat("\x00\x00\x00\x01", 5),
+			"\x02ns\x07example\x03com\x00\x04host\x07example\x03com\x00" + strings.Repeat("\x00\x00\x00\x01", 5)},
+		{TypeTXT, "\x02\xc0\x0c", "\x02\xc0\x0c"}, // not a name
+	}
+	for _, tt := range tests {
+		packet := "\x00\x01\x81\x80\x00\x01\x00\x01\x00\x00\x00\x00" +
+			"\x07example\x03com\x00\x00\x01\x00\x01" +
+			"\xc0\x0c" + string(binary.BigEndian.AppendUint16(nil, tt.rtype)) + "\x00\x01\x00\x00\x00\x3c" +
+			string(binary.BigEndian.AppendUint16(nil, uint16(len(tt.rdata)))) + tt.rdata
+
+		var msg DNSMessage
+		if err := msg.ParseComplete([]byte(packet)); err != nil {
+			t.Errorf("%s: ParseComplete: %v", TypeString(tt.rtype), err)
+			continue
+		}
+		if a := msg.Answers[0]; string(a.RData) != tt.want || int(a.RDLength) != len(tt.want) {
+			t.Errorf("%s: RData %q (RDLength %d), want %q", TypeString(tt.rtype), a.RData, a.RDLength, tt.want)
+		}
+	}
+
+	// A name running past its record is an error, not a silent read
+	// into the next one
+	packet := "\x00\x01\x81\x80\x00\x00\x00\x01\x00\x00\x00\x00" +
+		"\x07example\x03com\x00\x00\x05\x00\x01\x00\x00\x00\x3c\x00\x02\x03web\x00"
+	var msg DNSMessage
+	if err := msg.ParseComplete([]byte(packet)); err == nil {
+		t.Error("a CNAME overrunning its RDLENGTH parsed")
+	}
+}
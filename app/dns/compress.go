@@ -0,0 +1,47 @@
+package dns
+
+// maxPointerOffset is the largest offset a 14-bit compression pointer
+// can reach
+const maxPointerOffset = 0x3FFF
+
+// NameCompressor remembers where names were written into a message so
+// later occurrences of the same name, or of a suffix of it, can be
+// replaced by a pointer (RFC 1035 section 4.1.4)
+type NameCompressor struct {
+	offsets map[string]int // wire-format suffix -> message offset
+}
+
+// NewNameCompressor returns a compressor for a single message
+func NewNameCompressor() *NameCompressor {
+	return &NameCompressor{offsets: make(map[string]int)}
+}
+
+// AppendName appends the uncompressed wire-format name to buf, the
+// message encoded so far, pointing at the longest suffix already
+// written. Suffixes are matched byte for byte, so the casing of every
+// name is preserved.
+func (c *NameCompressor) AppendName(buf, name []byte) []byte {
+	start := len(buf)
+
+	offset := 0
+	for offset < len(name) && name[offset] != 0 {
+		next := offset + int(name[offset]) + 1
+		if next >= len(name) {
+			// Malformed name, write the rest as is
+			break
+		}
+
+		suffix := string(name[offset:])
+		if ptr, ok := c.offsets[suffix]; ok {
+			buf = append(buf, name[:offset]...)
+			return append(buf, 0xC0|byte(ptr>>8), byte(ptr))
+		}
+		if start+offset <= maxPointerOffset {
+			c.offsets[suffix] = start + offset
+		}
+
+		offset = next
+	}
+
+	return append(buf, name...)
+}
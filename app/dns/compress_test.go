@@ -0,0 +1,30 @@
+package dns
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAnswerNamePointsToQuestion(t *testing.T) {
+	msg := testMessage(t)
+	data := msg.Encode()
+
+	answer := 12 + len(msg.Questions[0].QName) + 4
+	if got := data[answer : answer+2]; !bytes.Equal(got, []byte{0xC0, 0x0C}) {
+		t.Errorf("answer name = %x, want pointer c00c", got)
+	}
+	if want := answer + 2 + 10 + 4; len(data) != want {
+		t.Errorf("encoded %d bytes, want %d", len(data), want)
+	}
+}
+
+func TestCompressorPointsToSuffix(t *testing.T) {
+	c := NewNameCompressor()
+	buf := c.AppendName(make([]byte, 12), []byte("\x03www\x07example\x03com\x00"))
+	buf = c.AppendName(buf, []byte("\x04mail\x07example\x03com\x00"))
+
+	// example.com starts 4 bytes into the first name, at offset 16
+	if got := buf[29:]; !bytes.Equal(got, []byte("\x04mail\xc0\x10")) {
+		t.Errorf("second name = %q, want mail + pointer to 16", got)
+	}
+}
@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// OptionClientSubnet is the EDNS Client Subnet option (RFC 7871)
+const OptionClientSubnet uint16 = 8
+
+// Client subnet address families
+const (
+	FamilyIPv4 uint16 = 1
+	FamilyIPv6 uint16 = 2
+)
+
+// ClientSubnet is the decoded form of an EDNS Client Subnet option: the
+// network of the client a query is made on behalf of
+type ClientSubnet struct {
+	Family       uint16
+	SourcePrefix uint8 // leading bits of Address that are significant
+	ScopePrefix  uint8 // set in responses: bits the answer applies to
+	Address      net.IP
+}
+
+// ParseClientSubnet decodes the data of a client subnet option. The
+// address must have no more bytes than the source prefix needs and no
+// bits set beyond it (RFC 7871 section 6).
+func ParseClientSubnet(data []byte) (*ClientSubnet, error) {
+	if len(data) < 4 {
+		return nil, parseErrorf(Truncated, "client subnet option too short")
+	}
+
+	ecs := &ClientSubnet{
+		Family:       binary.BigEndian.Uint16(data[0:2]),
+		SourcePrefix: data[2],
+		ScopePrefix:  data[3],
+	}
+
+	size, err := ecs.addressSize()
+	if err != nil {
+		return nil, err
+	}
+
+	address := data[4:]
+	if len(address) != (int(ecs.SourcePrefix)+7)/8 {
+		return nil, fmt.Errorf("client subnet address is %d bytes for a /%d prefix", len(address), ecs.SourcePrefix)
+	}
+
+	ip := make(net.IP, size)
+	copy(ip, address)
+	if !ip.Mask(net.CIDRMask(int(ecs.SourcePrefix), size*8)).Equal(ip) {
+		return nil, fmt.Errorf("client subnet address has bits set beyond /%d", ecs.SourcePrefix)
+	}
+	ecs.Address = ip
+
+	return ecs, nil
+}
+
+// Encode returns the option data, with the address cut to the bytes
+// the source prefix covers and any bits beyond it cleared. It fails if
+// the address doesn't belong to the family or a prefix is longer than
+// the address.
+func (c *ClientSubnet) Encode() ([]byte, error) {
+	size, err := c.addressSize()
+	if err != nil {
+		return nil, err
+	}
+	address := c.Address.To4()
+	if c.Family == FamilyIPv6 {
+		address = c.Address.To16()
+	}
+	if address == nil {
+		return nil, fmt.Errorf("client subnet address %s is not in family %d", c.Address, c.Family)
+	}
+	address = address.Mask(net.CIDRMask(int(c.SourcePrefix), size*8))
+
+	data := make([]byte, 4, 4+len(address))
+	binary.BigEndian.PutUint16(data[0:2], c.Family)
+	data[2] = c.SourcePrefix
+	data[3] = c.ScopePrefix
+	return append(data, address[:(int(c.SourcePrefix)+7)/8]...), nil
+}
+
+// addressSize returns the address length of the subnet's family,
+// checking that both prefixes fit in it
+func (c *ClientSubnet) addressSize() (int, error) {
+	var size int
+	switch c.Family {
+	case FamilyIPv4:
+		size = net.IPv4len
+	case FamilyIPv6:
+		size = net.IPv6len
+	default:
+		return 0, fmt.Errorf("unknown client subnet family %d", c.Family)
+	}
+	if int(c.SourcePrefix) > size*8 || int(c.ScopePrefix) > size*8 {
+		return 0, fmt.Errorf("client subnet prefix longer than the address")
+	}
+	return size, nil
+}
+
+// String returns the subnet in CIDR form, e.g. "192.0.2.0/24"
+func (c *ClientSubnet) String() string {
+	return fmt.Sprintf("%s/%d", c.Address, c.SourcePrefix)
+}
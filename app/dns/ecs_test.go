@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientSubnetRoundTrip(t *testing.T) {
+	tests := []struct {
+		ecs  ClientSubnet
+		data string
+		cidr string
+	}{
+		{ClientSubnet{Family: FamilyIPv4, SourcePrefix: 24, Address: net.IPv4(192, 0, 2, 0)}, "\x00\x01\x18\x00\xc0\x00\x02", "192.0.2.0/24"},
+		{ClientSubnet{Family: FamilyIPv4, SourcePrefix: 0, Address: net.IPv4zero}, "\x00\x01\x00\x00", "0.0.0.0/0"},
+		{ClientSubnet{Family: FamilyIPv6, SourcePrefix: 56, Address: net.ParseIP("2001:db8:0:ff00::")}, "\x00\x02\x38\x00\x20\x01\x0d\xb8\x00\x00\xff", "2001:db8:0:ff00::/56"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			got, err := tt.ecs.Encode()
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if string(got) != tt.data {
+				t.Errorf("Encode() = %x, want %x", got, tt.data)
+			}
+			parsed, err := ParseClientSubnet([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("ParseClientSubnet: %v", err)
+			}
+			if parsed.String() != tt.cidr || parsed.Family != tt.ecs.Family {
+				t.Errorf("parsed %s family %d, want %s", parsed, parsed.Family, tt.cidr)
+			}
+		})
+	}
+}
+
+func TestClientSubnetRejectsMalformedData(t *testing.T) {
+	for name, data := range map[string]string{
+		"short":           "\x00\x01\x18",
+		"unknown family":  "\x00\x03\x00\x00",
+		"long prefix":     "\x00\x01\x21\x00\xc0\x00\x02\x00\x00",
+		"too many bytes":  "\x00\x01\x08\x00\xc0\x00",
+		"bits past /23":   "\x00\x01\x17\x00\xc0\x00\x03",
+		"scope too large": "\x00\x01\x00\x21",
+	} {
+		if _, err := ParseClientSubnet([]byte(data)); err == nil {
+			t.Errorf("%s: ParseClientSubnet accepted %x", name, data)
+		}
+	}
+}
+
+func TestClientSubnetEncodeRejectsInvalidSubnets(t *testing.T) {
+	for name, ecs := range map[string]ClientSubnet{
+		"unknown family":     {Family: 3, SourcePrefix: 8, Address: net.IPv4(192, 0, 2, 0)},
+		"IPv6 in IPv4":       {Family: FamilyIPv4, SourcePrefix: 24, Address: net.ParseIP("2001:db8::")},
+		"no address":         {Family: FamilyIPv4, SourcePrefix: 24},
+		"long source prefix": {Family: FamilyIPv4, SourcePrefix: 33, Address: net.IPv4(192, 0, 2, 0)},
+		"long scope prefix":  {Family: FamilyIPv6, ScopePrefix: 129, Address: net.ParseIP("2001:db8::")},
+	} {
+		if data, err := ecs.Encode(); err == nil {
+			t.Errorf("%s: Encode() = %x, want an error", name, data)
+		}
+	}
+
+	// Bits past the source prefix are cleared so the data parses
+	ecs := ClientSubnet{Family: FamilyIPv4, SourcePrefix: 23, Address: net.IPv4(192, 0, 3, 7)}
+	data, err := ecs.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if parsed, err := ParseClientSubnet(data); err != nil || parsed.String() != "192.0.2.0/23" {
+		t.Errorf("parsed %v, %v; want 192.0.2.0/23", parsed, err)
+	}
+}
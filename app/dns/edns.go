@@ -0,0 +1,218 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EDNS option codes
+const (
+	OptionNSID    uint16 = 3 // name server identifier (RFC 5001)
+	OptionCookie  uint16 = 10
+	OptionPadding uint16 = 12
+)
+
+// EDNS header flags
+const (
+	FlagDO uint16 = 0x8000 // DNSSEC OK (RFC 3225)
+)
+
+// Extended response codes, only expressible with an OPT record
+const (
+	RCodeBadVers   uint16 = 16 // unsupported EDNS version (RFC 6891)
+	RCodeBadCookie uint16 = 23 // bad or missing server cookie (RFC 7873)
+)
+
+// EDNSOption is a single option carried in an OPT record
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// OPT is the decoded form of an EDNS0 OPT pseudo-record (RFC 6891)
+type OPT struct {
+	UDPSize       uint16 // requestor's UDP payload size (the record's CLASS)
+	ExtendedRCode uint8  // upper 8 bits of the 12-bit RCODE
+	Version       uint8
+	Flags         uint16 // DO is the top bit
+	Options       []EDNSOption
+}
+
+// ParseOPT decodes an OPT record from its resource record form
+func ParseOPT(rr DNSAnswer) (*OPT, error) {
+	if rr.Type != TypeOPT {
+		return nil, fmt.Errorf("record type %d is not OPT", rr.Type)
+	}
+
+	opt := &OPT{
+		UDPSize:       rr.Class,
+		ExtendedRCode: uint8(rr.TTL >> 24),
+		Version:       uint8(rr.TTL >> 16),
+		Flags:         uint16(rr.TTL),
+	}
+
+	data := rr.RData
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, parseErrorf(Truncated, "truncated EDNS option header")
+		}
+		code := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if 4+length > len(data) {
+			return nil, parseErrorf(Truncated, "EDNS option %d overruns record", code)
+		}
+		opt.Options = append(opt.Options, EDNSOption{Code: code, Data: data[4 : 4+length]})
+		data = data[4+length:]
+	}
+
+	return opt, nil
+}
+
+// Record encodes the OPT as an additional-section resource record
+func (o *OPT) Record() DNSAnswer {
+	var rdata []byte
+	for _, option := range o.Options {
+		rdata = binary.BigEndian.AppendUint16(rdata, option.Code)
+		rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(option.Data)))
+		rdata = append(rdata, option.Data...)
+	}
+
+	return DNSAnswer{
+		Name:     []byte{0}, // root
+		Type:     TypeOPT,
+		Class:    o.UDPSize,
+		TTL:      uint32(o.ExtendedRCode)<<24 | uint32(o.Version)<<16 | uint32(o.Flags),
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}
+}
+
+// DO reports whether the DNSSEC OK bit is set
+func (o *OPT) DO() bool {
+	return o.Flags&FlagDO != 0
+}
+
+// Option returns the data of the first option with the given code
+func (o *OPT) Option(code uint16) ([]byte, bool) {
+	for _, option := range o.Options {
+		if option.Code == code {
+			return option.Data, true
+		}
+	}
+	return nil, false
+}
+
+// SetOption replaces the option with the given code, or adds it
+func (o *OPT) SetOption(code uint16, data []byte) {
+	for i := range o.Options {
+		if o.Options[i].Code == code {
+			o.Options[i].Data = data
+			return
+		}
+	}
+	o.Options = append(o.Options, EDNSOption{Code: code, Data: data})
+}
+
+// knownOptions are the EDNS options this package has support for
+var knownOptions = map[uint16]bool{
+	OptionNSID:         true,
+	OptionCookie:       true,
+	OptionPadding:      true,
+	OptionClientSubnet: true,
+}
+
+// RemoveUnknownOptions drops every option this package has no support
+// for
+func (o *OPT) RemoveUnknownOptions() {
+	kept := o.Options[:0]
+	for _, option := range o.Options {
+		if knownOptions[option.Code] {
+			kept = append(kept, option)
+		}
+	}
+	o.Options = kept
+}
+
+// RemoveOption drops every option with the given code
+func (o *OPT) RemoveOption(code uint16) {
+	kept := o.Options[:0]
+	for _, option := range o.Options {
+		if option.Code != code {
+			kept = append(kept, option)
+		}
+	}
+	o.Options = kept
+}
+
+// OPT returns the message's decoded OPT record, or nil if it has none
+func (msg *DNSMessage) OPT() (*OPT, error) {
+	for _, rr := range msg.Additionals {
+		if rr.Type == TypeOPT {
+			return ParseOPT(rr)
+		}
+	}
+	return nil, nil
+}
+
+// SetOPT replaces the message's OPT record; nil removes it.
+// ARCount is updated to match the additional section.
+func (msg *DNSMessage) SetOPT(opt *OPT) {
+	additionals := make([]DNSAnswer, 0, len(msg.Additionals)+1)
+	for _, rr := range msg.Additionals {
+		if rr.Type != TypeOPT {
+			additionals = append(additionals, rr)
+		}
+	}
+	if opt != nil {
+		additionals = append(additionals, opt.Record())
+	}
+
+	msg.Additionals = additionals
+	msg.Header.ARCount = uint16(len(additionals))
+}
+
+// RCode returns the full 12-bit response code: the header's 4 bits,
+// extended by the OPT record's upper 8 bits when one is present
+func (msg *DNSMessage) RCode() uint16 {
+	rcode := msg.Header.RCode()
+	if opt, err := msg.OPT(); err == nil && opt != nil {
+		rcode |= uint16(opt.ExtendedRCode) << 4
+	}
+	return rcode
+}
+
+// SetRCode sets the full 12-bit response code, splitting it between the
+// header and the OPT record. An OPT record is added if the code needs
+// one and the message has none.
+func (msg *DNSMessage) SetRCode(rcode uint16) {
+	msg.Header.SetRCode(rcode)
+
+	opt, err := msg.OPT()
+	if err != nil || (opt == nil && rcode <= 0x0F) {
+		return
+	}
+	if opt == nil {
+		opt = &OPT{UDPSize: 512}
+	}
+	opt.ExtendedRCode = uint8(rcode >> 4)
+	msg.SetOPT(opt)
+}
+
+// Pad adds a PADDING option (RFC 7830) to the message's OPT record so
+// the encoded message is a multiple of blockSize bytes. Messages without
+// an OPT record are left alone.
+func (msg *DNSMessage) Pad(blockSize int) {
+	opt, err := msg.OPT()
+	if err != nil || opt == nil || blockSize <= 0 {
+		return
+	}
+
+	opt.RemoveOption(OptionPadding)
+	msg.SetOPT(opt)
+
+	// The option itself costs a 4-byte header before any padding
+	length := len(msg.Encode()) + 4
+	padding := (blockSize - length%blockSize) % blockSize
+	opt.SetOption(OptionPadding, make([]byte, padding))
+	msg.SetOPT(opt)
+}
@@ -0,0 +1,38 @@
+package dns
+
+import "testing"
+
+func TestExtendedRCode(t *testing.T) {
+	// Header RCODE 3 (NXDOMAIN) with extended bits 0x01 in the OPT TTL
+	packet := []byte("\x12\x34\x81\x83\x00\x00\x00\x00\x00\x00\x00\x01" +
+		"\x00\x00\x29\x04\xd0\x01\x00\x00\x00\x00\x00")
+	var msg DNSMessage
+	if err := msg.ParseComplete(packet); err != nil {
+		t.Fatalf("ParseComplete: %v", err)
+	}
+	if rcode := msg.RCode(); rcode != 0x13 {
+		t.Errorf("RCode() = %#x, want 0x13", rcode)
+	}
+
+	tests := []struct {
+		rcode   uint16
+		wantOPT bool
+	}{
+		{RCodeNXDomain, false},
+		{RCodeBadVers, true},
+		{0xABC, true},
+	}
+	for _, tt := range tests {
+		msg := testMessage(t)
+		msg.SetRCode(tt.rcode)
+		if got := msg.RCode(); got != tt.rcode {
+			t.Errorf("SetRCode(%d): RCode() = %d", tt.rcode, got)
+		}
+		if got := msg.Header.RCode(); got != tt.rcode&0x0F {
+			t.Errorf("SetRCode(%d): header RCODE = %d, want %d", tt.rcode, got, tt.rcode&0x0F)
+		}
+		if opt, _ := msg.OPT(); (opt != nil) != tt.wantOPT {
+			t.Errorf("SetRCode(%d): has OPT = %t, want %t", tt.rcode, opt != nil, tt.wantOPT)
+		}
+	}
+}
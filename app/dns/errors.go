@@ -0,0 +1,53 @@
+package dns
+
+import "fmt"
+
+// ParseErrorKind classifies why a message failed to parse
+type ParseErrorKind int
+
+const (
+	// Truncated means the data ended before a field it declares
+	Truncated ParseErrorKind = iota + 1
+	// InvalidLabel means a name used a reserved label type
+	InvalidLabel
+	// BadPointer means a compression pointer pointed outside the
+	// message or into a loop
+	BadPointer
+	// TooLong means a name exceeded 255 bytes
+	TooLong
+	// BadRData means a record's RDATA didn't fill its declared length
+	BadRData
+)
+
+var parseErrorKindNames = map[ParseErrorKind]string{
+	Truncated:    "truncated",
+	InvalidLabel: "invalid label",
+	BadPointer:   "bad pointer",
+	TooLong:      "too long",
+	BadRData:     "bad RDATA",
+}
+
+// String returns the kind's name
+func (k ParseErrorKind) String() string {
+	if name, ok := parseErrorKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("kind %d", int(k))
+}
+
+// ParseError is returned by the parse functions, so callers can tell
+// failure modes apart with errors.As
+type ParseError struct {
+	Kind ParseErrorKind
+	Msg  string
+}
+
+// Error returns the error's message
+func (e *ParseError) Error() string {
+	return e.Msg
+}
+
+// parseErrorf creates a ParseError of the given kind
+func parseErrorf(kind ParseErrorKind, format string, args ...any) *ParseError {
+	return &ParseError{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseErrorKinds(t *testing.T) {
+	header := []byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00")
+	longName := append(bytes.Repeat(append([]byte{63}, bytes.Repeat([]byte("a"), 63)...), 5), 0)
+
+	tests := []struct {
+		name     string
+		question []byte
+		want     ParseErrorKind
+	}{
+		{"cut name", []byte("\x03ww"), Truncated},
+		{"cut type", []byte("\x03www\x00\x00"), Truncated},
+		{"reserved label", []byte("\x80abc\x00\x00\x01\x00\x01"), InvalidLabel},
+		{"pointer past end", []byte("\xc0\xff\x00\x01\x00\x01"), BadPointer},
+		{"pointer loop", []byte("\xc0\x0c\x00\x01\x00\x01"), BadPointer},
+		{"long name", append(longName, 0, 1, 0, 1), TooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg DNSMessage
+			err := msg.Parse(append(bytes.Clone(header), tt.question...))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Parse() error = %v, want a *ParseError", err)
+			}
+			if parseErr.Kind != tt.want {
+				t.Errorf("kind = %v, want %v (%v)", parseErr.Kind, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRDataLengthMismatchIsParseError(t *testing.T) {
+	header := []byte("\x12\x34\x81\x00\x00\x00\x00\x01\x00\x00\x00\x00")
+	owner := []byte("\x03www\x00")
+	fixed := func(rtype byte, length int) []byte {
+		return []byte{0, rtype, 0, 1, 0, 0, 0, 60, 0, byte(length)}
+	}
+	soa := append([]byte("\x00\x00"), bytes.Repeat([]byte{0}, 19)...) // one byte short
+
+	tests := []struct {
+		name   string
+		record []byte
+	}{
+		{"CNAME", append(fixed(byte(TypeCNAME), 3), "\x00\x00\x00"...)},
+		{"MX", append(fixed(byte(TypeMX), 4), "\x00\x0a\x00\x00"...)},
+		{"SOA", append(fixed(byte(TypeSOA), len(soa)), soa...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(append(bytes.Clone(header), owner...), tt.record...)
+			var msg DNSMessage
+			err := msg.ParseComplete(data)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseComplete() error = %v, want a *ParseError", err)
+			}
+			if parseErr.Kind != BadRData {
+				t.Errorf("kind = %v, want %v (%v)", parseErr.Kind, BadRData, err)
+			}
+		})
+	}
+}
+
+// pointerChain returns a query of n questions, each of whose names is a
+// pointer to the previous question's name, so that decoding question i
+// follows i pointers
+func pointerChain(n int) []byte {
+	data := []byte{0x12, 0x34, 0x01, 0x00, byte(n >> 8), byte(n), 0, 0, 0, 0, 0, 0}
+	data = append(data, 0, 0, 1, 0, 1) // the root
+	for prev := 12; len(data) < 12+5+6*(n-1); prev = len(data) - 6 {
+		data = append(data, 0xc0|byte(prev>>8), byte(prev), 0, 1, 0, 1)
+	}
+	return data
+}
+
+func TestPointerChainsExhaustMessageBudget(t *testing.T) {
+	// A few chained names parse: the budget is per byte of message
+	var msg DNSMessage
+	if err := msg.Parse(pointerChain(16)); err != nil {
+		t.Fatalf("Parse() of 16 chained names: %v", err)
+	}
+
+	// Every name here stays under walkName's own limit of 1000 steps,
+	// but together they follow a pointer count quadratic in the size
+	// of the packet, which only the message-wide budget catches
+	data := pointerChain(900)
+	var parseErr *ParseError
+	if err := msg.Parse(data); !errors.As(err, &parseErr) || parseErr.Kind != BadPointer {
+		t.Fatalf("Parse() of 900 chained names: error = %v, want a BadPointer ParseError", err)
+	}
+	if err := msg.ParseComplete(data); err == nil {
+		t.Error("ParseComplete() accepted 900 chained names")
+	}
+}
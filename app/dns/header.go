@@ -2,7 +2,32 @@ package dns
 
 import (
 	"encoding/binary"
-	"fmt"
+)
+
+// Response codes
+const (
+	RCodeNoError  uint16 = 0
+	RCodeFormErr  uint16 = 1
+	RCodeServFail uint16 = 2
+	RCodeNXDomain uint16 = 3
+	RCodeNotImp   uint16 = 4
+	RCodeRefused  uint16 = 5
+)
+
+// Header flag bits and fields (RFC 1035 4.1.1, RFC 4035 for AD/CD)
+const (
+	FlagQR uint16 = 0x8000 // response
+	FlagAA uint16 = 0x0400 // authoritative answer
+	FlagTC uint16 = 0x0200 // truncated
+	FlagRD uint16 = 0x0100 // recursion desired
+	FlagRA uint16 = 0x0080 // recursion available
+	FlagZ  uint16 = 0x0040 // reserved, must be zero
+	FlagAD uint16 = 0x0020 // authentic data
+	FlagCD uint16 = 0x0010 // checking disabled
+
+	OpcodeMask  uint16 = 0x7800 // OPCODE, bits 11-14
+	opcodeShift        = 11
+	RCodeMask   uint16 = 0x000F // header RCODE, bits 0-3
 )
 
 // DNSHeader represents the DNS header section
@@ -18,7 +43,7 @@ type DNSHeader struct {
 // Parse extracts DNS header fields from the first 12 bytes
 func (h *DNSHeader) Parse(data []byte) error {
 	if len(data) < 12 {
-		return fmt.Errorf("data too short for header")
+		return parseErrorf(Truncated, "data too short for header")
 	}
 
 	h.ID = binary.BigEndian.Uint16(data[0:2])
@@ -32,25 +57,25 @@ func (h *DNSHeader) Parse(data []byte) error {
 
 // BuildResponse creates a response header based on the request
 func (h *DNSHeader) BuildResponse() DNSHeader {
-	// Extract OPCODE from request flags (bits 11-14)
-	opcode := (h.Flags >> 11) & 0x0F
+	opcode := h.Opcode()
 
 	// Determine RCODE based on OPCODE
 	var rcode uint16
 	if opcode != 0 {
-		rcode = 4 // Not implemented for non-standard queries
+		rcode = RCodeNotImp // Not implemented for non-standard queries
 	} else {
-		rcode = 0 // No error for standard queries
+		rcode = RCodeNoError // No error for standard queries
 	}
 
 	// Build response flags:
 	// QR=1 (response), OPCODE from request, AA=0, TC=0, RD from request
-	// RA=0, Z=0, RCODE as determined above
+	// RA=0, Z=0 (even if the query set it), AD=0, CD from request, RCODE as determined above
 	var flags uint16
-	flags |= 1 << 15            // QR = 1 (response)
-	flags |= opcode << 11       // OPCODE from request
-	flags |= (h.Flags & 0x0100) // Copy RD (recursion desired)
-	flags |= rcode              // Set RCODE (bits 0-3)
+	flags |= FlagQR                         // QR = 1 (response)
+	flags |= (opcode & 0x0F) << opcodeShift // OPCODE from request (4 bits)
+	flags |= h.Flags & FlagRD               // Copy RD (recursion desired)
+	flags |= h.Flags & FlagCD               // Copy CD (checking disabled, RFC 6840 5.7)
+	flags |= rcode & RCodeMask              // Set RCODE (bits 0-3)
 
 	return DNSHeader{
 		ID:      h.ID,
@@ -62,14 +87,47 @@ func (h *DNSHeader) BuildResponse() DNSHeader {
 	}
 }
 
+// IsResponse reports whether the QR bit is set
+func (h *DNSHeader) IsResponse() bool {
+	return h.Flags&FlagQR != 0
+}
+
+// Truncated reports whether the TC bit is set
+func (h *DNSHeader) Truncated() bool {
+	return h.Flags&FlagTC != 0
+}
+
+// Opcode returns the OPCODE field (bits 11-14)
+func (h *DNSHeader) Opcode() uint16 {
+	return h.Flags & OpcodeMask >> opcodeShift
+}
+
+// RecursionDesired reports whether the RD bit is set
+func (h *DNSHeader) RecursionDesired() bool {
+	return h.Flags&FlagRD != 0
+}
+
+// RCode returns the response code (bits 0-3)
+func (h *DNSHeader) RCode() uint16 {
+	return h.Flags & RCodeMask
+}
+
+// SetRCode replaces the response code (bits 0-3)
+func (h *DNSHeader) SetRCode(rcode uint16) {
+	h.Flags = h.Flags&^RCodeMask | rcode&RCodeMask
+}
+
 // Encode converts a DNS header to bytes (12 bytes)
 func (h *DNSHeader) Encode() []byte {
-	buf := make([]byte, 12)
-	binary.BigEndian.PutUint16(buf[0:2], h.ID)
-	binary.BigEndian.PutUint16(buf[2:4], h.Flags)
-	binary.BigEndian.PutUint16(buf[4:6], h.QDCount)
-	binary.BigEndian.PutUint16(buf[6:8], h.ANCount)
-	binary.BigEndian.PutUint16(buf[8:10], h.NSCount)
-	binary.BigEndian.PutUint16(buf[10:12], h.ARCount)
-	return buf
+	return h.appendTo(make([]byte, 0, 12))
+}
+
+// appendTo appends the 12 header bytes to buf
+func (h *DNSHeader) appendTo(buf []byte) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, h.ID)
+	buf = binary.BigEndian.AppendUint16(buf, h.Flags)
+	buf = binary.BigEndian.AppendUint16(buf, h.QDCount)
+	buf = binary.BigEndian.AppendUint16(buf, h.ANCount)
+	buf = binary.BigEndian.AppendUint16(buf, h.NSCount)
+	return binary.BigEndian.AppendUint16(buf, h.ARCount)
 }
@@ -0,0 +1,70 @@
+package dns
+
+import "testing"
+
+func TestHeaderFlagFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags  uint16
+		qr     bool
+		opcode uint16
+		rd     bool
+		rcode  uint16
+	}{
+		{"plain query", FlagRD, false, OpcodeQuery, true, RCodeNoError},
+		{"update", OpcodeUpdate << opcodeShift, false, OpcodeUpdate, false, RCodeNoError},
+		{"highest opcode", 0x7800, false, 15, false, RCodeNoError},
+		{"NXDOMAIN response", FlagQR | FlagRD | FlagRA | RCodeNXDomain, true, OpcodeQuery, true, RCodeNXDomain},
+		{"everything set", 0xFFFF, true, 15, true, 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := DNSHeader{ID: 0xBEEF, Flags: tt.flags, QDCount: 1}
+			var parsed DNSHeader
+			if err := parsed.Parse(h.Encode()); err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if parsed != h {
+				t.Errorf("round trip = %+v, want %+v", parsed, h)
+			}
+			if parsed.IsResponse() != tt.qr || parsed.Opcode() != tt.opcode ||
+				parsed.RecursionDesired() != tt.rd || parsed.RCode() != tt.rcode {
+				t.Errorf("QR %t opcode %d RD %t RCODE %d, want %t %d %t %d",
+					parsed.IsResponse(), parsed.Opcode(), parsed.RecursionDesired(), parsed.RCode(),
+					tt.qr, tt.opcode, tt.rd, tt.rcode)
+			}
+		})
+	}
+}
+
+func TestBuildResponseHeaderKeepsFieldsApart(t *testing.T) {
+	for opcode := uint16(0); opcode <= 15; opcode++ {
+		query := DNSHeader{ID: 7, Flags: opcode<<opcodeShift | FlagRD | FlagCD, QDCount: 1}
+		response := query.BuildResponse()
+
+		wantRCode := RCodeNotImp
+		if opcode == OpcodeQuery {
+			wantRCode = RCodeNoError
+		}
+		if !response.IsResponse() || response.Opcode() != opcode || response.RCode() != wantRCode {
+			t.Errorf("opcode %d: response QR %t opcode %d RCODE %d", opcode, response.IsResponse(), response.Opcode(), response.RCode())
+		}
+		if response.Flags&(FlagRD|FlagCD) != FlagRD|FlagCD {
+			t.Errorf("opcode %d: RD or CD not copied: flags %#04x", opcode, response.Flags)
+		}
+	}
+
+	// An RCODE too wide for the header must not spill into other fields
+	h := DNSHeader{Flags: FlagQR | FlagRD}
+	h.SetRCode(0x1F3)
+	if h.Flags != FlagQR|FlagRD|0x3 {
+		t.Errorf("SetRCode(0x1F3) flags = %#04x, want %#04x", h.Flags, FlagQR|FlagRD|0x3)
+	}
+}
+
+func TestBuildResponseClearsZ(t *testing.T) {
+	query := DNSHeader{ID: 7, Flags: 0xFFFF &^ FlagQR, QDCount: 1}
+	if response := query.BuildResponse(); response.Flags&FlagZ != 0 {
+		t.Errorf("response to a query with every flag set has Z set: %#04x", response.Flags)
+	}
+}
@@ -1,10 +1,23 @@
 package dns
 
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+)
+
+// defaultAnswerIP is the dummy answer given when no zone is configured
+var defaultAnswerIP = net.IPv4(8, 8, 8, 8)
+
 // DNSMessage represents a complete DNS message
 type DNSMessage struct {
-	Header    DNSHeader
-	Questions []Question
-	Answers   []DNSAnswer
+	Header      DNSHeader
+	Questions   []Question
+	Answers     []DNSAnswer
+	Authorities []DNSAnswer
+	Additionals []DNSAnswer
 }
 
 // Parse extracts a complete DNS message from bytes
@@ -16,11 +29,12 @@ func (msg *DNSMessage) Parse(data []byte) error {
 
 	// Parse questions
 	offset := 12 // Start after header
-	msg.Questions = make([]Question, 0, msg.Header.QDCount)
+	budget := newNameBudget(data)
+	msg.Questions = make([]Question, 0, capacityHint(data, offset, msg.Header.QDCount, minQuestionSize))
 
 	for i := uint16(0); i < msg.Header.QDCount; i++ {
 		var q Question
-		bytesRead, err := q.Parse(data, offset)
+		bytesRead, err := q.parse(data, offset, budget)
 		if err != nil {
 			return err
 		}
@@ -31,7 +45,8 @@ func (msg *DNSMessage) Parse(data []byte) error {
 	return nil
 }
 
-// ParseComplete parses a full DNS message including answers
+// ParseComplete parses a full DNS message including answers,
+// authority and additional records
 func (msg *DNSMessage) ParseComplete(data []byte) error {
 	// Parse header
 	if err := msg.Header.Parse(data); err != nil {
@@ -40,11 +55,12 @@ func (msg *DNSMessage) ParseComplete(data []byte) error {
 
 	// Parse questions
 	offset := 12 // Start after header
-	msg.Questions = make([]Question, 0, msg.Header.QDCount)
+	budget := newNameBudget(data)
+	msg.Questions = make([]Question, 0, capacityHint(data, offset, msg.Header.QDCount, minQuestionSize))
 
 	for i := uint16(0); i < msg.Header.QDCount; i++ {
 		var q Question
-		bytesRead, err := q.Parse(data, offset)
+		bytesRead, err := q.parse(data, offset, budget)
 		if err != nil {
 			return err
 		}
@@ -52,62 +68,379 @@ func (msg *DNSMessage) ParseComplete(data []byte) error {
 		offset = bytesRead
 	}
 
-	// Parse answers
-	msg.Answers = make([]DNSAnswer, 0, msg.Header.ANCount)
-	for i := uint16(0); i < msg.Header.ANCount; i++ {
+	// Parse answers, authority and additional records
+	var err error
+	if msg.Answers, offset, err = parseRecords(data, offset, msg.Header.ANCount, budget); err != nil {
+		return err
+	}
+	if msg.Authorities, offset, err = parseRecords(data, offset, msg.Header.NSCount, budget); err != nil {
+		return err
+	}
+	if msg.Additionals, _, err = parseRecords(data, offset, msg.Header.ARCount, budget); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Smallest possible encodings: a root name plus fixed fields
+const (
+	minQuestionSize = 1 + 4  // name + type + class
+	minRecordSize   = 1 + 10 // name + type + class + TTL + RDLength
+)
+
+// capacityHint sizes a section slice from its header count without
+// trusting it further than the remaining data could possibly hold, so
+// a forged count can't force a large allocation up front
+func capacityHint(data []byte, offset int, count uint16, minSize int) int {
+	remaining := max(len(data)-offset, 0)
+	return min(int(count), remaining/minSize)
+}
+
+// parseRecords parses count resource records starting at offset,
+// charging their names to budget
+// Returns the records and the offset after the last one
+func parseRecords(data []byte, offset int, count uint16, budget *nameBudget) ([]DNSAnswer, int, error) {
+	// Fail fast on a count the remaining data can't possibly hold,
+	// rather than parsing records until the data runs out
+	if n := capacityHint(data, offset, count, minRecordSize); n < int(count) {
+		return nil, 0, parseErrorf(Truncated, "%d records declared but %d bytes left hold at most %d",
+			count, max(len(data)-offset, 0), n)
+	}
+
+	records := make([]DNSAnswer, 0, count)
+	for i := uint16(0); i < count; i++ {
 		var a DNSAnswer
-		bytesRead, err := a.Parse(data, offset)
+		bytesRead, err := a.parse(data, offset, budget)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
-		msg.Answers = append(msg.Answers, a)
+		records = append(records, a)
 		offset = bytesRead
 	}
 
-	return nil
+	return records, offset, nil
 }
 
-// BuildResponse creates a response message based on the request
-func (msg *DNSMessage) BuildResponse() DNSMessage {
-	header := msg.Header.BuildResponse()
-	header.QDCount = uint16(len(msg.Questions))
-	header.ANCount = uint16(len(msg.Questions)) // Set answer count
+// BuildResponse creates a response message based on the request.
+// Without a zone, questions for defaultIP's type (A for 8.8.8.8 when
+// nil) get a dummy record for it, other known types get no answer and
+// unknown ones NOTIMP. With a zone the answers come from it (following
+// CNAME chains), unknown names get NXDOMAIN, known names without the
+// requested type get an empty NOERROR (NODATA) and the response is
+// marked authoritative (AA).
+func (msg *DNSMessage) BuildResponse(zone *Zone, defaultIP net.IP) DNSMessage {
+	response := DNSMessage{Header: msg.Header.BuildResponse()}
+	response.SyncCounts() // counts follow the sections added below
+	if zone != nil {
+		response.Header.Flags |= FlagAA
+	}
 
-	response := DNSMessage{
-		Header:    header,
-		Questions: make([]Question, len(msg.Questions)),
-		Answers:   make([]DNSAnswer, len(msg.Questions)),
+	if defaultIP == nil {
+		defaultIP = defaultAnswerIP
+	}
+	dummyType, dummy := TypeA, defaultIP.To4()
+	if dummy == nil {
+		dummyType, dummy = TypeAAAA, defaultIP.To16()
 	}
 
-	// Copy questions and add dummy A records for each
-	for i, q := range msg.Questions {
-		response.Questions[i] = q
-		response.Answers[i] = DNSAnswer{
-			Name:     q.QName,
-			Type:     q.QType, // Use the requested type
-			Class:    q.QClass,
-			TTL:      60,
-			RDLength: 4,
-			RData:    []byte{8, 8, 8, 8}, // Dummy IP
+	// Each question is resolved on its own. The single RCODE can only be
+	// NXDOMAIN when no question's name exists, so answers to the others
+	// aren't discarded by the client; negative answers carry the zone's
+	// SOA (if it has one) in the authority section.
+	missing, unknown := 0, 0
+	for _, q := range msg.Questions {
+		response.AddQuestion(q)
+
+		// No zone configured - add a dummy record, if the type is one
+		// the dummy address can answer; other known types get no
+		// answer and unknown ones NOTIMP
+		if zone == nil {
+			switch {
+			case q.QType == dummyType:
+				response.AddAnswer(DNSAnswer{
+					Name:     q.QName,
+					Type:     q.QType,
+					Class:    q.QClass,
+					TTL:      60,
+					RDLength: uint16(len(dummy)),
+					RData:    dummy,
+				})
+			case !KnownType(q.QType):
+				unknown++
+			}
+			continue
+		}
+
+		// Unknown name is NXDOMAIN; a known name without records of
+		// the requested type is NODATA (NOERROR with no answers)
+		answers, exists := zone.Answer(q)
+		if !exists {
+			missing++
+		}
+		for _, a := range answers {
+			response.AddAnswer(a)
+		}
+		if len(answers) == 0 {
+			soa, ok := zone.SOA(q.QName, q.QClass)
+			if ok && !slices.ContainsFunc(response.Authorities, soa.equal) {
+				response.AddAuthority(soa)
+			}
 		}
 	}
 
+	if missing > 0 && missing == len(msg.Questions) {
+		response.Header.SetRCode(RCodeNXDomain)
+	}
+	if unknown > 0 && unknown == len(msg.Questions) {
+		response.Header.SetRCode(RCodeNotImp)
+	}
+
 	return response
 }
 
-// Encode converts a DNS message to bytes
+// NewQuery builds a standard IN-class query for name with a random ID,
+// setting RD when recursionDesired
+func NewQuery(name string, qtype uint16, recursionDesired bool) (*DNSMessage, error) {
+	qname, err := EncodeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &DNSMessage{Header: DNSHeader{ID: uint16(rand.Uint32())}}
+	if recursionDesired {
+		query.Header.Flags |= FlagRD
+	}
+	query.AddQuestion(Question{QName: qname, QType: qtype, QClass: ClassIN})
+	return query, nil
+}
+
+// AddQuestion appends a question and increments QDCount
+func (msg *DNSMessage) AddQuestion(q Question) {
+	msg.Questions = append(msg.Questions, q)
+	msg.Header.QDCount++
+}
+
+// AddAnswer appends an answer record and increments ANCount
+func (msg *DNSMessage) AddAnswer(a DNSAnswer) {
+	msg.Answers = append(msg.Answers, a)
+	msg.Header.ANCount++
+}
+
+// AddAuthority appends an authority record and increments NSCount
+func (msg *DNSMessage) AddAuthority(a DNSAnswer) {
+	msg.Authorities = append(msg.Authorities, a)
+	msg.Header.NSCount++
+}
+
+// SyncCounts sets every header count from the length of its section
+func (msg *DNSMessage) SyncCounts() {
+	msg.Header.QDCount = uint16(len(msg.Questions))
+	msg.Header.ANCount = uint16(len(msg.Answers))
+	msg.Header.NSCount = uint16(len(msg.Authorities))
+	msg.Header.ARCount = uint16(len(msg.Additionals))
+}
+
+// Clone returns a deep copy of the message: its sections and the names
+// and RDATA they hold can be modified without affecting the original
+func (msg *DNSMessage) Clone() *DNSMessage {
+	clone := &DNSMessage{
+		Header:      msg.Header,
+		Questions:   make([]Question, len(msg.Questions)),
+		Answers:     cloneRecords(msg.Answers),
+		Authorities: cloneRecords(msg.Authorities),
+		Additionals: cloneRecords(msg.Additionals),
+	}
+	for i, q := range msg.Questions {
+		q.QName = bytes.Clone(q.QName)
+		clone.Questions[i] = q
+	}
+	return clone
+}
+
+// cloneRecords deep-copies a section's records
+func cloneRecords(records []DNSAnswer) []DNSAnswer {
+	if records == nil {
+		return nil
+	}
+	clone := make([]DNSAnswer, len(records))
+	for i, rr := range records {
+		rr.Name = bytes.Clone(rr.Name)
+		rr.RData = bytes.Clone(rr.RData)
+		clone[i] = rr
+	}
+	return clone
+}
+
+// Equal reports whether two messages carry the same header and the same
+// records. Names are compared case-insensitively and RDLength is
+// ignored in favour of the RData itself.
+func (msg *DNSMessage) Equal(other *DNSMessage) bool {
+	return msg.Header == other.Header &&
+		slices.EqualFunc(msg.Questions, other.Questions, questionsEqual) &&
+		slices.EqualFunc(msg.Answers, other.Answers, DNSAnswer.equal) &&
+		slices.EqualFunc(msg.Authorities, other.Authorities, DNSAnswer.equal) &&
+		slices.EqualFunc(msg.Additionals, other.Additionals, DNSAnswer.equal)
+}
+
+// questionsEqual compares two questions, ignoring name case
+func questionsEqual(a, b Question) bool {
+	return equalNames(a.QName, b.QName) && a.QType == b.QType && a.QClass == b.QClass
+}
+
+// equal reports whether two records match, ignoring name case
+func (a DNSAnswer) equal(b DNSAnswer) bool {
+	return equalNames(a.Name, b.Name) && a.Type == b.Type && a.Class == b.Class &&
+		a.TTL == b.TTL && bytes.Equal(a.RData, b.RData)
+}
+
+// equalNames compares two wire-format names, folding only ASCII letters
+// (RFC 4343)
+func equalNames(a, b []byte) bool {
+	return bytes.Equal(CanonicalName(a), CanonicalName(b))
+}
+
+// Validate checks that the message is consistent enough to encode:
+// header counts match the sections, every RDLength matches its RData
+// and every name is a non-empty, terminated label sequence
+func (msg *DNSMessage) Validate() error {
+	counts := []struct {
+		section string
+		header  uint16
+		actual  int
+	}{
+		{"question", msg.Header.QDCount, len(msg.Questions)},
+		{"answer", msg.Header.ANCount, len(msg.Answers)},
+		{"authority", msg.Header.NSCount, len(msg.Authorities)},
+		{"additional", msg.Header.ARCount, len(msg.Additionals)},
+	}
+	for _, c := range counts {
+		if int(c.header) != c.actual {
+			return fmt.Errorf("%s count %d does not match %d records", c.section, c.header, c.actual)
+		}
+	}
+
+	for i, q := range msg.Questions {
+		if err := validateName(q.QName); err != nil {
+			return fmt.Errorf("question %d: %v", i, err)
+		}
+	}
+
+	sections := []struct {
+		name    string
+		records []DNSAnswer
+	}{
+		{"answer", msg.Answers},
+		{"authority", msg.Authorities},
+		{"additional", msg.Additionals},
+	}
+	for _, sec := range sections {
+		for i, a := range sec.records {
+			if err := validateName(a.Name); err != nil {
+				return fmt.Errorf("%s %d: %v", sec.name, i, err)
+			}
+			if int(a.RDLength) != len(a.RData) {
+				return fmt.Errorf("%s %d: RDLength %d does not match %d bytes of RData", sec.name, i, a.RDLength, len(a.RData))
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateName checks that name is an uncompressed label sequence
+// ending in the root label
+func validateName(name []byte) error {
+	if len(name) == 0 {
+		return fmt.Errorf("empty name")
+	}
+
+	offset := 0
+	for offset < len(name) {
+		labelLen := int(name[offset])
+		if labelLen == 0 {
+			if offset != len(name)-1 {
+				return fmt.Errorf("data after root label")
+			}
+			return nil
+		}
+		if labelLen > 63 {
+			return fmt.Errorf("label length %d exceeds 63", labelLen)
+		}
+		offset += 1 + labelLen
+	}
+
+	return fmt.Errorf("name not terminated")
+}
+
+// Encode converts a DNS message to bytes. Names are compressed against
+// those written before them: owner names in every section (typically
+// to a pointer at offset 12), and the names inside NS, CNAME, PTR, MX
+// and SOA RDATA.
 func (msg *DNSMessage) Encode() []byte {
-	buf := msg.Header.Encode()
+	return msg.EncodeTo(nil)
+}
+
+// EncodeTo is Encode writing into buf's storage, which it overwrites,
+// so a buffer can be reused across messages
+func (msg *DNSMessage) EncodeTo(buf []byte) []byte {
+	buf = msg.Header.appendTo(buf[:0])
+	compressor := NewNameCompressor()
 
 	// Encode questions
 	for _, q := range msg.Questions {
-		buf = append(buf, q.Encode()...)
+		buf = q.appendCompressed(buf, compressor)
 	}
 
-	// Encode answers
+	// Encode answers, authority and additional records
 	for _, a := range msg.Answers {
-		buf = append(buf, a.Encode()...)
+		buf = a.appendCompressed(buf, compressor)
+	}
+	for _, a := range msg.Authorities {
+		buf = a.appendCompressed(buf, compressor)
+	}
+	for _, a := range msg.Additionals {
+		buf = a.appendCompressed(buf, compressor)
 	}
 
 	return buf
 }
+
+// Truncate drops whole records from the end of the message until it
+// encodes to at most size bytes: first additional records (keeping the
+// OPT record), then authority records and answers. Dropping any of the
+// latter sets TC so the client retries over TCP (RFC 2181 section 9);
+// records are never cut mid-way.
+func (msg *DNSMessage) Truncate(size int) {
+	if len(msg.Encode()) <= size {
+		return
+	}
+
+	opt, _ := msg.OPT()
+	msg.SetOPT(nil)
+	for len(msg.Additionals) > 0 && msg.encodedLen(opt) > size {
+		msg.Additionals = msg.Additionals[:len(msg.Additionals)-1]
+	}
+	for len(msg.Authorities) > 0 && msg.encodedLen(opt) > size {
+		msg.Authorities = msg.Authorities[:len(msg.Authorities)-1]
+		msg.Header.Flags |= FlagTC
+	}
+	for len(msg.Answers) > 0 && msg.encodedLen(opt) > size {
+		msg.Answers = msg.Answers[:len(msg.Answers)-1]
+		msg.Header.Flags |= FlagTC
+	}
+
+	msg.SyncCounts()
+	msg.SetOPT(opt)
+}
+
+// encodedLen returns the encoded size of the message with opt added to
+// its additional section
+func (msg *DNSMessage) encodedLen(opt *OPT) int {
+	n := len(msg.Encode())
+	if opt != nil {
+		record := opt.Record()
+		n += len(record.Encode())
+	}
+	return n
+}
@@ -0,0 +1,67 @@
+package dns
+
+import "testing"
+
+// fuzzSeeds are real messages to start fuzzing from
+func fuzzSeeds(f *testing.F) [][]byte {
+	var seeds [][]byte
+	add := func(msg *DNSMessage) { seeds = append(seeds, msg.Encode()) }
+
+	for _, qtype := range []uint16{TypeA, TypeAAAA, TypeMX, TypeHTTPS, TypeANY} {
+		q, err := NewQuery("www.example.com", qtype, true)
+		if err != nil {
+			f.Fatal(err)
+		}
+		add(q)
+	}
+
+	// An EDNS query with a cookie, and a two-question query whose second
+	// name is compressed
+	edns, _ := NewQuery("example.org", TypeA, true)
+	opt := &OPT{UDPSize: 1232, Flags: FlagDO}
+	opt.SetOption(OptionCookie, []byte("01234567"))
+	edns.SetOPT(opt)
+	add(edns)
+
+	multi, _ := NewQuery("example.com", TypeA, true)
+	multi.AddQuestion(Question{QName: []byte("\x03www\x07example\x03com\x00"), QType: TypeAAAA, QClass: ClassIN})
+	add(multi)
+
+	// Responses with compressed owner names and RDATA
+	seeds = append(seeds, compressedResponse)
+	response := &DNSMessage{Header: DNSHeader{ID: 7, Flags: FlagQR | FlagRD | FlagRA}}
+	response.AddQuestion(Question{QName: []byte("\x03www\x07example\x03com\x00"), QType: TypeA, QClass: ClassIN})
+	response.AddAnswer(DNSAnswer{Name: []byte("\x03www\x07example\x03com\x00"), Type: TypeCNAME, Class: ClassIN, TTL: 60,
+		RData: []byte("\x03web\x07example\x03com\x00")})
+	response.AddAnswer(DNSAnswer{Name: []byte("\x03web\x07example\x03com\x00"), Type: TypeA, Class: ClassIN, TTL: 60,
+		RData: []byte{192, 0, 2, 1}})
+	response.AddAuthority(DNSAnswer{Name: []byte("\x07example\x03com\x00"), Type: TypeNS, Class: ClassIN, TTL: 60,
+		RData: []byte("\x02ns\x07example\x03com\x00")})
+	add(response)
+
+	return seeds
+}
+
+// FuzzParseMessage feeds arbitrary bytes to ParseComplete, which must
+// neither panic nor hang. Whatever it accepts must survive a round trip
+// through Encode.
+func FuzzParseMessage(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg DNSMessage
+		if err := msg.ParseComplete(data); err != nil {
+			return
+		}
+
+		var reparsed DNSMessage
+		if err := reparsed.ParseComplete(msg.Encode()); err != nil {
+			t.Fatalf("re-encoded message doesn't parse: %v", err)
+		}
+		if !reparsed.Equal(&msg) {
+			t.Fatalf("round trip changed the message")
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package dns
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// testMessage returns a response for www.example.com with one A record
+func testMessage(t *testing.T) *DNSMessage {
+	t.Helper()
+	msg := &DNSMessage{Header: DNSHeader{ID: 0x1234, Flags: FlagQR | FlagRD | FlagRA}}
+	msg.AddQuestion(Question{QName: mustName(t, "www.example.com"), QType: TypeA, QClass: ClassIN})
+	msg.AddAnswer(DNSAnswer{
+		Name:     mustName(t, "www.example.com"),
+		Type:     TypeA,
+		Class:    ClassIN,
+		TTL:      300,
+		RDLength: 4,
+		RData:    []byte{192, 0, 2, 1},
+	})
+	return msg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(msg *DNSMessage)
+		wantErr string
+	}{
+		{"consistent", func(*DNSMessage) {}, ""},
+		{"answer count", func(msg *DNSMessage) { msg.Header.ANCount = 2 }, "answer count 2 does not match 1 records"},
+		{"question count", func(msg *DNSMessage) { msg.Header.QDCount = 0 }, "question count 0 does not match 1 records"},
+		{"RDLength", func(msg *DNSMessage) { msg.Answers[0].RDLength = 16 }, "RDLength 16 does not match 4 bytes"},
+		{"empty name", func(msg *DNSMessage) { msg.Questions[0].QName = nil }, "question 0: empty name"},
+		{"unterminated name", func(msg *DNSMessage) { msg.Answers[0].Name = []byte("\x03www") }, "answer 0: name not terminated"},
+		{"long label", func(msg *DNSMessage) { msg.Answers[0].Name = []byte{64, 0} }, "label length 64 exceeds 63"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := testMessage(t)
+			tt.edit(msg)
+			err := msg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// compressedResponse is a response to www.example.com A whose answer
+// owner name is a pointer to the question name
+var compressedResponse = []byte("\x12\x34\x81\x80\x00\x01\x00\x01\x00\x00\x00\x00" +
+	"\x03www\x07example\x03com\x00\x00\x01\x00\x01" +
+	"\xc0\x0c\x00\x01\x00\x01\x00\x00\x01\x2c\x00\x04\xc0\x00\x02\x01")
+
+func TestCompressedAnswerSurvivesReencoding(t *testing.T) {
+	var parsed DNSMessage
+	if err := parsed.ParseComplete(compressedResponse); err != nil {
+		t.Fatalf("ParseComplete: %v", err)
+	}
+	want := mustName(t, "www.example.com")
+	if !bytes.Equal(parsed.Answers[0].Name, want) {
+		t.Fatalf("parsed answer name = %q, want %q", parsed.Answers[0].Name, want)
+	}
+
+	// In a message with another question, offset 12 holds another name
+	fresh := &DNSMessage{Header: DNSHeader{ID: 1, Flags: FlagQR}}
+	fresh.AddQuestion(Question{QName: mustName(t, "other.test"), QType: TypeA, QClass: ClassIN})
+	fresh.AddAnswer(parsed.Answers[0])
+
+	var reparsed DNSMessage
+	if err := reparsed.ParseComplete(fresh.Encode()); err != nil {
+		t.Fatalf("ParseComplete of re-encoded message: %v", err)
+	}
+	if got := reparsed.Answers[0].Name; !bytes.Equal(got, want) {
+		t.Errorf("re-encoded answer name = %q, want %q", got, want)
+	}
+}
+
+// query builds a query message for name and qtype
+func query(t *testing.T, name string, qtype uint16) *DNSMessage {
+	t.Helper()
+	q, err := NewQuery(name, qtype, true)
+	if err != nil {
+		t.Fatalf("NewQuery: %v", err)
+	}
+	return q
+}
+
+func TestBuildResponseNXDomainAndNoData(t *testing.T) {
+	zone := parseZone(t, "www.example.com A 192.0.2.1\n")
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRCode uint16
+		answers   int
+	}{
+		{"existing", "www.example.com", TypeA, RCodeNoError, 1},
+		{"missing name", "missing.example.com", TypeA, RCodeNXDomain, 0},
+		{"missing type", "www.example.com", TypeAAAA, RCodeNoError, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := query(t, tt.qname, tt.qtype).BuildResponse(zone, nil)
+			if rcode := response.Header.RCode(); rcode != tt.wantRCode {
+				t.Errorf("RCODE = %d, want %d", rcode, tt.wantRCode)
+			}
+			if len(response.Answers) != tt.answers {
+				t.Errorf("got %d answers, want %d", len(response.Answers), tt.answers)
+			}
+			if response.Header.Flags&FlagAA == 0 {
+				t.Error("zone response is not authoritative")
+			}
+		})
+	}
+}
+
+func TestAddKeepsCountsInSync(t *testing.T) {
+	msg := &DNSMessage{}
+	record := testMessage(t).Answers[0]
+	for i := range 3 {
+		msg.AddQuestion(Question{QName: mustName(t, "example.com"), QType: TypeA, QClass: ClassIN})
+		msg.AddAnswer(record)
+		msg.AddAnswer(record)
+		msg.AddAuthority(record)
+		if err := msg.Validate(); err != nil {
+			t.Fatalf("after %d rounds: %v", i+1, err)
+		}
+	}
+	if h := msg.Header; h.QDCount != 3 || h.ANCount != 6 || h.NSCount != 3 {
+		t.Errorf("counts = %d/%d/%d, want 3/6/3", h.QDCount, h.ANCount, h.NSCount)
+	}
+
+	msg.Answers = msg.Answers[:1]
+	msg.Additionals = append(msg.Additionals, record)
+	msg.SyncCounts()
+	if err := msg.Validate(); err != nil {
+		t.Errorf("after SyncCounts: %v", err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(msg *DNSMessage)
+		want bool
+	}{
+		{"identical", func(*DNSMessage) {}, true},
+		{"name case", func(msg *DNSMessage) { msg.Answers[0].Name = mustName(t, "WWW.Example.COM") }, true},
+		{"RDLength", func(msg *DNSMessage) { msg.Answers[0].RDLength = 0 }, true},
+		{"RData", func(msg *DNSMessage) { msg.Answers[0].RData = []byte{192, 0, 2, 2} }, false},
+		{"TTL", func(msg *DNSMessage) { msg.Answers[0].TTL++ }, false},
+		{"header", func(msg *DNSMessage) { msg.Header.ID++ }, false},
+		{"question type", func(msg *DNSMessage) { msg.Questions[0].QType = TypeAAAA }, false},
+		{"extra answer", func(msg *DNSMessage) { msg.AddAnswer(msg.Answers[0]) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := testMessage(t)
+			tt.edit(other)
+			if got := testMessage(t).Equal(other); got != tt.want {
+				t.Errorf("Equal() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	// Only ASCII letters fold, so other bytes must match exactly
+	msg, other := testMessage(t), testMessage(t)
+	msg.Questions[0].QName = []byte("\x03\xff\xfe\xfd\x00")
+	other.Questions[0].QName = []byte("\x03\x80\x81\x82\x00")
+	if msg.Equal(other) {
+		t.Error("Equal() = true for different non-ASCII question names")
+	}
+}
+
+func TestBuildResponseAnswersEachQuestion(t *testing.T) {
+	zone := parseZone(t, "www.example.com A 192.0.2.1\n")
+
+	msg := query(t, "www.example.com", TypeA)
+	msg.AddQuestion(Question{QName: mustName(t, "missing.example.com"), QType: TypeA, QClass: ClassIN})
+	response := msg.BuildResponse(zone, nil)
+
+	if len(response.Questions) != 2 {
+		t.Errorf("response echoes %d questions, want 2", len(response.Questions))
+	}
+	if ips := answerIPs(response.Answers); len(ips) != 1 || ips[0] != "192.0.2.1" {
+		t.Errorf("answers = %v, want [192.0.2.1]", ips)
+	}
+	// NXDOMAIN would tell the client the first name doesn't exist either
+	if rcode := response.Header.RCode(); rcode != RCodeNoError {
+		t.Errorf("RCODE = %d, want NOERROR", rcode)
+	}
+	if err := response.Validate(); err != nil {
+		t.Errorf("response is invalid: %v", err)
+	}
+
+	both := query(t, "missing.example.com", TypeA)
+	both.AddQuestion(Question{QName: mustName(t, "gone.example.com"), QType: TypeA, QClass: ClassIN})
+	response = both.BuildResponse(zone, nil)
+	if rcode := response.Header.RCode(); rcode != RCodeNXDomain {
+		t.Errorf("two missing names: RCODE = %d, want NXDOMAIN", rcode)
+	}
+}
+
+func TestCloneIsDeep(t *testing.T) {
+	original := testMessage(t)
+	original.Additionals = append(original.Additionals, original.Answers[0])
+	original.SyncCounts()
+	want := testMessage(t)
+	want.Additionals = append(want.Additionals, want.Answers[0])
+	want.SyncCounts()
+
+	clone := original.Clone()
+	if !clone.Equal(original) {
+		t.Fatal("clone differs from the original")
+	}
+	clone.Header.ID++
+	clone.Questions[0].QName[1] = 'W'
+	clone.Answers[0].RData[3] = 99
+	clone.Answers[0].Name[1] = 'x'
+	clone.Additionals[0].RData[0] = 10
+	clone.AddAnswer(clone.Answers[0])
+
+	if !original.Equal(want) || !bytes.Equal(original.Questions[0].QName, want.Questions[0].QName) {
+		t.Error("changing the clone changed the original")
+	}
+}
+
+func TestNewQuery(t *testing.T) {
+	for _, rd := range []bool{true, false} {
+		query, err := NewQuery("www.example.com.", TypeAAAA, rd)
+		if err != nil {
+			t.Fatalf("NewQuery: %v", err)
+		}
+
+		var parsed DNSMessage
+		if err := parsed.ParseComplete(query.Encode()); err != nil {
+			t.Fatalf("ParseComplete: %v", err)
+		}
+		h := parsed.Header
+		if h.ID != query.Header.ID || h.IsResponse() || h.Opcode() != OpcodeQuery || h.RecursionDesired() != rd {
+			t.Errorf("RD %t: header %+v", rd, h)
+		}
+		if h.QDCount != 1 || h.ANCount != 0 || h.NSCount != 0 || h.ARCount != 0 {
+			t.Errorf("RD %t: counts %d/%d/%d/%d, want 1/0/0/0", rd, h.QDCount, h.ANCount, h.NSCount, h.ARCount)
+		}
+		if q := parsed.Questions[0]; q.Name() != "www.example.com" || q.QType != TypeAAAA || q.QClass != ClassIN {
+			t.Errorf("RD %t: question %s type %d class %d", rd, q.Name(), q.QType, q.QClass)
+		}
+	}
+
+	if _, err := NewQuery("bad..name", TypeA, true); err == nil {
+		t.Error("NewQuery accepted an empty label")
+	}
+}
+
+func TestBuildResponseWithoutZone(t *testing.T) {
+	tests := []struct {
+		qtype     uint16
+		defaultIP net.IP
+		rcode     uint16
+		answer    string
+	}{
+		{TypeA, nil, RCodeNoError, "8.8.8.8"},
+		{TypeAAAA, net.ParseIP("2001:db8::1"), RCodeNoError, "2001:db8::1"},
+		{TypeAAAA, nil, RCodeNoError, ""},
+		{TypeMX, nil, RCodeNoError, ""},
+		{TypeDS, nil, RCodeNoError, ""},
+		{TypeDNSKEY, nil, RCodeNoError, ""},
+		{TypeSRV, nil, RCodeNoError, ""},
+		{TypeCAA, nil, RCodeNoError, ""},
+		{999, nil, RCodeNotImp, ""},
+	}
+	for _, tt := range tests {
+		response := query(t, "example.com", tt.qtype).BuildResponse(nil, tt.defaultIP)
+		if rcode := response.Header.RCode(); rcode != tt.rcode {
+			t.Errorf("%s: RCODE = %d, want %d", TypeString(tt.qtype), rcode, tt.rcode)
+		}
+		ips := answerIPs(response.Answers)
+		if tt.answer == "" {
+			if len(response.Answers) != 0 {
+				t.Errorf("%s: got made-up answers %+v", TypeString(tt.qtype), response.Answers)
+			}
+		} else if len(ips) != 1 || ips[0] != tt.answer || response.Answers[0].Type != tt.qtype {
+			t.Errorf("%s: answers = %v, want [%s]", TypeString(tt.qtype), ips, tt.answer)
+		}
+	}
+}
+
+func TestTruncateDropsWholeRecords(t *testing.T) {
+	for _, rdlen := range []int{2, 37, 100, 113, 255} {
+		msg := query(t, "www.example.com", TypeTXT).BuildResponse(NewZone(), nil)
+		var answers []DNSAnswer
+		for i := range 50 {
+			rdata := append([]byte{byte(rdlen - 1)}, bytes.Repeat([]byte{'a' + byte(i)}, rdlen-1)...)
+			a := DNSAnswer{Name: mustName(t, "www.example.com"), Type: TypeTXT, Class: ClassIN, TTL: 60,
+				RDLength: uint16(len(rdata)), RData: rdata}
+			answers = append(answers, a)
+			msg.AddAnswer(a)
+		}
+
+		msg.Truncate(512)
+		data := msg.Encode()
+		if len(data) > 512 {
+			t.Errorf("%d-byte RDATA: truncated to %d bytes", rdlen, len(data))
+		}
+		var parsed DNSMessage
+		if err := parsed.ParseComplete(data); err != nil {
+			t.Fatalf("%d-byte RDATA: truncated message doesn't parse: %v", rdlen, err)
+		}
+		if !parsed.Header.Truncated() {
+			t.Errorf("%d-byte RDATA: TC not set", rdlen)
+		}
+
+		// The survivors are the leading answers, intact, and as many as fit
+		kept := len(parsed.Answers)
+		for i, a := range parsed.Answers {
+			if !bytes.Equal(a.RData, answers[i].RData) {
+				t.Errorf("%d-byte RDATA: answer %d changed", rdlen, i)
+			}
+		}
+		msg.AddAnswer(answers[kept])
+		if len(msg.Encode()) <= 512 {
+			t.Errorf("%d-byte RDATA: kept %d answers, but %d fit", rdlen, kept, kept+1)
+		}
+	}
+
+	// A message that already fits is left alone
+	msg := testMessage(t)
+	before := msg.Encode()
+	msg.Truncate(len(before))
+	if !bytes.Equal(msg.Encode(), before) || msg.Header.Truncated() {
+		t.Error("Truncate changed a message that fit")
+	}
+}
+
+func TestInflatedRecordCountIsRejected(t *testing.T) {
+	// A response claiming 65535 answers, with room for two at most
+	packet := []byte("\x00\x01\x81\x80\x00\x01\xff\xff\x00\x00\x00\x00" +
+		"\x07example\x03com\x00\x00\x01\x00\x01" +
+		"\xc0\x0c\x00\x01\x00\x01\x00\x00\x00\x3c\x00\x04\xc0\x00\x02\x01" +
+		"\xc0\x0c\x00\x01\x00\x01\x00\x00\x00\x3c\x00\x04\xc0\x00\x02\x02")
+
+	var msg DNSMessage
+	err := msg.ParseComplete(packet)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Kind != Truncated {
+		t.Fatalf("ParseComplete() error = %v, want a Truncated *ParseError", err)
+	}
+	if !strings.Contains(err.Error(), "65535 records declared") {
+		t.Errorf("error %q doesn't name the declared count", err)
+	}
+	if len(msg.Answers) != 0 {
+		t.Errorf("parsed %d answers before giving up", len(msg.Answers))
+	}
+
+	// The same records with an honest count parse
+	packet[6], packet[7] = 0, 2
+	if err := msg.ParseComplete(packet); err != nil {
+		t.Errorf("ParseComplete with ANCOUNT 2: %v", err)
+	}
+}
@@ -1,13 +1,15 @@
 package dns
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strings"
 )
 
 // Question represents a DNS question section
 type Question struct {
-	QName  []byte
+	QName  []byte // uncompressed wire-format name
 	QType  uint16
 	QClass uint16
 }
@@ -15,7 +17,14 @@ type Question struct {
 // Parse extracts question section from DNS message
 // Returns the number of bytes consumed
 func (q *Question) Parse(data []byte, offset int) (int, error) {
-	name, bytesConsumed, err := DecodeName(data, offset)
+	return q.parse(data, offset, nil)
+}
+
+// parse is Parse charging the name's decoding to budget
+func (q *Question) parse(data []byte, offset int, budget *nameBudget) (int, error) {
+	// QNAME may legally use compression pointers (e.g. for a second
+	// question), which decodeName follows
+	name, bytesConsumed, err := decodeName(data, offset, budget)
 	if err != nil {
 		return 0, err
 	}
@@ -24,7 +33,7 @@ func (q *Question) Parse(data []byte, offset int) (int, error) {
 	currentOffset := offset + bytesConsumed
 
 	if currentOffset+4 > len(data) {
-		return 0, fmt.Errorf("insufficient data for QTYPE/QCLASS")
+		return 0, parseErrorf(Truncated, "insufficient data for QTYPE/QCLASS")
 	}
 
 	q.QName = name
@@ -34,8 +43,38 @@ func (q *Question) Parse(data []byte, offset int) (int, error) {
 	return currentOffset + 4, nil
 }
 
+// DecodeName decodes a (possibly compressed) domain name starting at offset.
+// It returns the uncompressed wire-format name and the number of bytes the
+// name occupies at its original position.
 func DecodeName(data []byte, offset int) ([]byte, int, error) {
-	var name []byte
+	return decodeName(data, offset, nil)
+}
+
+// decodeName is DecodeName charging the labels and pointers it follows
+// to budget, which may be nil for no limit beyond the name's own
+func decodeName(data []byte, offset int, budget *nameBudget) ([]byte, int, error) {
+	// Measure the name first so it can be allocated exactly once; the
+	// second walk repeats the same steps, so only the first is charged
+	length, _, err := walkName(data, offset, nil, budget)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	name := make([]byte, 0, length)
+	_, bytesConsumed, err := walkName(data, offset, &name, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return name, bytesConsumed, nil
+}
+
+// walkName follows the labels and pointers of a name starting at offset.
+// It returns the decoded length and the bytes consumed at the original
+// position; when dst is non-nil the decoded labels are appended to it.
+// Every label and pointer followed is charged to budget.
+func walkName(data []byte, offset int, dst *[]byte, budget *nameBudget) (int, int, error) {
+	length := 0
 	bytesConsumed := 0
 	currentOffset := offset
 	jumped := false
@@ -47,11 +86,17 @@ func DecodeName(data []byte, offset int) ([]byte, int, error) {
 	for {
 		loops++
 		if loops > maxLoops {
-			return nil, 0, fmt.Errorf("too many jumps or labels")
+			return 0, 0, parseErrorf(BadPointer, "too many jumps or labels")
+		}
+		if err := budget.spend(); err != nil {
+			return 0, 0, err
 		}
 
 		if currentOffset >= len(data) {
-			return nil, 0, fmt.Errorf("offset out of bounds")
+			if jumped {
+				return 0, 0, parseErrorf(BadPointer, "pointer offset out of bounds")
+			}
+			return 0, 0, parseErrorf(Truncated, "offset out of bounds")
 		}
 
 		b := data[currentOffset]
@@ -59,7 +104,7 @@ func DecodeName(data []byte, offset int) ([]byte, int, error) {
 		// Check for pointer (11xxxxxx)
 		if b&0xC0 == 0xC0 {
 			if currentOffset+1 >= len(data) {
-				return nil, 0, fmt.Errorf("pointer incomplete")
+				return 0, 0, parseErrorf(Truncated, "pointer incomplete")
 			}
 
 			// Pointer consumes 2 bytes at the original position
@@ -76,6 +121,12 @@ func DecodeName(data []byte, offset int) ([]byte, int, error) {
 			continue
 		}
 
+		// The 10 prefix is reserved and 01 was the extended label type
+		// RFC 6891 retired; neither is a label length
+		if b&0xC0 != 0 {
+			return 0, 0, parseErrorf(InvalidLabel, "unsupported label type 0x%02x", b&0xC0)
+		}
+
 		// Regular label
 		if !jumped {
 			bytesConsumed += 1
@@ -83,17 +134,27 @@ func DecodeName(data []byte, offset int) ([]byte, int, error) {
 		currentOffset++
 
 		if b == 0 {
-			name = append(name, 0)
+			length++
+			if dst != nil {
+				*dst = append(*dst, 0)
+			}
 			break
 		}
 
 		labelLen := int(b)
 		if currentOffset+labelLen > len(data) {
-			return nil, 0, fmt.Errorf("label length out of bounds")
+			return 0, 0, parseErrorf(Truncated, "label length out of bounds")
 		}
 
-		name = append(name, b)
-		name = append(name, data[currentOffset:currentOffset+labelLen]...)
+		length += 1 + labelLen
+		// The root label still has to fit within the 255-byte limit
+		if length >= 255 {
+			return 0, 0, parseErrorf(TooLong, "name longer than 255 bytes")
+		}
+		if dst != nil {
+			*dst = append(*dst, b)
+			*dst = append(*dst, data[currentOffset:currentOffset+labelLen]...)
+		}
 
 		currentOffset += labelLen
 		if !jumped {
@@ -101,7 +162,131 @@ func DecodeName(data []byte, offset int) ([]byte, int, error) {
 		}
 	}
 
-	return name, bytesConsumed, nil
+	return length, bytesConsumed, nil
+}
+
+// nameStepsPerByte is how many labels and pointers name decoding may
+// follow per byte of a message. Legitimate compression stays well
+// under it; without it a packet of short pointer chains could make
+// every name in it walk the full 1000 steps walkName allows.
+const nameStepsPerByte = 4
+
+// nameBudget is what is left of a message's allowance of labels and
+// pointers for name decoding, shared by every name in the message
+type nameBudget int
+
+// newNameBudget returns the budget for parsing data
+func newNameBudget(data []byte) *nameBudget {
+	budget := nameBudget(nameStepsPerByte * len(data))
+	return &budget
+}
+
+// spend charges one label or pointer to the budget, failing once it's
+// used up. A nil budget never runs out.
+func (b *nameBudget) spend() error {
+	if b == nil {
+		return nil
+	}
+	if *b <= 0 {
+		return parseErrorf(BadPointer, "message follows too many labels and pointers")
+	}
+	*b--
+	return nil
+}
+
+// EncodeName converts a dotted domain name (e.g. "www.example.com") to
+// uncompressed wire format. A trailing dot is optional.
+func EncodeName(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return []byte{0}, nil
+	}
+
+	buf := make([]byte, 0, len(name)+2)
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid label length in %q", name)
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	buf = append(buf, 0)
+
+	if len(buf) > 255 {
+		return nil, fmt.Errorf("name too long: %q", name)
+	}
+
+	return buf, nil
+}
+
+// Name returns the question's name in dotted form (e.g.
+// "www.example.com"), or "." for the root
+func (q Question) Name() string {
+	return NameString(q.QName)
+}
+
+// Matches reports whether the question asks for name (dotted form,
+// compared case-insensitively) and qtype. TypeANY on either side
+// matches every type.
+func (q Question) Matches(name string, qtype uint16) bool {
+	if q.QType != qtype && q.QType != TypeANY && qtype != TypeANY {
+		return false
+	}
+	wire, err := EncodeName(name)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(CanonicalName(q.QName), CanonicalName(wire))
+}
+
+// NameString converts a wire-format name to dotted form, or "." for
+// the root. Malformed names give "".
+func NameString(wire []byte) string {
+	name, _, err := DecodeName(wire, 0)
+	if err != nil {
+		return ""
+	}
+
+	var labels []string
+	for offset := 0; name[offset] != 0; offset += int(name[offset]) + 1 {
+		labels = append(labels, string(name[offset+1:offset+1+int(name[offset])]))
+	}
+	if len(labels) == 0 {
+		return "."
+	}
+	return strings.Join(labels, ".")
+}
+
+// CanonicalName returns a wire-format name in the form used as a lookup
+// key: ASCII letters lowercased, since names compare case-insensitively
+// (RFC 4343), and ending in the root label
+func CanonicalName(name []byte) []byte {
+	canonical := make([]byte, 0, len(name)+1)
+	offset := 0
+	for offset < len(name) && name[offset] != 0 {
+		end := min(offset+1+int(name[offset]), len(name))
+		canonical = append(canonical, name[offset])
+		for _, c := range name[offset+1 : end] {
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			canonical = append(canonical, c)
+		}
+		offset = end
+	}
+	return append(canonical, 0)
+}
+
+// IsSubdomain reports whether the wire-format name equals zone or lies
+// below it, comparing their canonical forms
+func IsSubdomain(name, zone []byte) bool {
+	name, zone = CanonicalName(name), CanonicalName(zone)
+	for offset := 0; offset < len(name); offset += int(name[offset]) + 1 {
+		if bytes.Equal(name[offset:], zone) {
+			return true
+		}
+	}
+	return false
 }
 
 // Encode converts a Question to bytes
@@ -112,3 +297,10 @@ func (q *Question) Encode() []byte {
 	binary.BigEndian.PutUint16(buf[len(q.QName)+2:len(q.QName)+4], q.QClass)
 	return buf
 }
+
+// appendCompressed appends the question to buf, compressing its name
+func (q *Question) appendCompressed(buf []byte, c *NameCompressor) []byte {
+	buf = c.AppendName(buf, q.QName)
+	buf = binary.BigEndian.AppendUint16(buf, q.QType)
+	return binary.BigEndian.AppendUint16(buf, q.QClass)
+}
@@ -0,0 +1,196 @@
+package dns
+
+import (
+	"bytes"
+	"testing"
+)
+
+// compressedMessage returns a message holding www.example.com at offset
+// 12 and, after it, mail.www.example.com compressed to a pointer to it,
+// along with the second name's offset
+func compressedMessage() ([]byte, int) {
+	data := make([]byte, 12)
+	data = append(data, "\x03www\x07example\x03com\x00"...)
+	offset := len(data)
+	data = append(data, "\x04mail\xc0\x0c"...)
+	return data, offset
+}
+
+func TestDecodeNameFollowsPointers(t *testing.T) {
+	data, offset := compressedMessage()
+
+	name, n, err := DecodeName(data, offset)
+	if err != nil {
+		t.Fatalf("DecodeName: %v", err)
+	}
+	if want := []byte("\x04mail\x03www\x07example\x03com\x00"); !bytes.Equal(name, want) {
+		t.Errorf("name = %q, want %q", name, want)
+	}
+	if n != 7 {
+		t.Errorf("consumed %d bytes, want 7", n)
+	}
+	if cap(name) != len(name) {
+		t.Errorf("name has capacity %d for %d bytes", cap(name), len(name))
+	}
+}
+
+func TestDecodeNameAllocatesOnce(t *testing.T) {
+	data, offset := compressedMessage()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		DecodeName(data, offset)
+	})
+	if allocs != 1 {
+		t.Errorf("DecodeName made %v allocations, want 1", allocs)
+	}
+}
+
+// decodeNameAppend decodes a name by growing a slice label by label,
+// the way DecodeName did before it measured names first; it is the
+// baseline BenchmarkDecodeName compares against
+func decodeNameAppend(data []byte, offset int) []byte {
+	var name []byte
+	for data[offset] != 0 {
+		if data[offset]&0xC0 == 0xC0 {
+			offset = int(data[offset]&0x3F)<<8 | int(data[offset+1])
+			continue
+		}
+		end := offset + 1 + int(data[offset])
+		name = append(name, data[offset:end]...)
+		offset = end
+	}
+	return append(name, 0)
+}
+
+func BenchmarkDecodeName(b *testing.B) {
+	data, offset := compressedMessage()
+
+	b.Run("measured", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			DecodeName(data, offset)
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			decodeNameAppend(data, offset)
+		}
+	})
+}
+
+func TestQuestionName(t *testing.T) {
+	plain := Question{QName: []byte("\x03www\x07example\x03com\x00")}
+	if got := plain.Name(); got != "www.example.com" {
+		t.Errorf("Name() = %q, want www.example.com", got)
+	}
+	if got := (Question{QName: []byte{0}}).Name(); got != "." {
+		t.Errorf("root Name() = %q, want .", got)
+	}
+
+	// A second question whose name points back into the first
+	data := []byte("\x00\x01\x00\x00\x00\x02\x00\x00\x00\x00\x00\x00" +
+		"\x03www\x07example\x03com\x00\x00\x01\x00\x01" +
+		"\x04mail\xc0\x0c\x00\x01\x00\x01")
+	var msg DNSMessage
+	if err := msg.ParseComplete(data); err != nil {
+		t.Fatalf("ParseComplete: %v", err)
+	}
+	if got := msg.Questions[1].Name(); got != "mail.www.example.com" {
+		t.Errorf("compressed Name() = %q, want mail.www.example.com", got)
+	}
+}
+
+func TestReservedLabelTypesAreRejected(t *testing.T) {
+	for _, length := range []byte{0x80, 0x40, 0xBF} {
+		data := append(make([]byte, 12), length, 'a', 'b', 'c', 0)
+		if _, _, err := DecodeName(data, 12); err == nil {
+			t.Errorf("DecodeName accepted length byte 0x%02x", length)
+		}
+
+		// The same name as a question must fail the whole message
+		data[5] = 1 // QDCOUNT
+		data = append(data, 0, 1, 0, 1)
+		var msg DNSMessage
+		if err := msg.Parse(data); err == nil {
+			t.Errorf("Parse accepted a question name with length byte 0x%02x", length)
+		}
+	}
+}
+
+func TestQuestionParseCompressedName(t *testing.T) {
+	data, offset := compressedMessage()
+	data = append(data, 0, 28, 0, 1) // AAAA IN
+
+	var q Question
+	next, err := q.Parse(data, offset)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if next != len(data) {
+		t.Errorf("Parse returned offset %d, want %d", next, len(data))
+	}
+	if q.Name() != "mail.www.example.com" || q.QType != TypeAAAA || q.QClass != ClassIN {
+		t.Errorf("question = %s type %d class %d", q.Name(), q.QType, q.QClass)
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"\x07Example\x03COM\x00", "\x07example\x03com\x00"},
+		{"\x07example\x03com", "\x07example\x03com\x00"}, // missing root label
+		{"\x00", "\x00"},
+		{"\x03A-\xc9\x00", "\x03a-\xc9\x00"}, // only ASCII letters fold
+	}
+	for _, tt := range tests {
+		if got := CanonicalName([]byte(tt.name)); string(got) != tt.want {
+			t.Errorf("CanonicalName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsSubdomain(t *testing.T) {
+	tests := []struct {
+		name, zone string
+		want       bool
+	}{
+		{"\x03www\x07Example\x03COM\x00", "\x07example\x03com\x00", true},
+		{"\x07example\x03com\x00", "\x07EXAMPLE\x03com\x00", true},
+		{"\x03www\x07example\x03org\x00", "\x07example\x03com\x00", false},
+		{"\x03www\x03\xff\xfe\xfd\x00", "\x03\x80\x81\x82\x00", false}, // only ASCII letters fold
+		{"\x03www\x00", "\x00", true},
+	}
+	for _, tt := range tests {
+		if got := IsSubdomain([]byte(tt.name), []byte(tt.zone)); got != tt.want {
+			t.Errorf("IsSubdomain(%q, %q) = %t, want %t", tt.name, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestQuestionMatches(t *testing.T) {
+	q := Question{QName: []byte("\x03www\x07Example\x03com\x00"), QType: TypeA, QClass: ClassIN}
+	wildcard := Question{QName: q.QName, QType: TypeANY, QClass: ClassIN}
+	tests := []struct {
+		q     Question
+		name  string
+		qtype uint16
+		want  bool
+	}{
+		{q, "www.example.com", TypeA, true},
+		{q, "www.example.com", TypeAAAA, false},
+		{q, "www.example.com", TypeANY, true},
+		{wildcard, "www.example.com", TypeMX, true},
+		{q, "WWW.EXAMPLE.COM", TypeA, true},
+		{q, "www.example.com.", TypeA, true},
+		{q, "example.com", TypeA, false},
+		{q, "www.example.org", TypeA, false},
+	}
+	for _, tt := range tests {
+		if got := tt.q.Matches(tt.name, tt.qtype); got != tt.want {
+			t.Errorf("%s type %d Matches(%q, %d) = %t, want %t", tt.q.Name(), tt.q.QType, tt.name, tt.qtype, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SvcParamKeys (RFC 9460 section 14.3.2)
+const (
+	SvcParamMandatory     uint16 = 0
+	SvcParamALPN          uint16 = 1
+	SvcParamNoDefaultALPN uint16 = 2
+	SvcParamPort          uint16 = 3
+	SvcParamIPv4Hint      uint16 = 4
+	SvcParamECH           uint16 = 5
+	SvcParamIPv6Hint      uint16 = 6
+)
+
+var svcParamKeyNames = map[string]uint16{
+	"mandatory":       SvcParamMandatory,
+	"alpn":            SvcParamALPN,
+	"no-default-alpn": SvcParamNoDefaultALPN,
+	"port":            SvcParamPort,
+	"ipv4hint":        SvcParamIPv4Hint,
+	"ech":             SvcParamECH,
+	"ipv6hint":        SvcParamIPv6Hint,
+}
+
+// SvcParam is one key=value parameter of an SVCB or HTTPS record, with
+// the value in wire format
+type SvcParam struct {
+	Key   uint16
+	Value []byte
+}
+
+// SVCB is the decoded RDATA of an SVCB or HTTPS record (RFC 9460)
+type SVCB struct {
+	Priority uint16 // 0 for AliasMode
+	Target   []byte // wire-format name; the root means the owner name
+	Params   []SvcParam
+}
+
+// ParseSVCB decodes SVCB or HTTPS RDATA. Parameters must appear in
+// strictly increasing key order.
+func ParseSVCB(rdata []byte) (*SVCB, error) {
+	if len(rdata) < 3 {
+		return nil, parseErrorf(Truncated, "SVCB RDATA too short")
+	}
+
+	svcb := &SVCB{Priority: binary.BigEndian.Uint16(rdata[0:2])}
+	target, n, err := DecodeName(rdata, 2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SVCB target: %w", err)
+	}
+	svcb.Target = target
+
+	data := rdata[2+n:]
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, parseErrorf(Truncated, "truncated SvcParam header")
+		}
+		key := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if 4+length > len(data) {
+			return nil, parseErrorf(Truncated, "SvcParam %d overruns record", key)
+		}
+		if len(svcb.Params) > 0 && key <= svcb.Params[len(svcb.Params)-1].Key {
+			return nil, fmt.Errorf("SvcParam %d out of order", key)
+		}
+		svcb.Params = append(svcb.Params, SvcParam{Key: key, Value: data[4 : 4+length]})
+		data = data[4+length:]
+	}
+
+	return svcb, nil
+}
+
+// Encode returns the record's RDATA, with the parameters sorted by key
+func (s *SVCB) Encode() []byte {
+	params := slices.Clone(s.Params)
+	slices.SortFunc(params, func(a, b SvcParam) int { return int(a.Key) - int(b.Key) })
+
+	buf := binary.BigEndian.AppendUint16(nil, s.Priority)
+	buf = append(buf, s.Target...)
+	for _, p := range params {
+		buf = binary.BigEndian.AppendUint16(buf, p.Key)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(p.Value)))
+		buf = append(buf, p.Value...)
+	}
+	return buf
+}
+
+// Param returns the value of the parameter with the given key
+func (s *SVCB) Param(key uint16) ([]byte, bool) {
+	for _, p := range s.Params {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ParseSVCBText parses the presentation form of SVCB or HTTPS RDATA,
+// e.g. "1 . alpn=h2,h3 port=443"
+func ParseSVCBText(fields []string) (*SVCB, error) {
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("SVCB needs <priority> <target> [<key>=<value>...]")
+	}
+
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SVCB priority %q", fields[0])
+	}
+	target, err := EncodeName(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	svcb := &SVCB{Priority: uint16(priority), Target: target}
+
+	for _, field := range fields[2:] {
+		name, value, _ := strings.Cut(field, "=")
+		key, err := parseSvcParamKey(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := svcb.Param(key); dup {
+			return nil, fmt.Errorf("duplicate SvcParam %q", name)
+		}
+		wire, err := svcParamValue(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", name, value, err)
+		}
+		svcb.Params = append(svcb.Params, SvcParam{Key: key, Value: wire})
+	}
+
+	return svcb, nil
+}
+
+// parseSvcParamKey returns the key for a name like "alpn" or "key65000"
+func parseSvcParamKey(name string) (uint16, error) {
+	if key, ok := svcParamKeyNames[name]; ok {
+		return key, nil
+	}
+	if n, ok := strings.CutPrefix(name, "key"); ok {
+		if key, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return uint16(key), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown SvcParam key %q", name)
+}
+
+// svcParamValue converts a parameter's presentation value to wire format
+func svcParamValue(key uint16, value string) ([]byte, error) {
+	switch key {
+	case SvcParamMandatory:
+		var wire []byte
+		for _, name := range strings.Split(value, ",") {
+			k, err := parseSvcParamKey(name)
+			if err != nil {
+				return nil, err
+			}
+			wire = binary.BigEndian.AppendUint16(wire, k)
+		}
+		return wire, nil
+	case SvcParamALPN:
+		return characterStrings(strings.Split(value, ","))
+	case SvcParamNoDefaultALPN:
+		if value != "" {
+			return nil, fmt.Errorf("takes no value")
+		}
+		return []byte{}, nil
+	case SvcParamPort:
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("not a port number")
+		}
+		return binary.BigEndian.AppendUint16(nil, uint16(port)), nil
+	case SvcParamIPv4Hint, SvcParamIPv6Hint:
+		var wire []byte
+		for _, s := range strings.Split(value, ",") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("not an IP address: %q", s)
+			}
+			var rdata []byte
+			var err error
+			if key == SvcParamIPv4Hint {
+				rdata, err = ipv4RData(ip)
+			} else {
+				rdata, err = ipv6RData(ip)
+			}
+			if err != nil {
+				return nil, err
+			}
+			wire = append(wire, rdata...)
+		}
+		return wire, nil
+	case SvcParamECH:
+		return base64.StdEncoding.DecodeString(value)
+	default:
+		return []byte(value), nil
+	}
+}
+
+// String returns the presentation form of the RDATA, e.g.
+// "1 . alpn=h2,h3 port=443"
+func (s *SVCB) String() string {
+	target := NameString(s.Target)
+	if target != "." {
+		target += "."
+	}
+	parts := []string{strconv.Itoa(int(s.Priority)), target}
+	for _, p := range s.Params {
+		parts = append(parts, svcParamString(p))
+	}
+	return strings.Join(parts, " ")
+}
+
+// svcParamKeyString returns the name of a key, or keyNNNN for unknown
+// ones
+func svcParamKeyString(key uint16) string {
+	for name, k := range svcParamKeyNames {
+		if k == key {
+			return name
+		}
+	}
+	return fmt.Sprintf("key%d", key)
+}
+
+// svcParamString returns the presentation form of a parameter. Values
+// that don't decode as their key's type are shown raw.
+func svcParamString(p SvcParam) string {
+	name := svcParamKeyString(p.Key)
+
+	var values []string
+	switch v := p.Value; {
+	case p.Key == SvcParamNoDefaultALPN && len(v) == 0:
+		return name
+	case p.Key == SvcParamMandatory && len(v)%2 == 0:
+		for ; len(v) > 0; v = v[2:] {
+			values = append(values, svcParamKeyString(binary.BigEndian.Uint16(v)))
+		}
+	case p.Key == SvcParamALPN:
+		for len(v) > 0 && int(v[0]) < len(v) {
+			values = append(values, string(v[1:1+v[0]]))
+			v = v[1+v[0]:]
+		}
+	case p.Key == SvcParamPort && len(v) == 2:
+		values = append(values, strconv.Itoa(int(binary.BigEndian.Uint16(v))))
+	case p.Key == SvcParamIPv4Hint && len(v)%net.IPv4len == 0:
+		for ; len(v) > 0; v = v[net.IPv4len:] {
+			values = append(values, net.IP(v[:net.IPv4len]).String())
+		}
+	case p.Key == SvcParamIPv6Hint && len(v)%net.IPv6len == 0:
+		for ; len(v) > 0; v = v[net.IPv6len:] {
+			values = append(values, net.IP(v[:net.IPv6len]).String())
+		}
+	case p.Key == SvcParamECH:
+		values = append(values, base64.StdEncoding.EncodeToString(v))
+	default:
+		values = append(values, string(v))
+	}
+	return name + "=" + strings.Join(values, ",")
+}
@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHTTPSRecordRoundTrip(t *testing.T) {
+	zone := parseZone(t, "example.com HTTPS 1 . alpn=h2,h3 port=8443\n")
+	response := query(t, "example.com", TypeHTTPS).BuildResponse(zone, nil)
+	if len(response.Answers) != 1 || response.Answers[0].Type != TypeHTTPS {
+		t.Fatalf("answers = %+v, want one HTTPS record", response.Answers)
+	}
+
+	// Through the wire and back
+	var parsed DNSMessage
+	if err := parsed.ParseComplete(response.Encode()); err != nil {
+		t.Fatalf("ParseComplete: %v", err)
+	}
+	rdata := parsed.Answers[0].RData
+	svcb, err := ParseSVCB(rdata)
+	if err != nil {
+		t.Fatalf("ParseSVCB: %v", err)
+	}
+
+	if svcb.Priority != 1 || !bytes.Equal(svcb.Target, []byte{0}) {
+		t.Errorf("priority %d target %q, want 1 and the root", svcb.Priority, svcb.Target)
+	}
+	if alpn, _ := svcb.Param(SvcParamALPN); string(alpn) != "\x02h2\x02h3" {
+		t.Errorf("alpn = %q, want h2,h3", alpn)
+	}
+	if port, _ := svcb.Param(SvcParamPort); string(port) != "\x20\xfb" {
+		t.Errorf("port = %x, want 8443", port)
+	}
+	if got, want := svcb.String(), "1 . alpn=h2,h3 port=8443"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if !bytes.Equal(svcb.Encode(), rdata) {
+		t.Errorf("Encode() = %x, want the parsed RDATA %x", svcb.Encode(), rdata)
+	}
+}
+
+func TestSVCBParamsAreSortedAndChecked(t *testing.T) {
+	svcb, err := ParseSVCBText([]string{"1", "svc.example.com", "port=53", "alpn=dot"})
+	if err != nil {
+		t.Fatalf("ParseSVCBText: %v", err)
+	}
+	parsed, err := ParseSVCB(svcb.Encode())
+	if err != nil {
+		t.Fatalf("ParseSVCB: %v", err)
+	}
+	if got, want := parsed.String(), "1 svc.example.com. alpn=dot port=53"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	for _, fields := range [][]string{
+		{"1"},
+		{"x", "."},
+		{"1", ".", "port=http"},
+		{"1", ".", "alpn=h2", "alpn=h3"},
+		{"1", ".", "bogus=1"},
+	} {
+		if _, err := ParseSVCBText(fields); err == nil {
+			t.Errorf("ParseSVCBText(%q) succeeded", fields)
+		}
+	}
+
+	// port (3) before alpn (1) on the wire
+	if _, err := ParseSVCB([]byte("\x00\x01\x00\x00\x03\x00\x02\x00\x35\x00\x01\x00\x01\x00")); err == nil {
+		t.Error("ParseSVCB accepted parameters out of order")
+	}
+}
@@ -0,0 +1,43 @@
+package dns
+
+// Record types
+const (
+	TypeA          uint16 = 1
+	TypeNS         uint16 = 2
+	TypeCNAME      uint16 = 5
+	TypeSOA        uint16 = 6
+	TypePTR        uint16 = 12
+	TypeHINFO      uint16 = 13
+	TypeMX         uint16 = 15
+	TypeTXT        uint16 = 16
+	TypeAAAA       uint16 = 28
+	TypeSRV        uint16 = 33
+	TypeNAPTR      uint16 = 35
+	TypeOPT        uint16 = 41
+	TypeDS         uint16 = 43
+	TypeSSHFP      uint16 = 44
+	TypeRRSIG      uint16 = 46
+	TypeNSEC       uint16 = 47
+	TypeDNSKEY     uint16 = 48
+	TypeNSEC3      uint16 = 50
+	TypeNSEC3PARAM uint16 = 51
+	TypeTLSA       uint16 = 52
+	TypeSVCB       uint16 = 64
+	TypeHTTPS      uint16 = 65
+	TypeAXFR       uint16 = 252 // zone transfer (RFC 5936), a query type only
+	TypeANY        uint16 = 255
+	TypeCAA        uint16 = 257
+)
+
+// Opcodes
+const (
+	OpcodeQuery  uint16 = 0
+	OpcodeUpdate uint16 = 5
+)
+
+// Record classes
+const (
+	ClassIN   uint16 = 1
+	ClassNONE uint16 = 254
+	ClassANY  uint16 = 255
+)
@@ -0,0 +1,260 @@
+package dns
+
+import "bytes"
+
+// DNS UPDATE (RFC 2136) reuses the message sections: the question
+// section holds the zone, the answer section the prerequisites and the
+// authority section the updates.
+
+// Update response codes (RFC 2136 section 2.2)
+const (
+	RCodeYXDomain uint16 = 6  // name exists when it should not
+	RCodeYXRRSet  uint16 = 7  // RRset exists when it should not
+	RCodeNXRRSet  uint16 = 8  // RRset that should exist does not
+	RCodeNotAuth  uint16 = 9  // server not authoritative for the zone
+	RCodeNotZone  uint16 = 10 // name not within the zone section's zone
+)
+
+// Update checks the prerequisites of an UPDATE message and, if they all
+// hold, applies its updates atomically. It returns the response code,
+// NOTAUTH when the zone section names no apex the zone holds an SOA for.
+func (z *Zone) Update(msg *DNSMessage) uint16 {
+	if len(msg.Questions) != 1 || msg.Questions[0].QType != TypeSOA {
+		return RCodeFormErr
+	}
+	zone := msg.Questions[0]
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if !hasType(z.records[string(CanonicalName(zone.QName))], TypeSOA) {
+		return RCodeNotAuth
+	}
+
+	// Value-dependent prerequisites are gathered into RRsets, each of
+	// which must match the zone's exactly (RFC 2136 section 3.2.5)
+	var sets []rrset
+	for _, rr := range msg.Answers {
+		if rcode := z.checkPrerequisite(zone, rr); rcode != RCodeNoError {
+			return rcode
+		}
+		if rr.Class == zone.QClass {
+			sets = addToRRSet(sets, rr)
+		}
+	}
+	for _, set := range sets {
+		if !set.matches(z.records[set.key]) {
+			return RCodeNXRRSet
+		}
+	}
+
+	// Validate every update before applying any, so a bad one leaves the
+	// zone untouched
+	for _, rr := range msg.Authorities {
+		if rcode := checkUpdate(zone, rr); rcode != RCodeNoError {
+			return rcode
+		}
+	}
+	for _, rr := range msg.Authorities {
+		z.applyUpdate(zone, rr)
+	}
+
+	return RCodeNoError
+}
+
+// checkPrerequisite evaluates one prerequisite (RFC 2136 section 3.2)
+func (z *Zone) checkPrerequisite(zone Question, rr DNSAnswer) uint16 {
+	if rr.TTL != 0 {
+		return RCodeFormErr
+	}
+	if !IsSubdomain(rr.Name, zone.QName) {
+		return RCodeNotZone
+	}
+
+	records, exists := z.records[string(CanonicalName(rr.Name))]
+	switch {
+	case rr.Class == ClassANY && rr.Type == TypeANY:
+		// Name is in use
+		if !exists {
+			return RCodeNXDomain
+		}
+	case rr.Class == ClassANY:
+		// RRset exists (value independent)
+		if !hasType(records, rr.Type) {
+			return RCodeNXRRSet
+		}
+	case rr.Class == ClassNONE && rr.Type == TypeANY:
+		// Name is not in use
+		if exists {
+			return RCodeYXDomain
+		}
+	case rr.Class == ClassNONE:
+		// RRset does not exist
+		if hasType(records, rr.Type) {
+			return RCodeYXRRSet
+		}
+	case rr.Class == zone.QClass:
+		// RRset exists (value dependent) - Update compares the whole
+		// RRset once every prerequisite is in
+		if rr.Type == TypeANY {
+			return RCodeFormErr
+		}
+	default:
+		return RCodeFormErr
+	}
+
+	return RCodeNoError
+}
+
+// rrset is an RRset built from value-dependent prerequisites
+type rrset struct {
+	key     string // the canonical owner name
+	rtype   uint16
+	records []Record
+}
+
+// addToRRSet adds rr to its RRset in sets, starting one if needed.
+// Duplicate records are ignored.
+func addToRRSet(sets []rrset, rr DNSAnswer) []rrset {
+	key := string(CanonicalName(rr.Name))
+	r := Record{Type: rr.Type, RData: rr.RData}
+	for i := range sets {
+		if sets[i].key == key && sets[i].rtype == rr.Type {
+			if !hasRecord(sets[i].records, r) {
+				sets[i].records = append(sets[i].records, r)
+			}
+			return sets
+		}
+	}
+	return append(sets, rrset{key: key, rtype: rr.Type, records: []Record{r}})
+}
+
+// matches reports whether the name's records of the set's type are
+// exactly the set's records
+func (set rrset) matches(records []Record) bool {
+	count := 0
+	for _, r := range records {
+		if r.Type != set.rtype {
+			continue
+		}
+		if !hasRecord(set.records, r) {
+			return false
+		}
+		count++
+	}
+	return count == len(set.records)
+}
+
+// checkUpdate validates one update record (RFC 2136 section 3.4.1)
+func checkUpdate(zone Question, rr DNSAnswer) uint16 {
+	if !IsSubdomain(rr.Name, zone.QName) {
+		return RCodeNotZone
+	}
+
+	switch rr.Class {
+	case zone.QClass:
+		if rr.Type == TypeANY {
+			return RCodeFormErr
+		}
+	case ClassANY:
+		if rr.TTL != 0 || len(rr.RData) != 0 {
+			return RCodeFormErr
+		}
+	case ClassNONE:
+		if rr.TTL != 0 || rr.Type == TypeANY {
+			return RCodeFormErr
+		}
+	default:
+		return RCodeFormErr
+	}
+
+	return RCodeNoError
+}
+
+// applyUpdate applies one validated update record (RFC 2136 section 3.4.2).
+// Deletes that would remove the apex SOA or NS RRset, or its last NS
+// record, are ignored (section 3.4.2.4).
+func (z *Zone) applyUpdate(zone Question, rr DNSAnswer) {
+	key := string(CanonicalName(rr.Name))
+	records, existed := z.records[key]
+	apex := key == string(CanonicalName(zone.QName))
+
+	switch {
+	case rr.Class == zone.QClass:
+		// Add to an RRset, ignoring duplicates
+		r := Record{Type: rr.Type, TTL: rr.TTL, RData: bytes.Clone(rr.RData)}
+		if !hasRecord(records, r) {
+			records = append(records, r)
+		}
+	case rr.Class == ClassANY && rr.Type == TypeANY:
+		// Delete all RRsets from the name, bar the apex SOA and NS
+		records = filterRecords(records, func(r Record) bool {
+			return !apex || (r.Type != TypeSOA && r.Type != TypeNS)
+		})
+	case rr.Class == ClassANY:
+		// Delete an RRset
+		if apex && (rr.Type == TypeSOA || rr.Type == TypeNS) {
+			return
+		}
+		records = filterRecords(records, func(r Record) bool { return r.Type == rr.Type })
+	case rr.Class == ClassNONE:
+		// Delete a single RR
+		if apex && rr.Type == TypeSOA {
+			return
+		}
+		target := Record{Type: rr.Type, RData: rr.RData}
+		kept := filterRecords(records, func(r Record) bool { return r.equal(target) })
+		if apex && rr.Type == TypeNS && !hasType(kept, TypeNS) {
+			return
+		}
+		records = kept
+	}
+
+	if len(records) == 0 {
+		if existed {
+			z.indexName(key, -1)
+		}
+		delete(z.records, key)
+		return
+	}
+	if !existed {
+		z.indexName(key, 1)
+	}
+	z.records[key] = records
+}
+
+// equal reports whether two records have the same type and RDATA
+func (r Record) equal(other Record) bool {
+	return r.Type == other.Type && bytes.Equal(r.RData, other.RData)
+}
+
+// hasType reports whether any record has the given type
+func hasType(records []Record, rtype uint16) bool {
+	for _, r := range records {
+		if r.Type == rtype {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRecord reports whether records contains target
+func hasRecord(records []Record, target Record) bool {
+	for _, r := range records {
+		if r.equal(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRecords returns the records for which drop is false
+func filterRecords(records []Record, drop func(Record) bool) []Record {
+	var kept []Record
+	for _, r := range records {
+		if !drop(r) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,532 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Record is a resource record held in a Zone
+type Record struct {
+	Type  uint16
+	TTL   uint32
+	RData []byte
+}
+
+// defaultZoneTTL is the TTL of records added without one
+const defaultZoneTTL = 60
+
+// maxCNAMEHops bounds how many CNAMEs Answer follows within a zone
+const maxCNAMEHops = 8
+
+// Zone is an in-memory store of authoritative records
+type Zone struct {
+	// RotateAnswers cycles the starting record of multi-record answers
+	// on every lookup for simple round-robin load balancing
+	RotateAnswers bool
+
+	// DefaultTTL is given to records added by the Add helpers, and to
+	// zone file records without their own TTL (defaults to 60)
+	DefaultTTL uint32
+
+	mu      sync.Mutex
+	records map[string][]Record // keyed by wire-format name
+	names   map[string]int      // owner names at or below each name
+	next    map[string]int      // rotation offset per name and type
+}
+
+// NewZone creates an empty zone
+func NewZone() *Zone {
+	return &Zone{
+		DefaultTTL: defaultZoneTTL,
+		records:    make(map[string][]Record),
+		names:      make(map[string]int),
+		next:       make(map[string]int),
+	}
+}
+
+// Add stores a record for name (dotted form, e.g. "www.example.com")
+func (z *Zone) Add(name string, r Record) error {
+	wire, err := EncodeName(name)
+	if err != nil {
+		return err
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	key := string(CanonicalName(wire))
+	if _, ok := z.records[key]; !ok {
+		z.indexName(key, 1)
+	}
+	z.records[key] = append(z.records[key], r)
+	return nil
+}
+
+// AddA stores an A record for name
+func (z *Zone) AddA(name string, ip net.IP) error {
+	rdata, err := ipv4RData(ip)
+	if err != nil {
+		return err
+	}
+	return z.Add(name, Record{Type: TypeA, TTL: z.DefaultTTL, RData: rdata})
+}
+
+// AddAAAA stores an AAAA record for name
+func (z *Zone) AddAAAA(name string, ip net.IP) error {
+	rdata, err := ipv6RData(ip)
+	if err != nil {
+		return err
+	}
+	return z.Add(name, Record{Type: TypeAAAA, TTL: z.DefaultTTL, RData: rdata})
+}
+
+// AddRaw stores a record of any type from its raw RDATA, for types the
+// zone has no dedicated syntax for
+func (z *Zone) AddRaw(name string, rtype uint16, rdata []byte) error {
+	return z.Add(name, Record{Type: rtype, TTL: z.DefaultTTL, RData: rdata})
+}
+
+// AddCNAME stores a CNAME record pointing name at target
+func (z *Zone) AddCNAME(name, target string) error {
+	wire, err := EncodeName(target)
+	if err != nil {
+		return err
+	}
+	return z.Add(name, Record{Type: TypeCNAME, TTL: z.DefaultTTL, RData: wire})
+}
+
+// AddNS stores an NS record delegating name (typically the zone apex)
+// to the nameserver host
+func (z *Zone) AddNS(name, host string) error {
+	wire, err := EncodeName(host)
+	if err != nil {
+		return err
+	}
+	return z.Add(name, Record{Type: TypeNS, TTL: z.DefaultTTL, RData: wire})
+}
+
+// ipv4RData returns the RDATA of an A record
+func ipv4RData(ip net.IP) ([]byte, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("not an IPv4 address: %v", ip)
+	}
+	return ip4, nil
+}
+
+// ipv6RData returns the RDATA of an AAAA record
+func ipv6RData(ip net.IP) ([]byte, error) {
+	if ip.To4() != nil || ip.To16() == nil {
+		return nil, fmt.Errorf("not an IPv6 address: %v", ip)
+	}
+	return ip.To16(), nil
+}
+
+// Answer builds the answer records for a question. When the name only
+// has a CNAME, the chain is followed within the zone and every link is
+// returned followed by the target's records. exists is false when the
+// queried name is not in the zone at all (NXDOMAIN).
+func (z *Zone) Answer(q Question) (answers []DNSAnswer, exists bool) {
+	name := q.QName
+	for hops := 0; ; hops++ {
+		records, found := z.Lookup(name, q.QType)
+		if !found {
+			// A dangling chain still answers with the links so far
+			return answers, hops > 0
+		}
+
+		if len(records) > 0 || q.QType == TypeCNAME {
+			for _, r := range records {
+				answers = append(answers, newAnswer(name, q.QClass, r))
+			}
+			return answers, true
+		}
+
+		cnames, _ := z.Lookup(name, TypeCNAME)
+		if len(cnames) == 0 || hops == maxCNAMEHops {
+			return answers, true
+		}
+
+		answers = append(answers, newAnswer(name, q.QClass, cnames[0]))
+		name = cnames[0].RData
+	}
+}
+
+// SOA returns the SOA record of the closest enclosing zone apex of a
+// wire-format name, for the authority section of negative answers. Its
+// TTL is capped by the MINIMUM field (RFC 2308 section 3).
+func (z *Zone) SOA(name []byte, class uint16) (DNSAnswer, bool) {
+	for offset := 0; offset < len(name); offset += int(name[offset]) + 1 {
+		apex := name[offset:]
+		if records, _ := z.Lookup(apex, TypeSOA); len(records) > 0 {
+			soa := newAnswer(apex, class, records[0])
+			if n := len(soa.RData); n >= 4 {
+				soa.TTL = min(soa.TTL, binary.BigEndian.Uint32(soa.RData[n-4:]))
+			}
+			return soa, true
+		}
+		if name[offset] == 0 {
+			break
+		}
+	}
+	return DNSAnswer{}, false
+}
+
+// Replace swaps the zone's records for those of other in one step, so
+// lookups see either the old contents or the new, e.g. when reloading
+// the zone file. Settings like RotateAnswers are kept.
+func (z *Zone) Replace(other *Zone) {
+	other.mu.Lock()
+	records, names := other.records, other.names
+	other.mu.Unlock()
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.records, z.names = records, names
+	z.next = make(map[string]int)
+}
+
+// Transfer returns the contents of the zone at apex in AXFR order
+// (RFC 5936 section 2.2): the apex SOA, every other record at or below
+// apex, then the SOA again. ok is false when apex has no SOA.
+func (z *Zone) Transfer(apex []byte, class uint16) (records []DNSAnswer, ok bool) {
+	soas, _ := z.Lookup(apex, TypeSOA)
+	if len(soas) == 0 {
+		return nil, false
+	}
+	soa := newAnswer(apex, class, soas[0])
+
+	z.mu.Lock()
+	names := make([]string, 0, len(z.records))
+	for name := range z.records {
+		if IsSubdomain([]byte(name), apex) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	records = append(records, soa)
+	canonicalApex := string(CanonicalName(apex))
+	for _, name := range names {
+		for _, r := range z.records[name] {
+			if r.Type == TypeSOA && name == canonicalApex {
+				continue
+			}
+			records = append(records, newAnswer([]byte(name), class, r))
+		}
+	}
+	z.mu.Unlock()
+
+	return append(records, soa), true
+}
+
+// newAnswer builds an answer record for name from a stored record
+func newAnswer(name []byte, class uint16, r Record) DNSAnswer {
+	return DNSAnswer{
+		Name:     name,
+		Type:     r.Type,
+		Class:    class,
+		TTL:      r.TTL,
+		RDLength: uint16(len(r.RData)),
+		RData:    r.RData,
+	}
+}
+
+// Lookup returns the records of type qtype for a wire-format name,
+// synthesized from a wildcard if the name itself isn't in the zone.
+// exists reports whether the name has any records at all, so callers
+// can tell NXDOMAIN (no such name) from NODATA (no such type).
+func (z *Zone) Lookup(name []byte, qtype uint16) (records []Record, exists bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	owner := CanonicalName(name)
+	all, exists := z.records[string(owner)]
+	switch {
+	case exists:
+	case z.nameExists(owner):
+		// An empty non-terminal exists, with no records of any type
+		exists = true
+	default:
+		owner, all, exists = z.wildcard(owner)
+	}
+
+	var matches []Record
+	for _, r := range all {
+		if r.Type == qtype {
+			matches = append(matches, r)
+		}
+	}
+
+	// Rotation follows the RRset's owner, so names synthesized from a
+	// wildcard share one offset rather than adding one each
+	if z.RotateAnswers && len(matches) > 1 {
+		key := fmt.Sprintf("%s/%d", owner, qtype)
+		start := z.next[key] % len(matches)
+		z.next[key] = start + 1
+
+		rotated := make([]Record, 0, len(matches))
+		rotated = append(rotated, matches[start:]...)
+		matches = append(rotated, matches[:start]...)
+	}
+
+	return matches, exists
+}
+
+// wildcard returns the wildcard owner name and records matching a
+// canonical name that isn't in the zone (RFC 4592 section 3.3.1): those
+// of "*" below the closest encloser, the nearest ancestor that exists,
+// if only as an empty non-terminal. A name below an existing name
+// therefore never matches a wildcard further up. Called with z.mu held.
+func (z *Zone) wildcard(name []byte) ([]byte, []Record, bool) {
+	for offset := int(name[0]) + 1; offset < len(name); offset += int(name[offset]) + 1 {
+		ancestor := name[offset:]
+		if !z.nameExists(ancestor) {
+			continue
+		}
+		owner := "\x01*" + string(ancestor)
+		records, ok := z.records[owner]
+		return []byte(owner), records, ok
+	}
+	return nil, nil, false
+}
+
+// nameExists reports whether a canonical name owns records or has
+// descendants that do. Called with z.mu held.
+func (z *Zone) nameExists(name []byte) bool {
+	return z.names[string(name)] > 0
+}
+
+// indexName counts a canonical owner name gaining (delta 1) or losing
+// (delta -1) its last record against the name and each of its
+// ancestors, which nameExists consults. Called with z.mu held.
+func (z *Zone) indexName(name string, delta int) {
+	for offset := 0; offset < len(name); offset += int(name[offset]) + 1 {
+		suffix := name[offset:]
+		z.names[suffix] += delta
+		if z.names[suffix] <= 0 {
+			delete(z.names, suffix)
+		}
+	}
+}
+
+// LoadZoneFile reads a zone from a file, see ParseZone for the format
+func LoadZoneFile(path string) (*Zone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseZone(f)
+}
+
+// ParseZone reads a zone with one record per line:
+//
+//	<name> [<ttl>] <type> <rdata>
+//
+// e.g. "www.example.com 300 A 192.0.2.1", "example.com NS
+// ns1.example.com" for the apex nameservers or "example.com HTTPS 1 .
+// alpn=h2,h3". Records without a TTL get the zone default, which a
+// "$TTL <seconds>" line sets for the lines after it. Repeating a name
+// adds another record. Any type, including unknown ones written as
+// TYPEnnn, can use the RFC 3597 generic RDATA form, e.g.
+// "example.com TYPE99 \# 4 c0a80101". Blank lines and lines starting
+// with ';' or '#' are ignored.
+func ParseZone(r io.Reader) (*Zone, error) {
+	zone := NewZone()
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if fields[0] == "$TTL" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected $TTL <seconds>", lineNo)
+			}
+			ttl, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid TTL %q", lineNo, fields[1])
+			}
+			zone.DefaultTTL = uint32(ttl)
+			continue
+		}
+
+		// The TTL column is optional
+		ttl := zone.DefaultTTL
+		if len(fields) > 1 {
+			if explicit, err := strconv.ParseUint(fields[1], 10, 32); err == nil {
+				ttl = uint32(explicit)
+				fields = append(fields[:1], fields[2:]...)
+			}
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected <name> [<ttl>] <type> <rdata>", lineNo)
+		}
+
+		name, rdata := fields[0], fields[2:]
+		rtype, err := ParseType(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+
+		var raw []byte
+		switch {
+		case rdata[0] == `\#`:
+			// RFC 3597 generic form works for any type: \# <length> <hex>
+			raw, err = parseGenericRData(rdata)
+		case rtype == TypeA:
+			ip := net.ParseIP(rdata[0])
+			if ip == nil {
+				return nil, fmt.Errorf("line %d: invalid IP address %q", lineNo, rdata[0])
+			}
+			raw, err = ipv4RData(ip)
+		case rtype == TypeAAAA:
+			ip := net.ParseIP(rdata[0])
+			if ip == nil {
+				return nil, fmt.Errorf("line %d: invalid IP address %q", lineNo, rdata[0])
+			}
+			raw, err = ipv6RData(ip)
+		case rtype == TypeCNAME || rtype == TypeNS || rtype == TypePTR:
+			raw, err = EncodeName(rdata[0])
+		case rtype == TypeHINFO:
+			if len(rdata) != 2 {
+				return nil, fmt.Errorf("line %d: HINFO needs <cpu> <os>", lineNo)
+			}
+			raw, err = characterStrings(rdata)
+		case rtype == TypeSVCB || rtype == TypeHTTPS:
+			var svcb *SVCB
+			if svcb, err = ParseSVCBText(rdata); err == nil {
+				raw = svcb.Encode()
+			}
+		default:
+			return nil, fmt.Errorf("line %d: type %s needs the generic \\# syntax", lineNo, fields[1])
+		}
+		if err == nil {
+			err = zone.Add(name, Record{Type: rtype, TTL: ttl, RData: raw})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return zone, nil
+}
+
+// typeNames maps record type mnemonics to their codes
+var typeNames = map[string]uint16{
+	"A":          TypeA,
+	"NS":         TypeNS,
+	"CNAME":      TypeCNAME,
+	"SOA":        TypeSOA,
+	"PTR":        TypePTR,
+	"HINFO":      TypeHINFO,
+	"MX":         TypeMX,
+	"TXT":        TypeTXT,
+	"AAAA":       TypeAAAA,
+	"SRV":        TypeSRV,
+	"NAPTR":      TypeNAPTR,
+	"DS":         TypeDS,
+	"SSHFP":      TypeSSHFP,
+	"RRSIG":      TypeRRSIG,
+	"NSEC":       TypeNSEC,
+	"DNSKEY":     TypeDNSKEY,
+	"NSEC3":      TypeNSEC3,
+	"NSEC3PARAM": TypeNSEC3PARAM,
+	"TLSA":       TypeTLSA,
+	"SVCB":       TypeSVCB,
+	"HTTPS":      TypeHTTPS,
+	"ANY":        TypeANY,
+	"CAA":        TypeCAA,
+}
+
+// ParseType converts a type mnemonic ("A", "AAAA") or the generic
+// "TYPEnnn" form to a record type code
+func ParseType(s string) (uint16, error) {
+	s = strings.ToUpper(s)
+	if t, ok := typeNames[s]; ok {
+		return t, nil
+	}
+	if num, ok := strings.CutPrefix(s, "TYPE"); ok {
+		t, err := strconv.ParseUint(num, 10, 16)
+		if err == nil {
+			return uint16(t), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+// KnownType reports whether the server knows the record type by name
+func KnownType(t uint16) bool {
+	for _, code := range typeNames {
+		if code == t {
+			return true
+		}
+	}
+	return false
+}
+
+// TypeString returns the mnemonic for a record type, or the generic
+// "TYPEnnn" form for types without one
+func TypeString(t uint16) string {
+	for name, code := range typeNames {
+		if code == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// parseGenericRData decodes RFC 3597 RDATA: \# <length> <hex words...>
+func parseGenericRData(fields []string) ([]byte, error) {
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("generic RDATA needs a length")
+	}
+	length, err := strconv.Atoi(fields[1])
+	if err != nil || length < 0 || length > 65535 {
+		return nil, fmt.Errorf("invalid generic RDATA length %q", fields[1])
+	}
+
+	rdata, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic RDATA hex: %v", err)
+	}
+	if len(rdata) != length {
+		return nil, fmt.Errorf("generic RDATA is %d bytes, expected %d", len(rdata), length)
+	}
+
+	return rdata, nil
+}
+
+// characterStrings encodes each field as a <character-string>,
+// dropping surrounding quotes
+func characterStrings(fields []string) ([]byte, error) {
+	var rdata []byte
+	for _, f := range fields {
+		f = strings.Trim(f, `"`)
+		if len(f) > 255 {
+			return nil, fmt.Errorf("character-string too long: %q", f)
+		}
+		rdata = append(rdata, byte(len(f)))
+		rdata = append(rdata, f...)
+	}
+	return rdata, nil
+}
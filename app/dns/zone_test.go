@@ -0,0 +1,307 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// mustName encodes a dotted name, failing the test if it's invalid
+func mustName(t *testing.T, name string) []byte {
+	t.Helper()
+	wire, err := EncodeName(name)
+	if err != nil {
+		t.Fatalf("EncodeName(%q): %v", name, err)
+	}
+	return wire
+}
+
+// parseZone parses a zone file's text, failing the test on error
+func parseZone(t *testing.T, text string) *Zone {
+	t.Helper()
+	zone, err := ParseZone(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("ParseZone: %v", err)
+	}
+	return zone
+}
+
+// answerIPs returns the addresses of the A and AAAA records in answers
+func answerIPs(answers []DNSAnswer) []string {
+	var ips []string
+	for _, a := range answers {
+		if ip, ok := a.IP(); ok {
+			ips = append(ips, ip.String())
+		}
+	}
+	return ips
+}
+
+// roundRobinZone holds three A records for www.example.com
+func roundRobinZone(t *testing.T) *Zone {
+	zone := NewZone()
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		if err := zone.AddA("www.example.com", net.ParseIP(ip)); err != nil {
+			t.Fatalf("AddA: %v", err)
+		}
+	}
+	return zone
+}
+
+func TestZoneAnswerReturnsEveryRecord(t *testing.T) {
+	zone := roundRobinZone(t)
+
+	answers, exists := zone.Answer(Question{QName: mustName(t, "www.example.com"), QType: TypeA, QClass: ClassIN})
+	if !exists {
+		t.Fatal("name does not exist")
+	}
+	got := answerIPs(answers)
+	slices.Sort(got)
+	if want := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}; !slices.Equal(got, want) {
+		t.Errorf("answers = %v, want %v", got, want)
+	}
+}
+
+func TestZoneRotateAnswers(t *testing.T) {
+	zone := roundRobinZone(t)
+	zone.RotateAnswers = true
+	q := Question{QName: mustName(t, "www.example.com"), QType: TypeA, QClass: ClassIN}
+
+	first, _ := zone.Answer(q)
+	second, _ := zone.Answer(q)
+	if slices.Equal(answerIPs(first), answerIPs(second)) {
+		t.Errorf("successive answers have the same order %v", answerIPs(first))
+	}
+	if len(second) != 3 {
+		t.Errorf("rotated answer has %d records, want 3", len(second))
+	}
+
+	// Every record leads once per full rotation
+	leaders := map[string]bool{answerIPs(first)[0]: true, answerIPs(second)[0]: true}
+	third, _ := zone.Answer(q)
+	leaders[answerIPs(third)[0]] = true
+	if len(leaders) != 3 {
+		t.Errorf("three lookups were led by %d distinct records, want 3", len(leaders))
+	}
+}
+
+func TestZoneRotateWildcardAnswers(t *testing.T) {
+	zone := parseZone(t, "*.example.com A 192.0.2.1\n*.example.com A 192.0.2.2\n")
+	zone.RotateAnswers = true
+
+	var leaders []string
+	for i := range 4 {
+		records, _ := zone.Lookup(mustName(t, fmt.Sprintf("host%d.example.com", i)), TypeA)
+		leaders = append(leaders, net.IP(records[0].RData).String())
+	}
+	if want := []string{"192.0.2.1", "192.0.2.2", "192.0.2.1", "192.0.2.2"}; !slices.Equal(leaders, want) {
+		t.Errorf("lookups were led by %v, want %v", leaders, want)
+	}
+	if len(zone.next) != 1 {
+		t.Errorf("zone keeps %d rotation offsets, want 1 for the wildcard", len(zone.next))
+	}
+}
+
+func TestZoneAnswerFollowsCNAMEChain(t *testing.T) {
+	zone := parseZone(t, `
+www.example.com CNAME web.example.com
+web.example.com CNAME host.example.net
+host.example.net A 192.0.2.7
+`)
+
+	answers, exists := zone.Answer(Question{QName: mustName(t, "WWW.example.com"), QType: TypeA, QClass: ClassIN})
+	if !exists {
+		t.Fatal("name does not exist")
+	}
+
+	want := []struct {
+		owner string
+		rtype uint16
+	}{
+		{"www.example.com", TypeCNAME},
+		{"web.example.com", TypeCNAME},
+		{"host.example.net", TypeA},
+	}
+	if len(answers) != len(want) {
+		t.Fatalf("got %d answers, want %d", len(answers), len(want))
+	}
+	for i, w := range want {
+		if !strings.EqualFold(NameString(answers[i].Name), w.owner) || answers[i].Type != w.rtype {
+			t.Errorf("answer %d is %s type %d, want %s type %d",
+				i, NameString(answers[i].Name), answers[i].Type, w.owner, w.rtype)
+		}
+	}
+	if ips := answerIPs(answers); !slices.Equal(ips, []string{"192.0.2.7"}) {
+		t.Errorf("addresses = %v", ips)
+	}
+}
+
+func TestZoneAnswerStopsAtCNAMELoop(t *testing.T) {
+	zone := parseZone(t, `
+a.example.com CNAME b.example.com
+b.example.com CNAME a.example.com
+`)
+
+	answers, _ := zone.Answer(Question{QName: mustName(t, "a.example.com"), QType: TypeA, QClass: ClassIN})
+	if len(answers) > maxCNAMEHops+1 {
+		t.Errorf("followed a CNAME loop for %d records", len(answers))
+	}
+}
+
+func TestZoneGenericRData(t *testing.T) {
+	zone := parseZone(t, "example.com 60 TYPE99 \\# 4 c0a80101\n"+
+		"example.com 60 HINFO \"x86\" linux\n")
+
+	tests := []struct {
+		qtype uint16
+		rdata string
+	}{
+		{99, "\xc0\xa8\x01\x01"},
+		{TypeHINFO, "\x03x86\x05linux"},
+	}
+	for _, tt := range tests {
+		t.Run(TypeString(tt.qtype), func(t *testing.T) {
+			answers, _ := zone.Answer(Question{QName: mustName(t, "example.com"), QType: tt.qtype, QClass: ClassIN})
+			if len(answers) != 1 {
+				t.Fatalf("got %d answers, want 1", len(answers))
+			}
+			want := "\x07example\x03com\x00" + string([]byte{byte(tt.qtype >> 8), byte(tt.qtype), 0, 1, 0, 0, 0, 60, 0, byte(len(tt.rdata))}) + tt.rdata
+			if got := answers[0].Encode(); string(got) != want {
+				t.Errorf("Encode() = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestZoneAnswersApexNS(t *testing.T) {
+	zone := parseZone(t, "example.com NS ns1.example.com\n"+
+		"example.com NS ns2.example.com\n"+
+		"ns1.example.com A 192.0.2.53\n")
+
+	response := query(t, "example.com", TypeNS).BuildResponse(zone, nil)
+	var hosts []string
+	for _, a := range response.Answers {
+		if a.Type == TypeNS {
+			hosts = append(hosts, NameString(a.RData))
+		}
+	}
+	slices.Sort(hosts)
+	if want := []string{"ns1.example.com", "ns2.example.com"}; !slices.Equal(hosts, want) {
+		t.Errorf("NS answers = %v, want %v", hosts, want)
+	}
+	if response.Header.Flags&FlagAA == 0 {
+		t.Error("apex NS answer is not authoritative")
+	}
+}
+
+func TestZoneRecordTTLs(t *testing.T) {
+	zone := parseZone(t, "www.example.com 30 A 192.0.2.1\n"+
+		"$TTL 600\n"+
+		"mail.example.com A 192.0.2.2\n"+
+		"ftp.example.com 7200 A 192.0.2.3\n")
+
+	tests := []struct {
+		name string
+		want uint32
+	}{
+		{"www.example.com", 30},
+		{"mail.example.com", 600},
+		{"ftp.example.com", 7200},
+	}
+	for _, tt := range tests {
+		response := query(t, tt.name, TypeA).BuildResponse(zone, nil)
+		if len(response.Answers) != 1 || response.Answers[0].TTL != tt.want {
+			t.Errorf("%s: answers %+v, want one with TTL %d", tt.name, response.Answers, tt.want)
+		}
+	}
+
+	// Records before any $TTL line get the zone's built-in default
+	defaulted := parseZone(t, "www.example.com A 192.0.2.1\n")
+	answers, _ := defaulted.Answer(Question{QName: mustName(t, "www.example.com"), QType: TypeA, QClass: ClassIN})
+	if len(answers) != 1 || answers[0].TTL != defaulted.DefaultTTL {
+		t.Errorf("answers %+v, want one with the default TTL %d", answers, defaulted.DefaultTTL)
+	}
+}
+
+func TestZoneWildcards(t *testing.T) {
+	zone := parseZone(t, "*.example.com A 192.0.2.1\n"+
+		"www.example.com A 192.0.2.2\n"+
+		"host.sub.example.com A 192.0.2.3\n")
+
+	tests := []struct {
+		name   string
+		want   []string
+		exists bool
+	}{
+		{"anything.example.com", []string{"192.0.2.1"}, true},
+		{"Deep.Below.example.com", []string{"192.0.2.1"}, true},
+		// An exact record wins over the wildcard
+		{"www.example.com", []string{"192.0.2.2"}, true},
+		// sub.example.com exists as an empty non-terminal: no records,
+		// and it closes off the wildcard for the names below it
+		{"sub.example.com", nil, true},
+		{"other.sub.example.com", nil, false},
+		// Likewise below a name that owns records
+		{"a.www.example.com", nil, false},
+		{"example.org", nil, false},
+	}
+	for _, tt := range tests {
+		records, exists := zone.Lookup(mustName(t, tt.name), TypeA)
+		var ips []string
+		for _, r := range records {
+			ips = append(ips, net.IP(r.RData).String())
+		}
+		if !slices.Equal(ips, tt.want) || exists != tt.exists {
+			t.Errorf("Lookup(%s) = %v, %v, want %v, %v", tt.name, ips, exists, tt.want, tt.exists)
+		}
+	}
+
+	// Synthesized answers carry the queried name
+	response := query(t, "anything.example.com", TypeA).BuildResponse(zone, nil)
+	if len(response.Answers) != 1 || NameString(response.Answers[0].Name) != "anything.example.com" {
+		t.Errorf("answers = %+v, want one owned by anything.example.com", response.Answers)
+	}
+}
+
+func TestZoneNameIndexFollowsChanges(t *testing.T) {
+	zone := parseZone(t, "*.example.com A 192.0.2.1\n")
+	if err := zone.AddRaw("example.com", TypeSOA, nil); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(name string) bool {
+		records, _ := zone.Lookup(mustName(t, name), TypeA)
+		return len(records) > 0
+	}
+	if !lookup("a.b.example.com") {
+		t.Fatal("a.b.example.com didn't match the wildcard")
+	}
+
+	// Adding b.example.com makes it the closest encloser
+	if err := zone.AddA("b.example.com", net.IPv4(192, 0, 2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if lookup("a.b.example.com") {
+		t.Error("a.b.example.com matched the wildcard below an existing name")
+	}
+
+	// Deleting it through an UPDATE opens the wildcard again
+	update := query(t, "example.com", TypeSOA)
+	update.AddAuthority(DNSAnswer{Name: mustName(t, "b.example.com"), Type: TypeANY, Class: ClassANY})
+	if rcode := zone.Update(update); rcode != RCodeNoError {
+		t.Fatalf("Update: RCODE %d", rcode)
+	}
+	if !lookup("a.b.example.com") {
+		t.Error("a.b.example.com didn't match the wildcard after b.example.com was deleted")
+	}
+
+	// Replace brings the other zone's names along
+	zone.Replace(parseZone(t, "*.example.com A 192.0.2.1\nx.b.example.com A 192.0.2.3\n"))
+	if lookup("a.b.example.com") {
+		t.Error("a.b.example.com matched the wildcard below a replaced-in empty non-terminal")
+	}
+	if !lookup("a.c.example.com") {
+		t.Error("a.c.example.com didn't match the wildcard after Replace")
+	}
+}
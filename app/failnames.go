@@ -0,0 +1,16 @@
+package main
+
+import "github.com/codecrafters-io/dns-server-starter-go/app/dns"
+
+// isFailName reports whether any of the request's questions asks for
+// one of FailNames
+func (s *DNSServer) isFailName(request *dns.DNSMessage) bool {
+	for _, name := range s.FailNames {
+		for _, q := range request.Questions {
+			if q.Matches(name, dns.TypeANY) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestFailNames(t *testing.T) {
+	var forwarded []string
+	server := newTestServer(t)
+	server.FailNames = []string{"broken.example"}
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		forwarded = append(forwarded, query.Questions[0].Name())
+		return reply(query, aRecord(t, query.Questions[0].Name(), "192.0.2.1", 300)), nil
+	})
+
+	tests := []struct {
+		name  string
+		rcode uint16
+	}{
+		{"broken.example", dns.RCodeServFail},
+		{"BROKEN.example", dns.RCodeServFail},
+		{"working.example", dns.RCodeNoError},
+	}
+	for _, tt := range tests {
+		response := exchange(t, server, newQuery(t, tt.name, dns.TypeA))
+		if rcode := response.Header.RCode(); rcode != tt.rcode {
+			t.Errorf("%s: RCODE = %d, want %d", tt.name, rcode, tt.rcode)
+		}
+	}
+	if len(forwarded) != 1 || forwarded[0] != "working.example" {
+		t.Errorf("forwarded %v, want only working.example", forwarded)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// WithForwardZone forwards queries for names at or below zone to the
+// resolver at addr instead of the default one, for split DNS setups
+// (e.g. "corp.internal" to an internal resolver)
+func WithForwardZone(zone, addr string) Option {
+	return func(s *DNSServer) error {
+		wire, err := dns.EncodeName(strings.Trim(zone, "."))
+		if err != nil {
+			return fmt.Errorf("invalid forward zone %q: %v", zone, err)
+		}
+		normalized, err := resolverAddr(addr)
+		if err != nil {
+			return err
+		}
+
+		if s.forwardZones == nil {
+			s.forwardZones = make(map[string]Resolver)
+		}
+		s.forwardZones[string(dns.CanonicalName(wire))] = &UDPResolver{Addr: normalized}
+		return nil
+	}
+}
+
+// parseForwardZones parses a "zone=addr,zone=addr" list into options
+func parseForwardZones(spec string) ([]Option, error) {
+	var opts []Option
+	for _, entry := range strings.Split(spec, ",") {
+		zone, addr, ok := strings.Cut(entry, "=")
+		if !ok || zone == "" || addr == "" {
+			return nil, fmt.Errorf("forward zone %q is not zone=addr", entry)
+		}
+		opts = append(opts, WithForwardZone(zone, addr))
+	}
+	return opts, nil
+}
+
+// forwardZoneResolver returns the resolver of the longest forward zone
+// a wire-format name is at or below
+func (s *DNSServer) forwardZoneResolver(name []byte) (Resolver, bool) {
+	if len(s.forwardZones) == 0 {
+		return nil, false
+	}
+
+	name = dns.CanonicalName(name)
+	for offset := 0; offset < len(name); offset += int(name[offset]) + 1 {
+		if resolver, ok := s.forwardZones[string(name[offset:])]; ok {
+			return resolver, true
+		}
+		if name[offset] == 0 {
+			break
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestForwardZoneRouting(t *testing.T) {
+	public, publicQueries := answeringUpstream(t)
+	internal, internalQueries := answeringUpstream(t)
+	server := newTestServer(t, WithResolver(public), WithForwardZone("corp.internal.", internal))
+
+	tests := []struct {
+		name     string
+		internal bool
+	}{
+		{"host.corp.internal", true},
+		{"CORP.Internal", true},
+		{"a.b.corp.internal", true},
+		{"corp.internal.example.com", false},
+		{"notcorp.internal", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		exchange(t, server, newQuery(t, tt.name, dns.TypeA))
+		want, other := publicQueries, internalQueries
+		if tt.internal {
+			want, other = internalQueries, publicQueries
+		}
+		select {
+		case <-want:
+		case <-other:
+			t.Errorf("%s went to the wrong resolver", tt.name)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s reached no resolver", tt.name)
+		}
+	}
+}
+
+func TestForwardZonesGetTCPPool(t *testing.T) {
+	answer := func(ip string) func(*dns.DNSMessage) *dns.DNSMessage {
+		return func(query *dns.DNSMessage) *dns.DNSMessage {
+			return reply(query, aRecord(t, query.Questions[0].Name(), ip, 300))
+		}
+	}
+	public, publicConns := tcpUpstream(t, "127.0.0.1:0", answer("192.0.2.1"))
+	internal, internalConns := tcpUpstream(t, "127.0.0.1:0", answer("10.0.0.1"))
+
+	// The pool option comes before the zone it must still apply to
+	server := newTestServer(t, WithTCPPool(2, time.Minute), WithResolver(public), WithForwardZone("corp.internal", internal))
+	server.UpstreamProtocol = UpstreamTCP
+
+	for range 2 {
+		for name, want := range map[string]string{"host.corp.internal": "10.0.0.1", "example.com": "192.0.2.1"} {
+			if ips := answerIPs(exchange(t, server, newQuery(t, name, dns.TypeA))); len(ips) != 1 || ips[0] != want {
+				t.Errorf("%s: answers = %v, want [%s]", name, ips, want)
+			}
+		}
+	}
+	if p, i := publicConns.Load(), internalConns.Load(); p != 1 || i != 1 {
+		t.Errorf("upstreams accepted %d and %d connections, want one each, reused", p, i)
+	}
+
+	if _, err := NewDNSServer("127.0.0.1:0", WithTCPPool(0, 0)); err == nil {
+		t.Error("NewDNSServer accepted an empty TCP pool")
+	}
+}
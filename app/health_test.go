@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestHealthCheckIsAnsweredLocally(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = failingResolver
+
+	response := exchange(t, server, newQuery(t, defaultHealthCheckName, dns.TypeTXT))
+	if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+		t.Fatalf("RCODE = %d, want NOERROR", rcode)
+	}
+	if len(response.Answers) != 1 {
+		t.Fatalf("got %d answers, want 1", len(response.Answers))
+	}
+	answer := response.Answers[0]
+	if answer.Type != dns.TypeTXT || string(answer.RData) != "\x02ok" || answer.TTL != 0 {
+		t.Errorf("answer = type %d TTL %d %q, want TXT TTL 0 \"ok\"", answer.Type, answer.TTL, answer.RData)
+	}
+}
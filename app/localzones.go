@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// localZoneTTL is the TTL of the local zones' records, and the SOA
+// MINIMUM that negative answers from them are cached for
+const localZoneTTL = 10800
+
+// localZoneNames are answered locally rather than forwarded: localhost
+// (RFC 6761) and the RFC 6303 reverse zones for private, loopback,
+// link-local and documentation addresses, which upstreams have no
+// business seeing queries for
+var localZoneNames = []string{
+	"localhost",
+	"127.in-addr.arpa",
+	"0.in-addr.arpa",
+	"10.in-addr.arpa",
+	"16.172.in-addr.arpa", "17.172.in-addr.arpa", "18.172.in-addr.arpa", "19.172.in-addr.arpa",
+	"20.172.in-addr.arpa", "21.172.in-addr.arpa", "22.172.in-addr.arpa", "23.172.in-addr.arpa",
+	"24.172.in-addr.arpa", "25.172.in-addr.arpa", "26.172.in-addr.arpa", "27.172.in-addr.arpa",
+	"28.172.in-addr.arpa", "29.172.in-addr.arpa", "30.172.in-addr.arpa", "31.172.in-addr.arpa",
+	"168.192.in-addr.arpa",
+	"254.169.in-addr.arpa",
+	"2.0.192.in-addr.arpa",
+	"100.51.198.in-addr.arpa",
+	"113.0.203.in-addr.arpa",
+	"255.255.255.255.in-addr.arpa",
+	strings.Repeat("0.", 32) + "ip6.arpa",        // ::
+	"1." + strings.Repeat("0.", 31) + "ip6.arpa", // ::1
+	"d.f.ip6.arpa",
+	"8.e.f.ip6.arpa", "9.e.f.ip6.arpa", "a.e.f.ip6.arpa", "b.e.f.ip6.arpa",
+	"8.b.d.0.1.0.0.2.ip6.arpa",
+}
+
+// localZones holds the records of every local zone: an SOA and NS at
+// each apex, loopback addresses for localhost (and any name below it)
+// and PTRs from the loopback addresses back to localhost
+var localZones = newLocalZones()
+
+// newLocalZones builds the local zones' records
+func newLocalZones() *dns.Zone {
+	zone := dns.NewZone()
+	zone.DefaultTTL = localZoneTTL
+
+	for _, name := range localZoneNames {
+		zone.AddRaw(name, dns.TypeSOA, localSOA(name))
+		zone.AddNS(name, name)
+	}
+
+	for _, name := range []string{"localhost", "*.localhost"} {
+		zone.AddA(name, net.IPv4(127, 0, 0, 1))
+		zone.AddAAAA(name, net.IPv6loopback)
+	}
+
+	localhost, _ := dns.EncodeName("localhost")
+	zone.AddRaw("1.0.0.127.in-addr.arpa", dns.TypePTR, localhost)
+	zone.AddRaw("1."+strings.Repeat("0.", 31)+"ip6.arpa", dns.TypePTR, localhost)
+
+	return zone
+}
+
+// localSOA returns the SOA RDATA RFC 6303 section 3 gives empty zones:
+// the apex as MNAME, nobody.invalid as RNAME, and fixed timers
+func localSOA(apex string) []byte {
+	mname, _ := dns.EncodeName(apex)
+	rname, _ := dns.EncodeName("nobody.invalid")
+
+	rdata := append(mname, rname...)
+	for _, v := range []uint32{1, 3600, 1200, 604800, localZoneTTL} {
+		rdata = binary.BigEndian.AppendUint32(rdata, v)
+	}
+	return rdata
+}
+
+// localZoneResponse answers a query for a name in the local zones, or
+// returns nil for any other. A loaded zone that covers the name takes
+// precedence.
+func (s *DNSServer) localZoneResponse(request *dns.DNSMessage) *dns.DNSMessage {
+	q := request.Questions[0]
+	if q.QClass != dns.ClassIN {
+		return nil
+	}
+	if _, ok := localZones.SOA(q.QName, q.QClass); !ok {
+		return nil
+	}
+	if s.zone != nil {
+		if _, ok := s.zone.SOA(q.QName, q.QClass); ok {
+			return nil
+		}
+	}
+
+	response := request.BuildResponse(localZones, nil)
+	return &response
+}
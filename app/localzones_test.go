@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestLocalZonesAreAnsweredLocally(t *testing.T) {
+	var forwarded int
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		forwarded++
+		return reply(query), nil
+	})
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		rcode  uint16
+		answer string // IP for A, target for PTR
+	}{
+		{"localhost", dns.TypeA, dns.RCodeNoError, "127.0.0.1"},
+		{"app.localhost", dns.TypeA, dns.RCodeNoError, "127.0.0.1"},
+		{"10.in-addr.arpa", dns.TypePTR, dns.RCodeNoError, ""},
+		{"4.3.2.10.in-addr.arpa", dns.TypePTR, dns.RCodeNXDomain, ""},
+		{"1.0.0.127.in-addr.arpa", dns.TypePTR, dns.RCodeNoError, "localhost"},
+	}
+	for _, tt := range tests {
+		response := exchange(t, server, newQuery(t, tt.name, tt.qtype))
+		if rcode := response.Header.RCode(); rcode != tt.rcode {
+			t.Errorf("%s: RCODE = %d, want %d", tt.name, rcode, tt.rcode)
+		}
+		var got string
+		for _, a := range response.Answers {
+			switch a.Type {
+			case dns.TypeA:
+				got = answerIPs(response)[0]
+			case dns.TypePTR:
+				got = dns.NameString(a.RData)
+			}
+		}
+		if got != tt.answer {
+			t.Errorf("%s: answer %q, want %q", tt.name, got, tt.answer)
+		}
+		if tt.answer == "" && (len(response.Authorities) != 1 || response.Authorities[0].Type != dns.TypeSOA) {
+			t.Errorf("%s: negative answer without the zone's SOA: %+v", tt.name, response.Authorities)
+		}
+	}
+	if forwarded != 0 {
+		t.Errorf("%d local-zone queries were forwarded", forwarded)
+	}
+
+	server.LocalZones = false
+	exchange(t, server, newQuery(t, "10.in-addr.arpa", dns.TypePTR))
+	if forwarded != 1 {
+		t.Error("with LocalZones off, the query wasn't forwarded")
+	}
+}
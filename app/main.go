@@ -1,29 +1,220 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
 )
 
-func main() {
-	fmt.Println("Logs from your program will appear here!")
+// version identifies the build; set it with
+// go build -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// printVersion writes the version line shown by -version
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "dns-server %s\n", version)
+}
+
+// runSelfTest resolves name through the server's full query path to
+// catch misconfiguration before serving, writing the outcome to w
+func runSelfTest(w io.Writer, server *DNSServer, name string) {
+	if name == "" {
+		fmt.Fprintln(w, "Self-test skipped: no name to query")
+		return
+	}
 
+	answers, err := selfTest(server, name)
+	if err != nil {
+		fmt.Fprintf(w, "Self-test FAILED for %s: %v\n", name, err)
+		return
+	}
+	fmt.Fprintf(w, "Self-test passed: %s resolved with %d answer(s)\n", name, answers)
+}
+
+// selfTest looks name up and returns the number of answers. An empty
+// answer is a failure, since a NOERROR with nothing in it says little
+// about the path; the health-check name is asked for its TXT "ok".
+func selfTest(server *DNSServer, name string) (int, error) {
+	health := server.HealthCheckName != "" &&
+		strings.EqualFold(strings.TrimSuffix(name, "."), strings.TrimSuffix(server.HealthCheckName, "."))
+	qtype := dns.TypeA
+	if health {
+		qtype = dns.TypeTXT
+	}
+
+	answers, err := server.Resolve(context.Background(), name, qtype)
+	if err != nil {
+		return 0, err
+	}
+	if len(answers) == 0 {
+		return 0, fmt.Errorf("no answers")
+	}
+	if health && string(answers[0].RData) != "\x02ok" {
+		return 0, fmt.Errorf("health check answered %q, want \"ok\"", answers[0].RData)
+	}
+	return len(answers), nil
+}
+
+func main() {
 	// Parse command line arguments
+	listenAddr := flag.String("listen", "127.0.0.1:2053", "Address to listen on (ip:port)")
 	resolverAddr := flag.String("resolver", "", "DNS resolver address (ip:port)")
+	forwardZones := flag.String("forward-zones", "", "Comma-separated zone=ip:port pairs forwarding names in a zone to their own resolver")
+	zoneFile := flag.String("zone", "", "Zone file to answer authoritatively from")
+	systemFallback := flag.Bool("system-fallback", false, "Look up A and AAAA queries for names missing from the zone through the system resolver")
+	rotate := flag.Bool("rotate", false, "Rotate the order of multi-record answers")
+	shuffle := flag.Bool("shuffle", false, "Randomize the order of records in each answer RRset")
+	debug := flag.Bool("debug", false, "Validate responses before sending them")
+	timeout := flag.Duration("timeout", defaultTimeout, "Maximum time to handle a single query")
+	writeTimeout := flag.Duration("write-timeout", defaultWriteTimeout, "Maximum time sending a response may block before it is dropped")
+	maxPacket := flag.Int("max-packet", defaultPacketSize, "Largest inbound UDP query in bytes (512-65535)")
+	maxUDPResponse := flag.Int("max-udp-response", defaultMaxUDPResponseSize, "Largest UDP response in bytes, whatever the client advertises (512-65535)")
+	metricsAddr := flag.String("metrics", "", "Address to serve Prometheus metrics on (e.g. :9153)")
+	randomizeCase := flag.Bool("0x20", false, "Randomize the case of forwarded query names (0x20 encoding)")
+	healthName := flag.String("health-name", defaultHealthCheckName, "Name answered locally with TXT \"ok\" for health checks (empty disables)")
+	nsid := flag.String("nsid", "", "Server identifier returned to queries carrying the EDNS NSID option")
+	minTTL := flag.Duration("min-ttl", 0, "Lower bound for forwarded record TTLs (e.g. 30s)")
+	maxTTL := flag.Duration("max-ttl", 0, "Upper bound for forwarded record TTLs (e.g. 1h)")
+	recordFile := flag.String("record", "", "Append every query and response to this capture file")
+	replayFile := flag.String("replay", "", "Replay a capture file through the handler, report differences and exit")
+	defaultIP := flag.String("default-ip", "8.8.8.8", "Address returned for every query when no zone is configured")
+	allowUpdates := flag.Bool("allow-updates", false, "Accept DNS UPDATE messages against the zone")
+	allowTransfer := flag.Bool("allow-axfr", false, "Serve zone transfers (AXFR) of the zone over TCP")
+	maxInFlight := flag.Int("max-inflight", defaultMaxInFlight, "Maximum queries handled concurrently; excess queries are refused")
+	upstreamSource := flag.String("upstream-source", "", "Source address for upstream queries (ip:port or ip:first-last)")
+	cacheSize := flag.Int("cache", 0, "Number of forwarded responses to cache (0 disables caching)")
+	serveStale := flag.Duration("serve-stale", 0, "Keep cached answers this long past their TTL to serve when the upstream fails")
+	paddingBlock := flag.Int("padding-block", defaultPaddingBlockSize, "Block size to pad TCP responses to when the query carries EDNS padding")
+	responseDelay := flag.Duration("delay", 0, "Artificial delay before responding, for chaos testing")
+	delayProbability := flag.Float64("delay-probability", 0, "Fraction of queries to delay (0 delays all)")
+	cookieRotation := flag.Duration("cookie-rotation", 0, "How often to rotate the DNS cookie secret (0 never rotates)")
+	requireCookies := flag.Bool("require-cookies", false, "Answer queries carrying a stale or forged server cookie with BADCOOKIE")
+	forwardECS := flag.Bool("forward-ecs", false, "Forward the EDNS Client Subnet option of queries to upstream resolvers")
+	minimalResponses := flag.Bool("minimal-responses", false, "Strip authority and additional records from forwarded responses")
+	chaseCNAME := flag.Bool("chase-cname", false, "Follow CNAMEs that end forwarded answers with further upstream queries")
+	stripEDNS := flag.Bool("strip-edns", false, "Forward queries without EDNS, for legacy upstreams")
+	dropUnknownOptions := flag.Bool("drop-unknown-edns", false, "Remove EDNS options the server doesn't support from forwarded queries instead of passing them on")
+	apiAddr := flag.String("api", "", "Address to serve the JSON query API on (e.g. :8053)")
+	localZones := flag.Bool("local-zones", true, "Answer localhost and the RFC 6303 private reverse zones locally")
+	authoritativeOnly := flag.Bool("authoritative-only", false, "Only answer names under -owned-zones, refusing everything else")
+	ownedZones := flag.String("owned-zones", "", "Comma-separated zones answered in authoritative-only mode")
+	upstreamProtocol := flag.String("upstream-protocol", UpstreamAuto, "How to reach upstream resolvers: udp, tcp or auto (UDP, retrying truncated responses over TCP)")
+	upstreamUDPSize := flag.Int("edns-size", defaultUpstreamUDPSize, "EDNS UDP payload size advertised to upstream resolvers (512-65535)")
+	listenTCP := flag.Bool("tcp", false, "Also serve DNS over TCP on the listen address")
+	tcpPool := flag.Int("tcp-pool", 0, "Idle upstream TCP connections to keep for reuse (0 disables pooling)")
+	tcpIdleTimeout := flag.Duration("tcp-idle-timeout", defaultPoolIdleTimeout, "How long a pooled upstream TCP connection may stay idle")
+	maxQuestions := flag.Int("max-questions", defaultMaxQuestions, "Refuse queries with more questions than this: multi-question queries are REFUSED unless set to 0, which answers them question by question")
+	failNames := flag.String("fail-names", "", "Comma-separated names always answered with SERVFAIL, for failure injection")
+	queryLogFormat := flag.String("query-log-format", QueryLogDefault, "Query log format: default or bind")
+	selfTest := flag.Bool("selftest", false, "Resolve -selftest-name through the server on startup and log the result")
+	selfTestName := flag.String("selftest-name", "", "Name queried by -selftest (defaults to the health-check name)")
+	showVersion := flag.Bool("version", false, "Print the version and exit")
 	flag.Parse()
 
+	if *showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
+	fmt.Println("Logs from your program will appear here!")
+
 	// Create and start DNS server
-	server, err := NewDNSServer("127.0.0.1:2053", *resolverAddr)
+	answerIP := net.ParseIP(*defaultIP)
+	if answerIP == nil {
+		fmt.Printf("Invalid default answer IP: %s\n", *defaultIP)
+		return
+	}
+	opts := []Option{
+		WithTimeout(*timeout), WithWriteTimeout(*writeTimeout), WithNSID(*nsid),
+		WithDefaultAnswerIP(answerIP), WithTTLBounds(*minTTL, *maxTTL), WithMaxInFlight(*maxInFlight),
+		WithDebug(*debug), WithQueryLogFormat(*queryLogFormat), WithShuffleAnswers(*shuffle),
+		WithMaxPacketSize(*maxPacket), WithMaxUDPResponseSize(*maxUDPResponse),
+		WithMetricsAddr(*metricsAddr), WithAPIAddr(*apiAddr), WithListenTCP(*listenTCP),
+		WithRandomizeCase(*randomizeCase), WithHealthCheckName(*healthName), WithRecordFile(*recordFile),
+		WithAllowUpdates(*allowUpdates), WithAllowTransfer(*allowTransfer),
+		WithCacheSize(*cacheSize), WithServeStale(*serveStale), WithPaddingBlockSize(*paddingBlock),
+		WithResponseDelay(*responseDelay, *delayProbability), WithCookies(*cookieRotation, *requireCookies),
+		WithStripEDNS(*stripEDNS), WithChaseCNAME(*chaseCNAME), WithDropUnknownOptions(*dropUnknownOptions),
+		WithMinimalResponses(*minimalResponses), WithForwardClientSubnet(*forwardECS),
+		WithUpstreamUDPSize(*upstreamUDPSize), WithUpstreamProtocol(*upstreamProtocol),
+		WithLocalZones(*localZones), WithMaxQuestions(*maxQuestions),
+	}
+	if *resolverAddr != "" {
+		opts = append(opts, WithResolver(*resolverAddr))
+	}
+	if *upstreamSource != "" {
+		opts = append(opts, WithUpstreamSource(*upstreamSource))
+	}
+	if *tcpPool > 0 {
+		opts = append(opts, WithTCPPool(*tcpPool, *tcpIdleTimeout))
+	}
+	if *forwardZones != "" {
+		zoneOpts, err := parseForwardZones(*forwardZones)
+		if err != nil {
+			fmt.Printf("Invalid forward zones: %v\n", err)
+			return
+		}
+		opts = append(opts, zoneOpts...)
+	}
+	if *authoritativeOnly {
+		var zones []string
+		if *ownedZones != "" {
+			zones = strings.Split(*ownedZones, ",")
+		}
+		opts = append(opts, WithAuthoritativeOnly(zones...))
+	}
+	if *failNames != "" {
+		opts = append(opts, WithFailNames(strings.Split(*failNames, ",")...))
+	}
+	if *zoneFile != "" {
+		zone, err := dns.LoadZoneFile(*zoneFile)
+		if err != nil {
+			fmt.Printf("Failed to load zone file: %v\n", err)
+			return
+		}
+		zone.RotateAnswers = *rotate
+		opts = append(opts, WithZone(zone), WithSystemFallback(*systemFallback))
+	}
+
+	server, err := NewDNSServer(*listenAddr, opts...)
 	if err != nil {
 		fmt.Printf("Failed to start server: %v\n", err)
 		return
 	}
 
+	if *zoneFile != "" {
+		server.reloadOnHangup(*zoneFile)
+		fmt.Printf("Answering from zone file: %s (SIGHUP reloads it)\n", *zoneFile)
+	}
+
 	if *resolverAddr != "" {
 		fmt.Printf("Forwarding queries to resolver: %s\n", *resolverAddr)
 	}
 
-	fmt.Printf("DNS server listening on %s\n", server.conn.LocalAddr().String())
+	if *replayFile != "" {
+		total, mismatches, err := server.Replay(*replayFile)
+		if err != nil {
+			fmt.Printf("Replay failed: %v\n", err)
+		}
+		fmt.Printf("Replayed %d exchanges, %d differed\n", total, mismatches)
+		return
+	}
+
+	if *selfTest {
+		name := *selfTestName
+		if name == "" {
+			name = server.HealthCheckName
+		}
+		runSelfTest(os.Stdout, server, name)
+	}
+
+	fmt.Printf("DNS server %s listening on %s\n", version, server.LocalAddr())
 	if err := server.Run(); err != nil {
 		fmt.Printf("Server error: %v\n", err)
 	}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestPrintVersion(t *testing.T) {
+	defer func(old string) { version = old }(version)
+	version = "v1.2.3"
+
+	var out bytes.Buffer
+	printVersion(&out)
+	if got := out.String(); got != "dns-server v1.2.3\n" {
+		t.Errorf("printVersion wrote %q, want %q", got, "dns-server v1.2.3\n")
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = failingResolver
+	server.HealthCheckName = defaultHealthCheckName
+	server.zone = testZone(t, "www.example.com A 192.0.2.1\nnodata.example.com AAAA 2001:db8::1\n")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www.example.com", "Self-test passed: www.example.com resolved with 1 answer(s)\n"},
+		{defaultHealthCheckName, "Self-test passed: " + defaultHealthCheckName + " resolved with 1 answer(s)\n"},
+		{"nodata.example.com", "Self-test FAILED for nodata.example.com: no answers\n"},
+		{"missing.example.com", "Self-test FAILED for missing.example.com"},
+		{"", "Self-test skipped: no name to query\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		runSelfTest(&out, server, tt.name)
+		if got := out.String(); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("self-test of %q wrote %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSelfTestUsesTheCache(t *testing.T) {
+	var queries int
+	server := newTestServer(t, WithCacheSize(16))
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		queries++
+		return reply(query, aRecord(t, "example.com", "192.0.2.1", 300)), nil
+	})
+
+	runSelfTest(io.Discard, server, "example.com")
+	if response := exchange(t, server, newQuery(t, "example.com", dns.TypeA)); len(response.Answers) != 1 {
+		t.Fatalf("answers = %+v, want one", response.Answers)
+	}
+	if queries != 1 {
+		t.Errorf("upstream saw %d queries, want 1 with the self-test's answer cached", queries)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the query latency histogram
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Metrics collects server counters and serves them in the Prometheus
+// text exposition format. A nil *Metrics discards all observations.
+type Metrics struct {
+	queries        atomic.Uint64
+	upstreamErrors atomic.Uint64
+
+	mu           sync.Mutex
+	bucketCounts []uint64 // per latency bucket, plus a final +Inf bucket
+	latencySum   float64
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{bucketCounts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+// ObserveQuery records a handled query and how long it took
+func (m *Metrics) ObserveQuery(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.queries.Add(1)
+
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencySum += seconds
+	i := 0
+	for i < len(latencyBuckets) && seconds > latencyBuckets[i] {
+		i++
+	}
+	m.bucketCounts[i]++
+}
+
+// UpstreamError records a failed query to the upstream resolver
+func (m *Metrics) UpstreamError() {
+	if m == nil {
+		return
+	}
+	m.upstreamErrors.Add(1)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP dns_queries_total Total number of DNS queries handled.")
+	fmt.Fprintln(w, "# TYPE dns_queries_total counter")
+	fmt.Fprintf(w, "dns_queries_total %d\n", m.queries.Load())
+
+	fmt.Fprintln(w, "# HELP dns_upstream_errors_total Total number of failed upstream queries.")
+	fmt.Fprintln(w, "# TYPE dns_upstream_errors_total counter")
+	fmt.Fprintf(w, "dns_upstream_errors_total %d\n", m.upstreamErrors.Load())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP dns_query_duration_seconds Time taken to handle a DNS query.")
+	fmt.Fprintln(w, "# TYPE dns_query_duration_seconds histogram")
+	var cumulative uint64
+	for i, count := range m.bucketCounts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = fmt.Sprintf("%g", latencyBuckets[i])
+		}
+		fmt.Fprintf(w, "dns_query_duration_seconds_bucket{le=\"%s\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(w, "dns_query_duration_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(w, "dns_query_duration_seconds_count %d\n", cumulative)
+}
+
+// serveMetrics starts an HTTP server exposing /metrics on addr
+func (s *DNSServer) serveMetrics(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logf("Metrics server error: %v\n", err)
+		}
+	}()
+
+	return server, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestMetricsAfterQueries(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(context.Context, *dns.DNSMessage) (*dns.DNSMessage, error) {
+		return nil, errors.New("upstream down")
+	})
+	exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+
+	recorder := httptest.NewRecorder()
+	server.metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	for _, want := range []string{
+		"dns_queries_total 1\n",
+		"dns_upstream_errors_total 1\n",
+		`dns_query_duration_seconds_bucket{le="+Inf"} 1`,
+		"dns_query_duration_seconds_count 1\n",
+		"# TYPE dns_query_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
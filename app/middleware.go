@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Handler produces the response to a parsed request
+type Handler func(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage
+
+// Middleware wraps a Handler with logic that runs before and after it,
+// e.g. to authorize, rewrite or log queries
+type Middleware func(next Handler) Handler
+
+// Use appends middleware to the chain run around every query. The first
+// registered is the outermost, so it sees the request first and the
+// response last.
+func (s *DNSServer) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// handler returns the server's respond wrapped in its middleware
+func (s *DNSServer) handler() Handler {
+	h := Handler(s.respond)
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h
+}
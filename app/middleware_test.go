@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestMiddlewareRewritesTTL(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+				order = append(order, name+" before")
+				response := next(ctx, request)
+				order = append(order, name+" after")
+				return response
+			}
+		}
+	}
+	capTTL := func(next Handler) Handler {
+		return func(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+			response := next(ctx, request)
+			for i := range response.Answers {
+				response.Answers[i].TTL = min(response.Answers[i].TTL, 5)
+			}
+			return response
+		}
+	}
+
+	server := newTestServer(t, WithMiddleware(trace("outer"), capTTL))
+	server.Use(trace("inner"))
+	server.resolver = answerA(t, "192.0.2.1")
+
+	response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+	if len(response.Answers) != 1 || response.Answers[0].TTL != 5 {
+		t.Errorf("answers = %+v, want one with the TTL rewritten to 5", response.Answers)
+	}
+	if got, want := strings.Join(order, ", "), "outer before, inner before, inner after, outer after"; got != want {
+		t.Errorf("middleware ran as %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// HandlerFunc answers a DNS query. Returning nil falls through to the
+// server's default handling (zone, forwarding or dummy answers).
+type HandlerFunc func(request *dns.DNSMessage) *dns.DNSMessage
+
+// Mux routes queries to handlers by domain suffix, similar to
+// net/http's ServeMux. The longest matching suffix wins.
+type Mux struct {
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc // keyed by wire-format suffix
+}
+
+// NewMux creates an empty Mux
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[string]HandlerFunc)}
+}
+
+// Handle registers handler for suffix and every name below it, so
+// "local" (or ".local") matches both "local" and "printer.local"
+func (m *Mux) Handle(suffix string, handler HandlerFunc) error {
+	wire, err := dns.EncodeName(strings.TrimPrefix(suffix, "."))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[string(dns.CanonicalName(wire))] = handler
+	return nil
+}
+
+// Match returns the handler registered for the longest suffix of a
+// wire-format name
+func (m *Mux) Match(name []byte) (HandlerFunc, bool) {
+	name = dns.CanonicalName(name)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Try each label boundary from the full name down to the root
+	for offset := 0; offset < len(name); offset += int(name[offset]) + 1 {
+		if handler, ok := m.handlers[string(name[offset:])]; ok {
+			return handler, true
+		}
+		if name[offset] == 0 {
+			break
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestHandledSuffixIsAnsweredLocally(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = answerA(t, "192.0.2.1")
+	err := server.Handle(".local", func(request *dns.DNSMessage) *dns.DNSMessage {
+		return reply(request, aRecord(t, request.Questions[0].Name(), "10.0.0.1", 60))
+	})
+	if err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"printer.local", "10.0.0.1"},
+		{"LOCAL", "10.0.0.1"},
+		{"example.com", "192.0.2.1"},
+		{"local.example.com", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		response := exchange(t, server, newQuery(t, tt.name, dns.TypeA))
+		if ips := answerIPs(response); !slices.Equal(ips, []string{tt.want}) {
+			t.Errorf("%s answered with %v, want [%s]", tt.name, ips, tt.want)
+		}
+	}
+}
+
+func TestMuxLongestSuffixWins(t *testing.T) {
+	mux := NewMux()
+	var matched string
+	for _, suffix := range []string{"example.com", "internal.example.com"} {
+		mux.Handle(suffix, func(*dns.DNSMessage) *dns.DNSMessage {
+			matched = suffix
+			return nil
+		})
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www.example.com", "example.com"},
+		{"db.internal.example.com", "internal.example.com"},
+		{"internal.example.com", "internal.example.com"},
+	}
+	for _, tt := range tests {
+		wire, _ := dns.EncodeName(tt.name)
+		handler, ok := mux.Match(wire)
+		if !ok {
+			t.Errorf("%s matched no handler", tt.name)
+			continue
+		}
+		handler(nil)
+		if matched != tt.want {
+			t.Errorf("%s matched %s, want %s", tt.name, matched, tt.want)
+		}
+	}
+
+	wire, _ := dns.EncodeName("example.org")
+	if _, ok := mux.Match(wire); ok {
+		t.Error("example.org matched a handler")
+	}
+}
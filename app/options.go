@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Option configures a DNSServer in NewDNSServer. Every setting has an
+// option, which validates it; the exported fields they set are read by
+// Run and may still be adjusted before it, as tests do.
+type Option func(*DNSServer) error
+
+// WithResolver forwards queries the server can't answer itself to the
+// upstream resolver at addr (host:port, or a bare IP for port 53)
+func WithResolver(addr string) Option {
+	return func(s *DNSServer) error {
+		normalized, err := resolverAddr(addr)
+		if err != nil {
+			return err
+		}
+		s.resolver = &UDPResolver{Addr: normalized}
+		return nil
+	}
+}
+
+// WithUpstreamSource sends UDP upstream queries from spec, either
+// "ip:port" or "ip:first-last" for a random port from a range
+func WithUpstreamSource(spec string) Option {
+	return func(s *DNSServer) error {
+		if _, _, _, err := parseSourceRange(spec); err != nil {
+			return err
+		}
+		s.upstreamSource = spec
+		return nil
+	}
+}
+
+// WithTCPPool keeps up to maxIdle idle TCP connections to each upstream
+// for reuse, closing those idle for longer than idleTimeout (zero uses
+// the pool default)
+func WithTCPPool(maxIdle int, idleTimeout time.Duration) Option {
+	return func(s *DNSServer) error {
+		if maxIdle <= 0 || idleTimeout < 0 {
+			return fmt.Errorf("invalid TCP pool of %d connections idle for %v", maxIdle, idleTimeout)
+		}
+		s.tcpPoolSize, s.tcpPoolIdleTimeout = maxIdle, idleTimeout
+		return nil
+	}
+}
+
+// WithTimeout bounds the handling of each query; zero keeps the default
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *DNSServer) error {
+		s.Timeout = timeout
+		return nil
+	}
+}
+
+// WithTTLBounds clamps the TTLs of forwarded records to [min, max]; a
+// zero bound leaves that side unclamped
+func WithTTLBounds(min, max time.Duration) Option {
+	return func(s *DNSServer) error {
+		if min < 0 || max < 0 || (max > 0 && min > max) {
+			return fmt.Errorf("invalid TTL bounds [%v, %v]", min, max)
+		}
+		s.MinTTL, s.MaxTTL = min, max
+		return nil
+	}
+}
+
+// WithMaxInFlight caps the queries handled concurrently at n; queries
+// beyond it are refused
+func WithMaxInFlight(n int) Option {
+	return func(s *DNSServer) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid in-flight limit %d", n)
+		}
+		s.MaxInFlight = n
+		return nil
+	}
+}
+
+// WithDefaultAnswerIP answers every question with ip when no zone is
+// configured, instead of 8.8.8.8
+func WithDefaultAnswerIP(ip net.IP) Option {
+	return func(s *DNSServer) error {
+		if ip.To16() == nil {
+			return fmt.Errorf("invalid default answer IP %v", ip)
+		}
+		s.DefaultAnswerIP = ip
+		return nil
+	}
+}
+
+// WithLogger sends the server's log output to w instead of stdout
+func WithLogger(w io.Writer) Option {
+	return func(s *DNSServer) error {
+		s.logger = w
+		return nil
+	}
+}
+
+// WithCache caches forwarded responses in cache
+func WithCache(cache Cache) Option {
+	return func(s *DNSServer) error {
+		s.Cache = cache
+		return nil
+	}
+}
+
+// WithMiddleware wraps the handling of every query in middleware
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(s *DNSServer) error {
+		s.Use(middleware...)
+		return nil
+	}
+}
+
+// WithNSID identifies the server as id to queries asking for its NSID
+func WithNSID(id string) Option {
+	return func(s *DNSServer) error {
+		s.NSID = id
+		return nil
+	}
+}
+
+// WithZone answers authoritatively from zone
+func WithZone(zone *dns.Zone) Option {
+	return func(s *DNSServer) error {
+		s.zone = zone
+		return nil
+	}
+}
+
+// WithSystemFallback looks up A and AAAA queries for names missing from
+// the zone through the system resolver
+func WithSystemFallback(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.SystemFallback = enabled
+		return nil
+	}
+}
+
+// WithDebug validates every locally built response before encoding it
+func WithDebug(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.Debug = enabled
+		return nil
+	}
+}
+
+// WithQueryLogFormat logs incoming queries as QueryLogDefault or
+// QueryLogBIND
+func WithQueryLogFormat(format string) Option {
+	return func(s *DNSServer) error {
+		if !validQueryLogFormat(format) {
+			return fmt.Errorf("invalid query log format %q", format)
+		}
+		s.QueryLogFormat = format
+		return nil
+	}
+}
+
+// WithWriteTimeout bounds how long sending a response may block; zero
+// keeps the default
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *DNSServer) error {
+		s.WriteTimeout = timeout
+		return nil
+	}
+}
+
+// WithMaxPacketSize reads inbound UDP queries of up to n bytes
+func WithMaxPacketSize(n int) Option {
+	return func(s *DNSServer) error {
+		s.MaxPacketSize = n
+		return nil
+	}
+}
+
+// WithMaxUDPResponseSize caps UDP responses at n bytes, whatever the
+// client advertises
+func WithMaxUDPResponseSize(n int) Option {
+	return func(s *DNSServer) error {
+		s.MaxUDPResponseSize = n
+		return nil
+	}
+}
+
+// WithUpstreamUDPSize advertises an EDNS UDP payload size of n bytes in
+// forwarded queries
+func WithUpstreamUDPSize(n int) Option {
+	return func(s *DNSServer) error {
+		s.UpstreamUDPSize = n
+		return nil
+	}
+}
+
+// WithUpstreamProtocol reaches resolvers over UpstreamUDP, UpstreamTCP
+// or UpstreamAuto
+func WithUpstreamProtocol(protocol string) Option {
+	return func(s *DNSServer) error {
+		if !validUpstreamProtocol(protocol) {
+			return fmt.Errorf("invalid upstream protocol %q", protocol)
+		}
+		s.UpstreamProtocol = protocol
+		return nil
+	}
+}
+
+// WithMetricsAddr serves Prometheus metrics on addr while the server runs
+func WithMetricsAddr(addr string) Option {
+	return func(s *DNSServer) error {
+		s.MetricsAddr = addr
+		return nil
+	}
+}
+
+// WithAPIAddr serves the JSON query API on addr while the server runs
+func WithAPIAddr(addr string) Option {
+	return func(s *DNSServer) error {
+		s.APIAddr = addr
+		return nil
+	}
+}
+
+// WithListenTCP also serves DNS over TCP on the listen address
+func WithListenTCP(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.ListenTCP = enabled
+		return nil
+	}
+}
+
+// WithRecordFile captures every exchange to path for later Replay
+func WithRecordFile(path string) Option {
+	return func(s *DNSServer) error {
+		s.RecordFile = path
+		return nil
+	}
+}
+
+// WithHealthCheckName answers name locally with TXT "ok"; empty
+// disables the health check
+func WithHealthCheckName(name string) Option {
+	return func(s *DNSServer) error {
+		s.HealthCheckName = name
+		return nil
+	}
+}
+
+// WithLocalZones answers localhost and the RFC 6303 reverse zones
+// locally
+func WithLocalZones(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.LocalZones = enabled
+		return nil
+	}
+}
+
+// WithAuthoritativeOnly answers only names under zones, refusing
+// everything else
+func WithAuthoritativeOnly(zones ...string) Option {
+	return func(s *DNSServer) error {
+		s.AuthoritativeOnly, s.OwnedZones = true, zones
+		return nil
+	}
+}
+
+// WithAllowUpdates accepts DNS UPDATE messages against the zone
+func WithAllowUpdates(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.AllowUpdates = enabled
+		return nil
+	}
+}
+
+// WithAllowTransfer serves zone transfers (AXFR) over TCP
+func WithAllowTransfer(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.AllowTransfer = enabled
+		return nil
+	}
+}
+
+// WithMaxQuestions refuses queries with more than n questions; zero
+// allows any number
+func WithMaxQuestions(n int) Option {
+	return func(s *DNSServer) error {
+		if n < 0 {
+			return fmt.Errorf("invalid question limit %d", n)
+		}
+		s.MaxQuestions = n
+		return nil
+	}
+}
+
+// WithFailNames answers names with SERVFAIL, for failure injection
+func WithFailNames(names ...string) Option {
+	return func(s *DNSServer) error {
+		s.FailNames = names
+		return nil
+	}
+}
+
+// WithCacheSize caches up to n forwarded responses in a MemoryCache,
+// unless WithCache supplies a cache
+func WithCacheSize(n int) Option {
+	return func(s *DNSServer) error {
+		if n < 0 {
+			return fmt.Errorf("invalid cache size %d", n)
+		}
+		s.CacheSize = n
+		return nil
+	}
+}
+
+// WithServeStale keeps cached responses this long past their TTL to
+// answer with when the upstream fails
+func WithServeStale(window time.Duration) Option {
+	return func(s *DNSServer) error {
+		if window < 0 {
+			return fmt.Errorf("invalid serve-stale window %v", window)
+		}
+		s.ServeStale = window
+		return nil
+	}
+}
+
+// WithRandomizeCase enables 0x20 encoding of forwarded query names
+func WithRandomizeCase(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.RandomizeCase = enabled
+		return nil
+	}
+}
+
+// WithShuffleAnswers randomizes the order of records in each answer
+// RRset
+func WithShuffleAnswers(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.ShuffleAnswers = enabled
+		return nil
+	}
+}
+
+// WithMinimalResponses strips forwarded responses of the records the
+// client doesn't need
+func WithMinimalResponses(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.MinimalResponses = enabled
+		return nil
+	}
+}
+
+// WithChaseCNAME follows CNAMEs that end forwarded answers upstream
+func WithChaseCNAME(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.ChaseCNAME = enabled
+		return nil
+	}
+}
+
+// WithStripEDNS forwards queries without their OPT record
+func WithStripEDNS(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.StripEDNS = enabled
+		return nil
+	}
+}
+
+// WithDropUnknownOptions removes EDNS options the server has no support
+// for from forwarded queries
+func WithDropUnknownOptions(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.DropUnknownOptions = enabled
+		return nil
+	}
+}
+
+// WithForwardClientSubnet passes the client's EDNS Client Subnet option
+// on to upstreams
+func WithForwardClientSubnet(enabled bool) Option {
+	return func(s *DNSServer) error {
+		s.ForwardClientSubnet = enabled
+		return nil
+	}
+}
+
+// WithCookies rotates the DNS cookie secret every rotation (zero never
+// rotates it) and, when require is set, answers queries with a stale or
+// forged server cookie with BADCOOKIE
+func WithCookies(rotation time.Duration, require bool) Option {
+	return func(s *DNSServer) error {
+		if rotation < 0 {
+			return fmt.Errorf("invalid cookie rotation %v", rotation)
+		}
+		s.CookieRotation, s.RequireCookies = rotation, require
+		return nil
+	}
+}
+
+// WithPaddingBlockSize pads TCP responses to queries asking for EDNS
+// padding to multiples of n bytes
+func WithPaddingBlockSize(n int) Option {
+	return func(s *DNSServer) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid padding block size %d", n)
+		}
+		s.PaddingBlockSize = n
+		return nil
+	}
+}
+
+// WithResponseDelay holds back the given fraction of responses (zero
+// for all) by delay, for chaos testing
+func WithResponseDelay(delay time.Duration, probability float64) Option {
+	return func(s *DNSServer) error {
+		if delay < 0 || probability < 0 || probability > 1 {
+			return fmt.Errorf("invalid response delay %v with probability %v", delay, probability)
+		}
+		s.ResponseDelay, s.DelayProbability = delay, probability
+		return nil
+	}
+}
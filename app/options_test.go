@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOptionsAreApplied(t *testing.T) {
+	var log bytes.Buffer
+	cache := NewMemoryCache(16)
+	server := newTestServer(t,
+		WithResolver("192.0.2.53"),
+		WithTimeout(3*time.Second),
+		WithLogger(&log),
+		WithCache(cache),
+		WithTTLBounds(time.Minute, time.Hour),
+		WithMaxInFlight(7),
+		WithDefaultAnswerIP(net.IPv4(192, 0, 2, 9)),
+		WithNSID("ns1"),
+		WithUpstreamSource("127.0.0.1:0"),
+	)
+
+	resolver, ok := server.resolver.(*UDPResolver)
+	if !ok || resolver.Addr != "192.0.2.53:53" {
+		t.Errorf("resolver = %#v, want UDP to 192.0.2.53:53", server.resolver)
+	} else if resolver.LocalAddr != "127.0.0.1:0" {
+		t.Errorf("resolver source = %q, want 127.0.0.1:0", resolver.LocalAddr)
+	}
+	if server.Timeout != 3*time.Second {
+		t.Errorf("Timeout = %v, want 3s", server.Timeout)
+	}
+	if server.logger != &log {
+		t.Error("the logger wasn't applied over the test default")
+	}
+	if server.Cache != cache {
+		t.Error("the cache wasn't applied")
+	}
+	if server.MinTTL != time.Minute || server.MaxTTL != time.Hour {
+		t.Errorf("TTL bounds = [%v, %v], want [1m, 1h]", server.MinTTL, server.MaxTTL)
+	}
+	if server.MaxInFlight != 7 {
+		t.Errorf("MaxInFlight = %d, want 7", server.MaxInFlight)
+	}
+	if !server.DefaultAnswerIP.Equal(net.IPv4(192, 0, 2, 9)) {
+		t.Errorf("DefaultAnswerIP = %v, want 192.0.2.9", server.DefaultAnswerIP)
+	}
+	if server.NSID != "ns1" {
+		t.Errorf("NSID = %q, want ns1", server.NSID)
+	}
+
+	// Settings no option touches keep their defaults
+	if server.WriteTimeout != defaultWriteTimeout || !server.LocalZones {
+		t.Errorf("defaults changed: WriteTimeout %v, LocalZones %v", server.WriteTimeout, server.LocalZones)
+	}
+}
+
+func TestSettingOptionsAreApplied(t *testing.T) {
+	zone := testZone(t, "www.example.com A 192.0.2.1\n")
+	server := newTestServer(t,
+		WithZone(zone),
+		WithQueryLogFormat(QueryLogBIND),
+		WithUpstreamProtocol(UpstreamTCP),
+		WithMaxQuestions(0),
+		WithCacheSize(32),
+		WithCookies(time.Hour, true),
+		WithResponseDelay(time.Millisecond, 0.5),
+		WithAuthoritativeOnly("example.com"),
+		WithFailNames("broken.test"),
+		WithLocalZones(false),
+		WithChaseCNAME(true),
+	)
+
+	if server.zone != zone {
+		t.Error("the zone wasn't applied")
+	}
+	if server.QueryLogFormat != QueryLogBIND || server.UpstreamProtocol != UpstreamTCP {
+		t.Errorf("formats = %q, %q, want bind, tcp", server.QueryLogFormat, server.UpstreamProtocol)
+	}
+	if server.MaxQuestions != 0 || server.CacheSize != 32 {
+		t.Errorf("MaxQuestions %d, CacheSize %d, want 0, 32", server.MaxQuestions, server.CacheSize)
+	}
+	if server.CookieRotation != time.Hour || !server.RequireCookies {
+		t.Errorf("cookies = %v, %t, want 1h, required", server.CookieRotation, server.RequireCookies)
+	}
+	if server.ResponseDelay != time.Millisecond || server.DelayProbability != 0.5 {
+		t.Errorf("delay = %v at %v, want 1ms at 0.5", server.ResponseDelay, server.DelayProbability)
+	}
+	if !server.AuthoritativeOnly || len(server.OwnedZones) != 1 || len(server.FailNames) != 1 {
+		t.Errorf("authoritative-only %t for %v, fail names %v", server.AuthoritativeOnly, server.OwnedZones, server.FailNames)
+	}
+	if server.LocalZones || !server.ChaseCNAME {
+		t.Errorf("LocalZones %t, ChaseCNAME %t, want false, true", server.LocalZones, server.ChaseCNAME)
+	}
+}
+
+func TestInvalidOptionsAreRejected(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"resolver":       WithResolver("dns.example"),
+		"upstream range": WithUpstreamSource("127.0.0.1:9-1"),
+		"TTL bounds":     WithTTLBounds(time.Hour, time.Minute),
+		"in-flight":      WithMaxInFlight(0),
+		"answer IP":      WithDefaultAnswerIP(nil),
+		"log format":     WithQueryLogFormat("json"),
+		"protocol":       WithUpstreamProtocol("quic"),
+		"questions":      WithMaxQuestions(-1),
+		"cache size":     WithCacheSize(-1),
+		"delay":          WithResponseDelay(time.Second, 1.5),
+		"padding":        WithPaddingBlockSize(0),
+		"packet size":    WithMaxPacketSize(100),
+		"response size":  WithMaxUDPResponseSize(70000),
+		"upstream size":  WithUpstreamUDPSize(511),
+	} {
+		if server, err := NewDNSServer("127.0.0.1:0", opt); err == nil {
+			server.conn.Close()
+			t.Errorf("%s: NewDNSServer accepted an invalid option", name)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultPoolIdleTimeout is how long a pooled connection may sit unused
+// when ConnPool.IdleTimeout is not set
+const defaultPoolIdleTimeout = 30 * time.Second
+
+// ConnPool keeps idle upstream TCP connections open for reuse, so
+// consecutive forwarded queries skip the TCP handshake
+type ConnPool struct {
+	// MaxIdle is the most idle connections kept; extra ones are closed
+	MaxIdle int
+
+	// IdleTimeout closes connections left unused for longer
+	IdleTimeout time.Duration
+
+	mu   sync.Mutex
+	idle []pooledConn
+}
+
+// pooledConn is an idle connection and when it was returned
+type pooledConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// idleTimeout returns the configured idle timeout, falling back to the
+// default
+func (p *ConnPool) idleTimeout() time.Duration {
+	if p.IdleTimeout > 0 {
+		return p.IdleTimeout
+	}
+	return defaultPoolIdleTimeout
+}
+
+// get returns the most recently used idle connection, or nil if there
+// is none. Connections idle past the timeout are closed first.
+func (p *ConnPool) get() net.Conn {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// idle is ordered oldest first, so expired connections are a prefix
+	expired := 0
+	for expired < len(p.idle) && now.Sub(p.idle[expired].since) >= p.idleTimeout() {
+		p.idle[expired].conn.Close()
+		expired++
+	}
+	p.idle = p.idle[expired:]
+
+	if len(p.idle) == 0 {
+		return nil
+	}
+	conn := p.idle[len(p.idle)-1].conn
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn
+}
+
+// put returns a healthy connection to the pool, closing it if the pool
+// is full
+func (p *ConnPool) put(conn net.Conn) {
+	conn.SetDeadline(time.Time{})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) >= p.MaxIdle {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, pooledConn{conn: conn, since: time.Now()})
+}
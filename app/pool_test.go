@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestPooledTCPResolverReusesConnection(t *testing.T) {
+	addr, accepted := tcpUpstream(t, "127.0.0.1:0", func(query *dns.DNSMessage) *dns.DNSMessage {
+		return reply(query, aRecord(t, query.Questions[0].Name(), "192.0.2.1", 300))
+	})
+	resolver := &TCPResolver{Addr: addr, Pool: &ConnPool{MaxIdle: 2}}
+
+	for _, name := range []string{"one.example", "two.example"} {
+		response, err := resolver.Query(context.Background(), newQuery(t, name, dns.TypeA))
+		if err != nil {
+			t.Fatalf("Query(%s): %v", name, err)
+		}
+		if got := response.Questions[0].Name(); got != name {
+			t.Errorf("answer for %s came back for %s", name, got)
+		}
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("upstream accepted %d connections, want 1", n)
+	}
+}
+
+func TestPoolClosesIdleConnections(t *testing.T) {
+	addr, accepted := tcpUpstream(t, "127.0.0.1:0", func(query *dns.DNSMessage) *dns.DNSMessage {
+		return reply(query)
+	})
+	resolver := &TCPResolver{Addr: addr, Pool: &ConnPool{MaxIdle: 2, IdleTimeout: 20 * time.Millisecond}}
+
+	for range 2 {
+		if _, err := resolver.Query(context.Background(), newQuery(t, "example.com", dns.TypeA)); err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("upstream accepted %d connections, want a fresh one after the idle timeout", n)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Query log formats
+const (
+	QueryLogDefault = "default"
+	QueryLogBIND    = "bind" // BIND's querylog lines
+)
+
+// validQueryLogFormat reports whether format is a known query log format
+func validQueryLogFormat(format string) bool {
+	return format == "" || format == QueryLogDefault || format == QueryLogBIND
+}
+
+// logQuery logs a request once it has been answered, in the configured
+// format. The default format includes how long the answer took and
+// where it came from; BIND's lines have no room for either.
+func (s *DNSServer) logQuery(ctx context.Context, request *dns.DNSMessage, elapsed time.Duration, source string) {
+	if s.QueryLogFormat == QueryLogBIND {
+		s.logQueryBIND(ctx, request)
+		return
+	}
+
+	s.logf("Request ID: %d, Flags: 0x%04x, Questions: %d, answered in %v from %s\n",
+		request.Header.ID, request.Header.Flags, request.Header.QDCount, elapsed, source)
+	for _, q := range request.Questions {
+		s.logf("  Question: %s type %d class %d\n", q.Name(), q.QType, q.QClass)
+	}
+}
+
+// logQueryBIND logs one line per question the way BIND's querylog does:
+//
+//	client 192.0.2.1#53000: query: www.example.com IN A +E (127.0.0.1)
+//
+// The flags are + or - for RD, then E for EDNS, T for TCP, D for DO and
+// C for CD.
+func (s *DNSServer) logQueryBIND(ctx context.Context, request *dns.DNSMessage) {
+	client := "-#0"
+	tcp := false
+	if addr := clientAddr(ctx); addr != nil {
+		if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+			client = host + "#" + port
+		}
+		_, tcp = addr.(*net.TCPAddr)
+	}
+
+	flags := "-"
+	if request.Header.RecursionDesired() {
+		flags = "+"
+	}
+	opt, err := request.OPT()
+	if err == nil && opt != nil {
+		flags += "E"
+	}
+	if tcp {
+		flags += "T"
+	}
+	if err == nil && opt != nil && opt.DO() {
+		flags += "D"
+	}
+	if request.Header.Flags&dns.FlagCD != 0 {
+		flags += "C"
+	}
+
+	server, _, err := net.SplitHostPort(s.LocalAddr().String())
+	if err != nil {
+		server = s.LocalAddr().String()
+	}
+
+	for _, q := range request.Questions {
+		s.logf("client %s: query: %s %s %s %s (%s)\n",
+			client, q.Name(), classString(q.QClass), dns.TypeString(q.QType), flags, server)
+	}
+}
+
+// classString returns the mnemonic for a class, or CLASSn for unknown
+// ones (RFC 3597)
+func classString(class uint16) string {
+	switch class {
+	case dns.ClassIN:
+		return "IN"
+	case 3:
+		return "CH"
+	case 4:
+		return "HS"
+	case dns.ClassNONE:
+		return "NONE"
+	case dns.ClassANY:
+		return "ANY"
+	}
+	return fmt.Sprintf("CLASS%d", class)
+}
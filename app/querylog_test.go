@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestBINDQueryLog(t *testing.T) {
+	var log bytes.Buffer
+	server := newTestServer(t, WithLogger(&log))
+	server.QueryLogFormat = QueryLogBIND
+
+	plain := newQuery(t, "www.example.com", dns.TypeA)
+	edns := newQuery(t, "example.com", dns.TypeAAAA)
+	edns.SetOPT(&dns.OPT{UDPSize: 1232, Flags: dns.FlagDO})
+	edns.Header.Flags |= dns.FlagCD
+	iterative, _ := dns.NewQuery("example.com", dns.TypeMX, false)
+
+	tests := []struct {
+		name   string
+		client net.Addr
+		query  *dns.DNSMessage
+		want   string
+	}{
+		{"UDP", &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53000}, plain,
+			"client 192.0.2.1#53000: query: www.example.com IN A + (127.0.0.1)\n"},
+		{"TCP with EDNS", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}, edns,
+			"client 2001:db8::1#40000: query: example.com IN AAAA +ETDC (127.0.0.1)\n"},
+		{"RD=0", &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53000}, iterative,
+			"client 192.0.2.1#53000: query: example.com IN MX - (127.0.0.1)\n"},
+		{"no client", nil, plain,
+			"client -#0: query: www.example.com IN A + (127.0.0.1)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log.Reset()
+			server.logQuery(withClientAddr(context.Background(), tt.client), tt.query, time.Millisecond, sourceForward)
+			if got := log.String(); got != tt.want {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
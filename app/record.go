@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// recorder appends exchanges to a capture file. Each is the client's
+// address ("udp 192.0.2.1:5353") and two messages, all length-prefixed:
+//
+//	<2-byte length><client><2-byte length><query><2-byte length><response>
+type recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openRecorder opens (or creates) a capture file for appending
+func openRecorder(path string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open record file: %v", err)
+	}
+	return &recorder{file: file}, nil
+}
+
+// Record appends one exchange with client to the capture file
+func (r *recorder) Record(client net.Addr, query, response []byte) error {
+	addr := client.Network() + " " + client.String()
+	buf := make([]byte, 0, 6+len(addr)+len(query)+len(response))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(addr)))
+	buf = append(buf, addr...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(query)))
+	buf = append(buf, query...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(response)))
+	buf = append(buf, response...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.file.Write(buf)
+	return err
+}
+
+// Close closes the capture file
+func (r *recorder) Close() error {
+	return r.file.Close()
+}
+
+// readMessage reads one length-prefixed message
+func readMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// parseClientAddr converts a recorded client address back to a
+// net.Addr; anything unrecognized gives nil, as for a query with no
+// client
+func parseClientAddr(recorded []byte) net.Addr {
+	network, addr, _ := strings.Cut(string(recorded), " ")
+	switch network {
+	case "udp":
+		if udpAddr, err := net.ResolveUDPAddr(network, addr); err == nil {
+			return udpAddr
+		}
+	case "tcp":
+		if tcpAddr, err := net.ResolveTCPAddr(network, addr); err == nil {
+			return tcpAddr
+		}
+	}
+	return nil
+}
+
+// Replay feeds every query in a capture file through HandleQuery, as if
+// from its recorded client, and reports responses that differ from the
+// recorded ones (see sameResponse). It returns the number of exchanges
+// replayed and how many of them differed.
+func (s *DNSServer) Replay(path string) (total, mismatches int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	for {
+		client, err := readMessage(file)
+		if errors.Is(err, io.EOF) {
+			return total, mismatches, nil
+		}
+		if err != nil {
+			return total, mismatches, fmt.Errorf("exchange %d: failed to read client: %v", total+1, err)
+		}
+		query, err := readMessage(file)
+		if err != nil {
+			return total, mismatches, fmt.Errorf("exchange %d: failed to read query: %v", total+1, err)
+		}
+		recorded, err := readMessage(file)
+		if err != nil {
+			return total, mismatches, fmt.Errorf("exchange %d: failed to read response: %v", total+1, err)
+		}
+		total++
+
+		ctx := context.Background()
+		if addr := parseClientAddr(client); addr != nil {
+			ctx = withClientAddr(ctx, addr)
+		}
+		ctx, cancel := context.WithTimeout(ctx, s.timeout())
+		response, err := s.HandleQuery(ctx, query)
+		cancel()
+		if err != nil {
+			s.logf("Exchange %d: error handling query: %v\n", total, err)
+			mismatches++
+			continue
+		}
+
+		if !sameResponse(response, recorded) {
+			s.logf("Exchange %d: response differs (recorded %d bytes, got %d)\n", total, len(recorded), len(response))
+			mismatches++
+		}
+	}
+}
+
+// sameResponse reports whether a replayed response carries the same
+// answer as the recorded one. What changes from run to run is ignored:
+// TTLs decay in the cache, shuffling and rotation reorder records, and
+// the server cookie derives from a per-process secret. Messages that
+// don't parse are compared byte for byte.
+func sameResponse(replayed, recorded []byte) bool {
+	var a, b dns.DNSMessage
+	if a.ParseComplete(replayed) != nil || b.ParseComplete(recorded) != nil {
+		return bytes.Equal(replayed, recorded)
+	}
+	normalizeResponse(&a)
+	normalizeResponse(&b)
+	return a.Equal(&b)
+}
+
+// normalizeResponse strips a parsed response of what sameResponse
+// ignores
+func normalizeResponse(msg *dns.DNSMessage) {
+	if opt, err := msg.OPT(); err == nil && opt != nil {
+		opt.RemoveOption(dns.OptionCookie)
+		msg.SetOPT(opt)
+	}
+	for _, section := range [][]dns.DNSAnswer{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range section {
+			if section[i].Type != dns.TypeOPT { // its TTL holds flags
+				section[i].TTL = 0
+			}
+		}
+		slices.SortFunc(section, func(x, y dns.DNSAnswer) int {
+			return cmp.Or(
+				bytes.Compare(dns.CanonicalName(x.Name), dns.CanonicalName(y.Name)),
+				cmp.Compare(x.Type, y.Type),
+				bytes.Compare(x.RData, y.RData),
+			)
+		})
+	}
+}
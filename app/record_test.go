@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// readCapture returns the exchanges recorded in a capture file
+func readCapture(t *testing.T, path string) (queries, responses [][]byte) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open capture: %v", err)
+	}
+	defer file.Close()
+	for {
+		if _, err := readMessage(file); err != nil {
+			return queries, responses
+		}
+		query, err := readMessage(file)
+		if err != nil {
+			t.Fatalf("capture ends after a client address: %v", err)
+		}
+		response, err := readMessage(file)
+		if err != nil {
+			t.Fatalf("capture ends after a query: %v", err)
+		}
+		queries, responses = append(queries, query), append(responses, response)
+	}
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	const zoneText = "www.example.com A 192.0.2.1\n"
+	server := newTestServer(t)
+	server.zone = testZone(t, zoneText)
+	server.RecordFile = filepath.Join(t.TempDir(), "capture")
+	startServer(t, server)
+
+	conn := dialServer(t, server)
+	for _, name := range []string{"www.example.com", "missing.example.com"} {
+		conn.Write(newQuery(t, name, dns.TypeA).Encode())
+		readResponse(t, conn)
+	}
+
+	same := newTestServer(t)
+	same.zone = testZone(t, zoneText)
+	total, mismatches, err := same.Replay(server.RecordFile)
+	if err != nil || total != 2 || mismatches != 0 {
+		t.Errorf("Replay() = %d, %d, %v, want 2 exchanges and no mismatches", total, mismatches, err)
+	}
+
+	changed := newTestServer(t)
+	changed.zone = testZone(t, "www.example.com A 192.0.2.2\n")
+	total, mismatches, err = changed.Replay(server.RecordFile)
+	if err != nil || total != 2 || mismatches != 1 {
+		t.Errorf("Replay() against a changed zone = %d, %d, %v, want 2 exchanges and 1 mismatch", total, mismatches, err)
+	}
+}
+
+func TestReplayUsesRecordedClient(t *testing.T) {
+	// Forty A records overflow a 512-byte UDP response
+	var zoneText strings.Builder
+	for i := range 40 {
+		fmt.Fprintf(&zoneText, "big.example.com A 192.0.2.%d\n", i+1)
+	}
+	server := newTestServer(t)
+	server.zone = testZone(t, zoneText.String())
+	server.RecordFile = filepath.Join(t.TempDir(), "capture")
+	startServer(t, server)
+
+	conn := dialServer(t, server)
+	conn.Write(newQuery(t, "big.example.com", dns.TypeA).Encode())
+	if response := readResponse(t, conn); !response.Header.Truncated() {
+		t.Fatal("UDP response wasn't truncated")
+	}
+
+	// Replayed as if over UDP again, the response is truncated the same way
+	same := newTestServer(t)
+	same.zone = testZone(t, zoneText.String())
+	total, mismatches, err := same.Replay(server.RecordFile)
+	if err != nil || total != 1 || mismatches != 0 {
+		t.Errorf("Replay() = %d, %d, %v, want 1 exchange and no mismatches", total, mismatches, err)
+	}
+}
+
+func TestSameResponseIgnoresRunToRunChanges(t *testing.T) {
+	query := newQuery(t, "example.com", dns.TypeA)
+	response := func(cookie string, ttl uint32, ips ...string) []byte {
+		var answers []dns.DNSAnswer
+		for _, ip := range ips {
+			answers = append(answers, aRecord(t, "example.com", ip, ttl))
+		}
+		msg := reply(query, answers...)
+		opt := &dns.OPT{UDPSize: 1232}
+		opt.SetOption(dns.OptionCookie, []byte(cookie))
+		msg.SetOPT(opt)
+		return msg.Encode()
+	}
+
+	recorded := response("0123456789abcdef", 300, "192.0.2.1", "192.0.2.2")
+	if !sameResponse(response("0123456789fedcba", 250, "192.0.2.2", "192.0.2.1"), recorded) {
+		t.Error("reordered records with other TTLs and cookie were reported as different")
+	}
+	if sameResponse(response("0123456789abcdef", 300, "192.0.2.1", "192.0.2.3"), recorded) {
+		t.Error("other RDATA was reported as the same")
+	}
+}
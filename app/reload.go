@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// ReloadZone re-reads the zone file at path and swaps its records into
+// the served zone. Queries in flight finish against whichever contents
+// they started with; on error the old records stay in place.
+func (s *DNSServer) ReloadZone(path string) error {
+	if s.zone == nil {
+		return fmt.Errorf("no zone to reload")
+	}
+
+	zone, err := dns.LoadZoneFile(path)
+	if err != nil {
+		return err
+	}
+	s.zone.Replace(zone)
+	return nil
+}
+
+// reloadOnHangup reloads the zone file at path every time the process
+// receives SIGHUP
+func (s *DNSServer) reloadOnHangup(path string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	go func() {
+		for range hangups {
+			if err := s.ReloadZone(path); err != nil {
+				s.logf("Failed to reload zone file: %v\n", err)
+				continue
+			}
+			s.logf("Reloaded zone file: %s\n", path)
+		}
+	}()
+}
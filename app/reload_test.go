@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// writeZoneFile replaces the zone file at path with text
+func writeZoneFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestReloadZone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.zone")
+	writeZoneFile(t, path, "www.example.com A 192.0.2.1\n")
+	zone, err := dns.LoadZoneFile(path)
+	if err != nil {
+		t.Fatalf("LoadZoneFile: %v", err)
+	}
+	server := newTestServer(t)
+	server.zone = zone
+
+	lookup := func(name string) []string {
+		return answerIPs(exchange(t, server, newQuery(t, name, dns.TypeA)))
+	}
+
+	writeZoneFile(t, path, "www.example.com A 192.0.2.2\nnew.example.com A 192.0.2.3\n")
+	if err := server.ReloadZone(path); err != nil {
+		t.Fatalf("ReloadZone: %v", err)
+	}
+	if ips := lookup("www.example.com"); len(ips) != 1 || ips[0] != "192.0.2.2" {
+		t.Errorf("www.example.com after reload = %v, want [192.0.2.2]", ips)
+	}
+	if ips := lookup("new.example.com"); len(ips) != 1 || ips[0] != "192.0.2.3" {
+		t.Errorf("new.example.com after reload = %v, want [192.0.2.3]", ips)
+	}
+
+	// A broken file leaves the served records alone
+	writeZoneFile(t, path, "www.example.com A not-an-ip\n")
+	if err := server.ReloadZone(path); err == nil {
+		t.Error("ReloadZone accepted a broken zone file")
+	}
+	if ips := lookup("www.example.com"); len(ips) != 1 || ips[0] != "192.0.2.2" {
+		t.Errorf("www.example.com after a failed reload = %v, want [192.0.2.2]", ips)
+	}
+}
+
+func TestHangupReloadsZone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.zone")
+	writeZoneFile(t, path, "www.example.com A 192.0.2.1\n")
+	zone, err := dns.LoadZoneFile(path)
+	if err != nil {
+		t.Fatalf("LoadZoneFile: %v", err)
+	}
+	logs := make(logLines, 16)
+	server := newTestServer(t, WithLogger(logs))
+	server.zone = zone
+	server.reloadOnHangup(path)
+
+	writeZoneFile(t, path, "www.example.com A 192.0.2.2\n")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	timeout := time.After(2 * time.Second)
+	for reloaded := false; !reloaded; {
+		select {
+		case line := <-logs:
+			reloaded = strings.HasPrefix(line, "Reloaded zone file")
+		case <-timeout:
+			t.Fatal("SIGHUP didn't reload the zone")
+		}
+	}
+
+	if ips := answerIPs(exchange(t, server, newQuery(t, "www.example.com", dns.TypeA))); len(ips) != 1 || ips[0] != "192.0.2.2" {
+		t.Errorf("www.example.com after SIGHUP = %v, want [192.0.2.2]", ips)
+	}
+}
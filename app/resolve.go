@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Resolve looks up name the way a client query would be answered, going
+// through the handlers, zone, cache and resolver, and returns the
+// answers. Responses other than NOERROR are returned as errors.
+func (s *DNSServer) Resolve(ctx context.Context, name string, qtype uint16) ([]dns.DNSAnswer, error) {
+	response, err := s.lookup(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	if rcode := response.RCode(); rcode != dns.RCodeNoError {
+		return nil, fmt.Errorf("resolving %s failed with RCODE %d", name, rcode)
+	}
+	return response.Answers, nil
+}
+
+// lookup runs a recursive IN query for name through HandleQuery within
+// the configured timeout and returns the parsed response
+func (s *DNSServer) lookup(ctx context.Context, name string, qtype uint16) (*dns.DNSMessage, error) {
+	query, err := dns.NewQuery(name, qtype, true)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	data, err := s.HandleQuery(ctx, query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var response dns.DNSMessage
+	if err := response.ParseComplete(data); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	return &response, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestResolve(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = answerA(t, "192.0.2.9")
+
+	answers, err := server.Resolve(context.Background(), "example.com", dns.TypeA)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if len(answers) != 1 {
+		t.Fatalf("got %d answers, want 1", len(answers))
+	}
+	if ip, ok := answers[0].IP(); !ok || ip.String() != "192.0.2.9" || answers[0].TTL != 300 {
+		t.Errorf("answer = %v TTL %d, want 192.0.2.9 TTL 300", ip, answers[0].TTL)
+	}
+
+	server.resolver = failingResolver
+	if _, err := server.Resolve(context.Background(), "example.org", dns.TypeA); err == nil {
+		t.Error("Resolve succeeded on SERVFAIL")
+	}
+	if _, err := server.Resolve(context.Background(), "bad..name", dns.TypeA); err == nil {
+		t.Error("Resolve accepted an invalid name")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Resolver answers DNS queries that the server does not answer itself
+type Resolver interface {
+	Query(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error)
+}
+
+// UDPResolver forwards queries to an upstream DNS server over UDP
+type UDPResolver struct {
+	Addr string
+
+	// LocalAddr, when set, is the source of upstream queries: "ip:port"
+	// or "ip:first-last" to pick a random port from a range
+	LocalAddr string
+
+	// TCP, when set, is used to retry truncated responses; by default
+	// each retry opens its own connection
+	TCP *TCPResolver
+
+	// NoTCPFallback returns truncated responses as they are, TC bit
+	// and all, instead of retrying them over TCP
+	NoTCPFallback bool
+}
+
+// Upstream protocols (see DNSServer.UpstreamProtocol)
+const (
+	UpstreamAuto = "auto" // UDP, retrying truncated responses over TCP
+	UpstreamUDP  = "udp"  // UDP only, passing truncated responses on
+	UpstreamTCP  = "tcp"  // TCP only
+)
+
+// validUpstreamProtocol reports whether protocol is a known upstream
+// protocol
+func validUpstreamProtocol(protocol string) bool {
+	return protocol == "" || protocol == UpstreamAuto || protocol == UpstreamUDP || protocol == UpstreamTCP
+}
+
+// defaultDNSPort is used for resolver addresses given without a port
+const defaultDNSPort = "53"
+
+// resolverAddr normalizes a resolver address to host:port. Bare IP
+// literals, including unbracketed IPv6 ones, get the default DNS port.
+func resolverAddr(addr string) (string, error) {
+	if ip := net.ParseIP(strings.Trim(addr, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), defaultDNSPort), nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", fmt.Errorf("invalid resolver address %q: %v", addr, err)
+	}
+	return addr, nil
+}
+
+// network picks the IP-version specific network ("udp6", "tcp4", ...)
+// for an address with an IP literal host, so IPv6 resolvers are always
+// reached over IPv6; hostnames use the dual-stack network
+func network(base, addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return base
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return base
+	case ip.To4() != nil:
+		return base + "4"
+	default:
+		return base + "6"
+	}
+}
+
+// sourcePortAttempts bounds how many ports of a range are tried before
+// giving up on binding the source address
+const sourcePortAttempts = 8
+
+// parseSourceRange splits an "ip:port" or "ip:first-last" source spec
+func parseSourceRange(spec string) (ip net.IP, first, last int, err error) {
+	host, ports, err := net.SplitHostPort(spec)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid source address %q: %v", spec, err)
+	}
+	if host != "" {
+		if ip = net.ParseIP(host); ip == nil {
+			return nil, 0, 0, fmt.Errorf("invalid source IP %q", host)
+		}
+	}
+
+	lo, hi, isRange := strings.Cut(ports, "-")
+	if first, err = strconv.Atoi(lo); err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid source port %q", lo)
+	}
+	last = first
+	if isRange {
+		if last, err = strconv.Atoi(hi); err != nil {
+			return nil, 0, 0, fmt.Errorf("invalid source port %q", hi)
+		}
+	}
+	if first < 0 || last > 65535 || first > last {
+		return nil, 0, 0, fmt.Errorf("invalid source port range %q", ports)
+	}
+
+	return ip, first, last, nil
+}
+
+// dial connects to the upstream server, binding the configured source
+// address if any. With a port range, busy ports are skipped.
+func (r *UDPResolver) dial(ctx context.Context) (net.Conn, error) {
+	if r.LocalAddr == "" {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network("udp", r.Addr), r.Addr)
+	}
+
+	ip, first, last, err := parseSourceRange(r.LocalAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		port := first + rand.IntN(last-first+1)
+		dialer := net.Dialer{LocalAddr: &net.UDPAddr{IP: ip, Port: port}}
+		conn, err := dialer.DialContext(ctx, network("udp", r.Addr), r.Addr)
+		if err == nil || first == last || attempt+1 >= sourcePortAttempts {
+			return conn, err
+		}
+	}
+}
+
+// Query sends the query to the upstream server and parses its response
+func (r *UDPResolver) Query(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+	// Connect to resolver
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to resolver: %v", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Unblock the read promptly if the context is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// Send query to resolver
+	_, err = conn.Write(query.Encode())
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to send query to resolver: %v", err)
+	}
+
+	data, err := readReply(ctx, conn, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// A truncated answer (TC=1) may be cut anywhere, so check the
+	// header alone and retry over TCP for the full response
+	var header dns.DNSHeader
+	if err := header.Parse(data); err == nil && header.Truncated() && !r.NoTCPFallback {
+		tcp := r.TCP
+		if tcp == nil {
+			tcp = &TCPResolver{Addr: r.Addr}
+		}
+		return tcp.Query(ctx, query)
+	}
+
+	var response dns.DNSMessage
+	if err := response.ParseComplete(data); err != nil {
+		// Keep what precedes the cut of a truncated response
+		if !header.Truncated() || response.Parse(data) != nil {
+			return nil, fmt.Errorf("failed to parse response from resolver: %v", err)
+		}
+		response.Answers, response.Authorities, response.Additionals = nil, nil, nil
+		response.SyncCounts()
+	}
+
+	return &response, nil
+}
+
+// readReply reads datagrams from conn until one answers query: one
+// with its ID and question. Others, stray or spoofed, are skipped
+// rather than failing the query, as long as the deadline allows.
+func readReply(ctx context.Context, conn net.Conn, query *dns.DNSMessage) ([]byte, error) {
+	// EDNS (e.g. DNSSEC) responses may be well over 512 bytes, so
+	// allow a full datagram
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to read response from resolver: %v", err)
+		}
+
+		var reply dns.DNSMessage
+		if reply.Parse(buf[:n]) == nil && validateResponse(query, &reply, false) == nil {
+			return buf[:n], nil
+		}
+	}
+}
+
+// upstream returns the resolver to forward a query for name to: that of
+// its forward zone if it has one, else the default, following
+// UpstreamProtocol
+func (s *DNSServer) upstream(name []byte) Resolver {
+	resolver := s.resolver
+	if r, ok := s.forwardZoneResolver(name); ok {
+		resolver = r
+	}
+
+	udp, ok := resolver.(*UDPResolver)
+	if !ok {
+		return resolver
+	}
+
+	switch s.UpstreamProtocol {
+	case UpstreamTCP:
+		if udp.TCP != nil {
+			return udp.TCP
+		}
+		return &TCPResolver{Addr: udp.Addr}
+	case UpstreamUDP:
+		noFallback := *udp
+		noFallback.NoTCPFallback = true
+		return &noFallback
+	}
+	return udp
+}
+
+// TCPResolver forwards queries to an upstream DNS server over TCP, one
+// connection per query unless a Pool is set
+type TCPResolver struct {
+	Addr string
+
+	// Pool, when set, keeps connections open between queries
+	Pool *ConnPool
+}
+
+// Query sends the query to the upstream server and parses its response.
+// A pooled connection the upstream has since closed is retried once on
+// a fresh connection.
+func (r *TCPResolver) Query(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+	if r.Pool != nil {
+		if conn := r.Pool.get(); conn != nil {
+			response, err := r.exchange(ctx, conn, query)
+			if err == nil || ctx.Err() != nil {
+				return response, err
+			}
+		}
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network("tcp", r.Addr), r.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to resolver: %v", err)
+	}
+	return r.exchange(ctx, conn, query)
+}
+
+// exchange sends the query over conn and reads the response. conn is
+// returned to the pool after a clean exchange and closed otherwise.
+func (r *TCPResolver) exchange(ctx context.Context, conn net.Conn, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+	keep := false
+	defer func() {
+		if !keep {
+			conn.Close()
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Unblock the read promptly if the context is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := writeTCPMessage(conn, query.Encode()); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to send query to resolver: %v", err)
+	}
+
+	buf, err := readTCPMessage(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to read response from resolver: %v", err)
+	}
+
+	var response dns.DNSMessage
+	if err := response.ParseComplete(buf); err != nil {
+		return nil, fmt.Errorf("failed to parse response from resolver: %v", err)
+	}
+
+	// Only reuse the connection if the context didn't close it
+	if r.Pool != nil && stop() {
+		keep = true
+		r.Pool.put(conn)
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// silentUpstream returns the address of a UDP socket that reads
+// queries and never answers them
+func silentUpstream(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// answeringUpstream returns the address of a UDP upstream answering
+// every query with an empty NOERROR response, and a channel receiving
+// the address each query came from
+func answeringUpstream(t *testing.T) (string, <-chan net.Addr) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	sources := make(chan net.Addr, 16)
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dns.DNSMessage
+			if err := query.Parse(buf[:n]); err != nil {
+				continue
+			}
+			sources <- from
+			conn.WriteTo(reply(&query).Encode(), from)
+		}
+	}()
+	return conn.LocalAddr().String(), sources
+}
+
+// freePort returns a UDP port on the loopback address that was free
+// a moment ago
+func freePort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// tcpUpstream serves DNS over TCP on addr, answering every query on a
+// connection through answer. It returns the listener's address and a
+// counter of the connections accepted.
+func tcpUpstream(t *testing.T, addr string, answer func(query *dns.DNSMessage) *dns.DNSMessage) (string, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	accepted := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				for {
+					data, err := readTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					var query dns.DNSMessage
+					if err := query.Parse(data); err != nil {
+						return
+					}
+					writeTCPMessage(conn, answer(&query).Encode())
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), accepted
+}
+
+func TestUDPResolverReturnsPromptlyOnCancel(t *testing.T) {
+	resolver := &UDPResolver{Addr: silentUpstream(t)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := resolver.Query(ctx, newQuery(t, "example.com", dns.TypeA))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Query() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Query() took %v to notice the cancellation", elapsed)
+	}
+}
+
+func TestUDPResolverSkipsMismatchedDatagrams(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dns.DNSMessage
+		if err := query.Parse(buf[:n]); err != nil {
+			return
+		}
+
+		// A stray answer with another ID and a spoofed one for another
+		// name arrive before the real answer
+		stray := reply(&query, aRecord(t, "example.com", "203.0.113.1", 300))
+		stray.Header.ID++
+		spoofed := reply(newQuery(t, "other.example", dns.TypeA), aRecord(t, "other.example", "203.0.113.2", 300))
+		spoofed.Header.ID = query.Header.ID
+		for _, msg := range []*dns.DNSMessage{stray, spoofed, reply(&query, aRecord(t, "example.com", "192.0.2.1", 300))} {
+			conn.WriteTo(msg.Encode(), from)
+		}
+	}()
+
+	resolver := &UDPResolver{Addr: conn.LocalAddr().String()}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	response, err := resolver.Query(ctx, newQuery(t, "example.com", dns.TypeA))
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.1" {
+		t.Errorf("answers = %v, want the real [192.0.2.1]", ips)
+	}
+}
+
+func TestUpstreamQueriesUseSourcePort(t *testing.T) {
+	upstream, sources := answeringUpstream(t)
+	port := freePort(t)
+	server := newTestServer(t, WithResolver(upstream), WithUpstreamSource(fmt.Sprintf("127.0.0.1:%d", port)))
+
+	exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+	select {
+	case from := <-sources:
+		if got := from.(*net.UDPAddr).Port; got != port {
+			t.Errorf("query came from port %d, want %d", got, port)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the upstream saw no query")
+	}
+
+	if _, err := NewDNSServer("127.0.0.1:0", WithUpstreamSource("127.0.0.1:9-1")); err == nil {
+		t.Error("NewDNSServer accepted an inverted port range")
+	}
+}
+
+// truncatingUpstream returns the address of an upstream answering over
+// UDP with an empty truncated response and over TCP with two A records,
+// and counters of the UDP queries and TCP connections it received
+func truncatingUpstream(t *testing.T) (string, *atomic.Int32, *atomic.Int32) {
+	t.Helper()
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { udpConn.Close() })
+	udpQueries := new(atomic.Int32)
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := udpConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dns.DNSMessage
+			if query.Parse(buf[:n]) != nil {
+				continue
+			}
+			udpQueries.Add(1)
+			truncated := reply(&query)
+			truncated.Header.Flags |= dns.FlagTC
+			udpConn.WriteTo(truncated.Encode(), from)
+		}
+	}()
+
+	// The TCP side of the same address has the full answer
+	addr := udpConn.LocalAddr().String()
+	_, tcpConns := tcpUpstream(t, addr, func(query *dns.DNSMessage) *dns.DNSMessage {
+		return reply(query,
+			aRecord(t, "example.com", "192.0.2.1", 300),
+			aRecord(t, "example.com", "192.0.2.2", 300))
+	})
+	return addr, udpQueries, tcpConns
+}
+
+func TestTruncatedAnswerIsRetriedOverTCP(t *testing.T) {
+	addr, _, _ := truncatingUpstream(t)
+	server := newTestServer(t, WithResolver(addr))
+	response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+	if ips := answerIPs(response); len(ips) != 2 {
+		t.Errorf("answers = %v, want both TCP answers", ips)
+	}
+	if response.Header.Truncated() {
+		t.Error("the client's response is still truncated")
+	}
+}
+
+func TestUpstreamProtocolModes(t *testing.T) {
+	tests := []struct {
+		protocol  string
+		udp, tcp  int32
+		answers   int
+		truncated bool
+	}{
+		{UpstreamAuto, 1, 1, 2, false},
+		{UpstreamTCP, 0, 1, 2, false},
+		{UpstreamUDP, 1, 0, 0, true},
+	}
+	for _, tt := range tests {
+		addr, udpQueries, tcpConns := truncatingUpstream(t)
+		server := newTestServer(t, WithResolver(addr))
+		server.UpstreamProtocol = tt.protocol
+
+		response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+		if udp, tcp := udpQueries.Load(), tcpConns.Load(); udp != tt.udp || tcp != tt.tcp {
+			t.Errorf("%s: %d UDP queries and %d TCP connections, want %d and %d", tt.protocol, udp, tcp, tt.udp, tt.tcp)
+		}
+		if len(response.Answers) != tt.answers || response.Header.Truncated() != tt.truncated {
+			t.Errorf("%s: %d answers, TC %t, want %d, %t", tt.protocol, len(response.Answers), response.Header.Truncated(), tt.answers, tt.truncated)
+		}
+	}
+}
+
+func TestResolverAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    string
+		network string
+	}{
+		{"192.0.2.1", "192.0.2.1:53", "udp4"},
+		{"192.0.2.1:5353", "192.0.2.1:5353", "udp4"},
+		{"2606:4700:4700::1111", "[2606:4700:4700::1111]:53", "udp6"},
+		{"[2606:4700:4700::1111]", "[2606:4700:4700::1111]:53", "udp6"},
+		{"[::1]:5353", "[::1]:5353", "udp6"},
+		{"dns.example:53", "dns.example:53", "udp"},
+	}
+	for _, tt := range tests {
+		got, err := resolverAddr(tt.addr)
+		if err != nil || got != tt.want {
+			t.Errorf("resolverAddr(%q) = %q, %v, want %q", tt.addr, got, err, tt.want)
+			continue
+		}
+		if network := network("udp", got); network != tt.network {
+			t.Errorf("network for %q = %s, want %s", got, network, tt.network)
+		}
+	}
+	if _, err := resolverAddr("dns.example"); err == nil {
+		t.Error("resolverAddr accepted a hostname without a port")
+	}
+}
+
+func TestForwardToIPv6Resolver(t *testing.T) {
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dns.DNSMessage
+			if query.Parse(buf[:n]) != nil {
+				continue
+			}
+			conn.WriteTo(reply(&query, aRecord(t, "example.com", "192.0.2.6", 300)).Encode(), from)
+		}
+	}()
+
+	server := newTestServer(t, WithResolver(conn.LocalAddr().String()))
+	response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+	if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.6" {
+		t.Errorf("answers = %v, want [192.0.2.6] from the IPv6 resolver", ips)
+	}
+}
@@ -1,181 +1,1240 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
 )
 
-// DNSServer handles DNS server operations
+const (
+	// defaultTimeout bounds how long a single query may take to handle
+	defaultTimeout = 5 * time.Second
+
+	// defaultWriteTimeout bounds how long sending a response may take
+	defaultWriteTimeout = 2 * time.Second
+
+	// defaultPacketSize is the classic DNS-over-UDP message limit
+	defaultPacketSize = 512
+
+	// maxPacketSize is the largest message a UDP datagram or a TCP
+	// length prefix can carry
+	maxPacketSize = 65535
+
+	// defaultPaddingBlockSize is the response block size recommended by
+	// RFC 8467
+	defaultPaddingBlockSize = 468
+
+	// defaultUpstreamUDPSize is the EDNS buffer size advertised to
+	// upstreams, the DNS flag day 2020 recommendation
+	defaultUpstreamUDPSize = 1232
+
+	// defaultMaxUDPResponseSize is the largest UDP response sent by
+	// default, the size DNS flag day 2020 found to avoid fragmentation
+	defaultMaxUDPResponseSize = 1232
+
+	// defaultMaxInFlight caps the queries handled concurrently
+	defaultMaxInFlight = 100
+
+	// defaultMaxQuestions refuses many-question queries, since most
+	// clients send a single question
+	defaultMaxQuestions = 1
+
+	// inFlightWait is how long a query waits for a free handler slot
+	// before it is refused
+	inFlightWait = 50 * time.Millisecond
+)
+
+// DNSServer handles DNS server operations. Its exported fields are part
+// of its configuration alongside the Options given to NewDNSServer, and
+// must be set before Run.
 type DNSServer struct {
+	// Debug validates every locally built response before encoding it
+	Debug bool
+
+	// QueryLogFormat selects how incoming queries are logged:
+	// QueryLogDefault or QueryLogBIND
+	QueryLogFormat string
+
+	// Timeout bounds the handling of each query, including upstream
+	// round trips (defaults to 5s)
+	Timeout time.Duration
+
+	// WriteTimeout bounds how long sending a response may block on a
+	// congested socket before it is dropped (defaults to 2s). UDP
+	// deadlines are per socket, so responses are written one at a time.
+	WriteTimeout time.Duration
+
+	// MaxPacketSize is the size of the buffer inbound UDP queries are read
+	// into (defaults to 512, at most 65535)
+	MaxPacketSize int
+
+	// MaxUDPResponseSize caps UDP responses whatever payload size the
+	// client advertises; larger ones are truncated (defaults to 1232)
+	MaxUDPResponseSize int
+
+	// MetricsAddr, when set, serves Prometheus metrics at /metrics on
+	// this address while the server runs
+	MetricsAddr string
+
+	// APIAddr, when set, serves a JSON query API at /resolve on this
+	// address while the server runs
+	APIAddr string
+
+	// DefaultAnswerIP is the address given for every question when no
+	// zone is configured (defaults to 8.8.8.8)
+	DefaultAnswerIP net.IP
+
+	// RandomizeCase enables 0x20 encoding: forwarded QNAMEs get random
+	// letter casing and responses must echo it exactly
+	RandomizeCase bool
+
+	// HealthCheckName is always answered locally with TXT "ok"
+	// (defaults to "health.check"; empty disables it)
+	HealthCheckName string
+
+	// MinTTL and MaxTTL clamp the TTLs of forwarded records; zero
+	// leaves that bound unset
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// RecordFile, when set, captures every query and response handled
+	// by Run for later Replay
+	RecordFile string
+
+	// AllowUpdates accepts DNS UPDATE (RFC 2136) messages against the
+	// loaded zone; they are refused otherwise
+	AllowUpdates bool
+
+	// CookieRotation is how often the secret behind our DNS cookies is
+	// replaced; cookies from the previous secret stay valid for one more
+	// interval. Zero keeps one secret for the server's lifetime.
+	CookieRotation time.Duration
+
+	// RequireCookies answers a query whose server cookie we didn't
+	// issue (or issued under a secret since retired) with BADCOOKIE and
+	// a fresh cookie instead of resolving it (RFC 7873 section 5.2.3).
+	// Otherwise such queries are answered normally, with a fresh cookie.
+	RequireCookies bool
+
+	// ForwardClientSubnet passes the client's EDNS Client Subnet option
+	// (RFC 7871) on to upstreams unchanged; by default it is removed
+	ForwardClientSubnet bool
+
+	// MinimalResponses strips forwarded responses down to what the
+	// client needs: no authority records unless the answer is negative,
+	// and no additional records
+	MinimalResponses bool
+
+	// ShuffleAnswers randomizes the order of the records in each answer
+	// RRset
+	ShuffleAnswers bool
+
+	// Rand, when set, is the source of randomness for ShuffleAnswers,
+	// e.g. rand.New(rand.NewPCG(1, 2)) for a reproducible order in tests
+	Rand *rand.Rand
+
+	// AllowTransfer serves zone transfers (AXFR) of the loaded zone over
+	// TCP; they are refused otherwise
+	AllowTransfer bool
+
+	// PaddingBlockSize is the block size that TCP responses to queries
+	// carrying an EDNS PADDING option are padded to (defaults to 468)
+	PaddingBlockSize int
+
+	// ResponseDelay holds back responses for chaos testing; it is off
+	// unless set. DelayProbability is the fraction of queries delayed
+	// (zero delays every query).
+	ResponseDelay    time.Duration
+	DelayProbability float64
+
+	// ListenTCP also serves DNS over TCP on the same address as UDP
+	ListenTCP bool
+
+	// UpstreamUDPSize is the EDNS UDP payload size advertised in
+	// forwarded queries (defaults to 1232)
+	UpstreamUDPSize int
+
+	// UpstreamProtocol is how queries reach the resolver: "udp", "tcp"
+	// or "auto" (UDP with TCP retries of truncated responses, the default)
+	UpstreamProtocol string
+
+	// AuthoritativeOnly answers only names under OwnedZones, refusing
+	// everything else, and never forwards
+	AuthoritativeOnly bool
+	OwnedZones        []string
+
+	// SystemFallback answers A and AAAA queries for names the zone
+	// doesn't have through the system resolver: SystemResolver, or
+	// net.DefaultResolver when that is nil
+	SystemFallback bool
+	SystemResolver *net.Resolver
+
+	// NSID is the identifier returned to queries carrying the NSID
+	// option (RFC 5001), e.g. to tell anycast instances apart; empty
+	// leaves the option unanswered
+	NSID string
+
+	// LocalZones answers localhost and the RFC 6303 special-use reverse
+	// zones locally instead of forwarding them (on by default)
+	LocalZones bool
+
+	// MaxQuestions, when set, refuses queries with more questions
+	// (defaults to 1). Zero allows any number, answered question by
+	// question, for clients that send several.
+	MaxQuestions int
+
+	// FailNames always get SERVFAIL, for failure-injection testing
+	FailNames []string
+
+	// StripEDNS removes the OPT record from forwarded queries, for
+	// upstreams that don't understand EDNS
+	StripEDNS bool
+
+	// ChaseCNAME follows a CNAME that ends a forwarded answer with
+	// further upstream queries, for upstreams that don't chase them
+	ChaseCNAME bool
+
+	// DropUnknownOptions removes EDNS options the server has no support
+	// for from forwarded queries; by default they are passed on unchanged
+	DropUnknownOptions bool
+
+	// Cache stores forwarded responses, including negative ones. When
+	// nil, NewDNSServer (or Run) creates a MemoryCache of CacheSize entries
+	// if CacheSize is set; otherwise nothing is cached.
+	Cache     Cache
+	CacheSize int
+
+	// ServeStale keeps cached responses this long past their TTL, to
+	// answer with when the upstream fails (RFC 8767); zero disables it
+	ServeStale time.Duration
+
+	// MaxInFlight caps the queries Run handles concurrently; excess
+	// queries are refused (defaults to 100)
+	MaxInFlight int
+
 	conn     *net.UDPConn
-	resolver string
+	resolver Resolver
+	zone     *dns.Zone
+	mux      *Mux
+	metrics  *Metrics
+
+	// forwardZones maps canonical wire-format zone names to the
+	// resolvers their queries go to (see WithForwardZone)
+	forwardZones map[string]Resolver
+
+	// upstreamSource is the source address of UDP upstream queries
+	// (see WithUpstreamSource)
+	upstreamSource string
+
+	// tcpPoolSize and tcpPoolIdleTimeout configure a connection pool
+	// for each upstream's TCP queries (see WithTCPPool)
+	tcpPoolSize        int
+	tcpPoolIdleTimeout time.Duration
+
+	middleware []Middleware // wrapped around respond (see Use)
+
+	logger io.Writer // where log output goes (see WithLogger)
+
+	randMu sync.Mutex // guards Rand, which isn't safe for concurrent use
+
+	writeMu sync.Mutex // serializes UDP writes and their socket deadline
+
+	packets sync.Pool // read buffers for inbound UDP queries (see Run)
+
+	cookies cookieSecrets // key the server cookies we hand out
+}
+
+// clientAddrKey carries the querying client's address in a context
+type clientAddrKey struct{}
+
+// withClientAddr returns a context carrying the client's address
+func withClientAddr(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, clientAddrKey{}, addr)
+}
+
+// clientAddr returns the client's address from the context, if known
+func clientAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(clientAddrKey{}).(net.Addr)
+	return addr
 }
 
-// NewDNSServer creates a new DNS server instance
-func NewDNSServer(addr, resolver string) (*DNSServer, error) {
+// NewDNSServer creates a new DNS server instance listening on addr,
+// configured by opts
+func NewDNSServer(addr string, opts ...Option) (*DNSServer, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid listen address %q: %v", addr, err)
 	}
 
 	conn, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
+		return nil, fmt.Errorf("failed to bind %s: %v", addr, err)
+	}
+
+	server := &DNSServer{
+		conn:            conn,
+		Timeout:         defaultTimeout,
+		WriteTimeout:    defaultWriteTimeout,
+		HealthCheckName: defaultHealthCheckName,
+		MaxInFlight:     defaultMaxInFlight,
+		MaxQuestions:    defaultMaxQuestions,
+		LocalZones:      true,
+		metrics:         NewMetrics(),
+	}
+	for _, opt := range opts {
+		if err := opt(server); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if err := server.setup(); err != nil {
+		conn.Close()
 		return nil, err
 	}
+	server.configureUpstreams()
+
+	return server, nil
+}
+
+// configureUpstreams applies the upstream settings to every UDP
+// resolver the options set up, whichever order they came in
+func (s *DNSServer) configureUpstreams() {
+	resolvers := []Resolver{s.resolver}
+	for _, resolver := range s.forwardZones {
+		resolvers = append(resolvers, resolver)
+	}
+	for _, resolver := range resolvers {
+		udp, ok := resolver.(*UDPResolver)
+		if !ok {
+			continue
+		}
+		if s.upstreamSource != "" {
+			udp.LocalAddr = s.upstreamSource
+		}
+		if s.tcpPoolSize > 0 {
+			udp.TCP = &TCPResolver{
+				Addr: udp.Addr,
+				Pool: &ConnPool{MaxIdle: s.tcpPoolSize, IdleTimeout: s.tcpPoolIdleTimeout},
+			}
+		}
+	}
+}
+
+// logf writes log output to the configured logger, or stdout
+func (s *DNSServer) logf(format string, args ...any) {
+	w := s.logger
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// LocalAddr returns the address the server is listening on, which
+// carries the chosen port when bound to port 0
+func (s *DNSServer) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
 
-	return &DNSServer{conn: conn, resolver: resolver}, nil
+// Handle registers a handler for queries under suffix; queries that
+// match no handler fall through to the zone or the resolver
+func (s *DNSServer) Handle(suffix string, handler HandlerFunc) error {
+	if s.mux == nil {
+		s.mux = NewMux()
+	}
+	return s.mux.Handle(suffix, handler)
 }
 
 // HandleQuery processes a DNS query and returns the response
-func (s *DNSServer) HandleQuery(data []byte) ([]byte, error) {
-	// Parse the request
+// The context bounds any upstream forwarding
+func (s *DNSServer) HandleQuery(ctx context.Context, data []byte) ([]byte, error) {
+	return s.handleQuery(ctx, data, nil)
+}
+
+// handleQuery is HandleQuery encoding the response into buf's storage
+func (s *DNSServer) handleQuery(ctx context.Context, data, buf []byte) ([]byte, error) {
+	start := time.Now()
+	defer func() { s.metrics.ObserveQuery(time.Since(start)) }()
+
+	// No transport carries a message over 65535 bytes, so a bigger one
+	// (e.g. from the API or a replay file) is malformed
+	if len(data) > maxPacketSize {
+		var request dns.DNSMessage
+		if err := request.Header.Parse(data); err != nil || request.Header.IsResponse() {
+			return nil, fmt.Errorf("ignoring %d-byte message", len(data))
+		}
+		return errorResponse(&request, dns.RCodeFormErr).EncodeTo(buf), nil
+	}
+
+	// Parse the request, including its additional section (EDNS)
 	var request dns.DNSMessage
-	if err := request.Parse(data); err != nil {
+	if err := request.ParseComplete(data); err != nil {
 		return nil, fmt.Errorf("failed to parse request: %v", err)
 	}
 
-	fmt.Printf("Request ID: %d, Flags: 0x%04x, Questions: %d\n",
-		request.Header.ID, request.Header.Flags, request.Header.QDCount)
+	// Never answer a response: that's how reflection loops start
+	if request.Header.IsResponse() {
+		return nil, fmt.Errorf("ignoring response message (QR=1) with ID %d", request.Header.ID)
+	}
+
+	// Padding only hides anything on an encrypted transport (RFC 8467),
+	// so plain UDP responses are never padded; TCP ones may be sent on
+	// through a TLS terminator
+	_, tcp := clientAddr(ctx).(*net.TCPAddr)
+	padded := tcp && wantsPadding(&request)
+
+	ctx, source := withAnswerSource(ctx)
+	response := s.handler()(ctx, &request)
+	s.delay(ctx)
 
-	// If resolver is set, forward the query
-	if s.resolver != "" {
-		return s.forwardQuery(&request)
+	// A UDP response must fit the payload size the client can take, and
+	// one over TCP the 65535 bytes its length prefix can describe
+	limit := maxPacketSize
+	if _, udp := clientAddr(ctx).(*net.UDPAddr); udp {
+		limit = s.udpResponseSize(&request)
 	}
+	response.Truncate(limit)
 
-	// Build response (for non-forwarding mode)
-	response := request.BuildResponse()
+	// Pad last, so the padding is sized for what is actually sent
+	if padded {
+		s.pad(response, limit)
+	}
+
+	if s.Debug {
+		if err := response.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid response: %v", err)
+		}
+	}
 
 	// Encode to bytes
-	return response.Encode(), nil
+	encoded := response.EncodeTo(buf)
+	s.logQuery(ctx, &request, time.Since(start), *source)
+	return encoded, nil
+}
+
+// respond builds the response for a parsed request, handling the
+// client's EDNS state around the actual answer
+func (s *DNSServer) respond(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+	opt, err := request.OPT()
+	if err != nil {
+		return errorResponse(request, dns.RCodeFormErr)
+	}
+
+	// We only speak EDNS version 0
+	if opt != nil && opt.Version != 0 {
+		response := errorResponse(request, dns.RCodeNoError)
+		s.addOPT(ctx, response, opt, nil)
+		response.SetRCode(dns.RCodeBadVers)
+		return response
+	}
+
+	var clientCookie []byte
+	if opt != nil {
+		if data, ok := opt.Option(dns.OptionCookie); ok {
+			var serverCookie []byte
+			if clientCookie, serverCookie, err = parseCookie(data); err != nil {
+				return errorResponse(request, dns.RCodeFormErr)
+			}
+			if len(serverCookie) > 0 && !s.validServerCookie(clientCookie, serverCookie, clientAddr(ctx)) {
+				if s.Debug {
+					s.logf("Invalid server cookie from %s\n", clientAddr(ctx))
+				}
+				if s.RequireCookies {
+					response := errorResponse(request, dns.RCodeNoError)
+					s.addOPT(ctx, response, opt, clientCookie)
+					response.SetRCode(dns.RCodeBadCookie)
+					return response
+				}
+			}
+
+			// Cookies are hop-by-hop, so never pass the client's upstream
+			opt.RemoveOption(dns.OptionCookie)
+			request.SetOPT(opt)
+		}
+	}
+
+	// Padding is hop-by-hop too; handleQuery pads the final response
+	if opt != nil {
+		if _, ok := opt.Option(dns.OptionPadding); ok {
+			opt.RemoveOption(dns.OptionPadding)
+			request.SetOPT(opt)
+		}
+	}
+
+	// NSID identifies us, not the upstream, so it's answered here
+	var wantsNSID bool
+	if opt != nil {
+		if _, wantsNSID = opt.Option(dns.OptionNSID); wantsNSID {
+			opt.RemoveOption(dns.OptionNSID)
+			request.SetOPT(opt)
+		}
+	}
+
+	// Log the client subnet, and keep it from upstreams unless they
+	// are meant to tailor answers to it
+	if opt != nil {
+		if data, ok := opt.Option(dns.OptionClientSubnet); ok {
+			ecs, err := dns.ParseClientSubnet(data)
+			if err != nil {
+				return errorResponse(request, dns.RCodeFormErr)
+			}
+			s.logf("  Client subnet: %s\n", ecs)
+
+			if !s.ForwardClientSubnet {
+				opt.RemoveOption(dns.OptionClientSubnet)
+				request.SetOPT(opt)
+			}
+		}
+	}
+
+	response := s.answer(ctx, request)
+	if s.ShuffleAnswers {
+		s.shuffleAnswers(response)
+	}
+
+	// EDNS queries get an OPT record back
+	if opt != nil {
+		s.addOPT(ctx, response, opt, clientCookie)
+		if wantsNSID && s.NSID != "" {
+			s.addNSID(response)
+		}
+	}
+
+	return response
+}
+
+// answer produces the answer for a request from the health check, a
+// registered handler, the resolver or the zone
+func (s *DNSServer) answer(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+	// Health checks never leave the server
+	if s.isHealthCheck(request) {
+		return healthResponse(request)
+	}
+
+	// Dynamic updates only ever apply to our own zone
+	if request.Header.Opcode() == dns.OpcodeUpdate {
+		if !s.AllowUpdates || s.zone == nil {
+			return errorResponse(request, dns.RCodeRefused)
+		}
+		return s.UpdateHandler(s.zone)(request)
+	}
+
+	// Many-question packets are an abuse vector, so they can be capped
+	if s.MaxQuestions > 0 && len(request.Questions) > s.MaxQuestions {
+		return errorResponse(request, dns.RCodeRefused)
+	}
+
+	// A question-less query is only meaningful as an EDNS probe (e.g.
+	// for cookies or keepalive), which gets a bare NOERROR carrying our OPT
+	if len(request.Questions) == 0 {
+		if opt, _ := request.OPT(); opt == nil {
+			return errorResponse(request, dns.RCodeFormErr)
+		}
+		return errorResponse(request, dns.RCodeNoError)
+	}
+
+	// Zone transfers are streamed over TCP by serveTCPConn; a single
+	// datagram can't carry one
+	if request.Questions[0].QType == dns.TypeAXFR {
+		return errorResponse(request, dns.RCodeRefused)
+	}
+
+	// Injected failures take precedence over every way of answering
+	if s.isFailName(request) {
+		return errorResponse(request, dns.RCodeServFail)
+	}
+
+	// In authoritative-only mode, names outside our zones are refused
+	if s.AuthoritativeOnly && !s.ownsName(request.Questions[0].QName) {
+		return errorResponse(request, dns.RCodeRefused)
+	}
+
+	// Dispatch to a registered handler for the (first) question's name
+	if s.mux != nil && len(request.Questions) > 0 {
+		if handler, ok := s.mux.Match(request.Questions[0].QName); ok {
+			if response := handler(request); response != nil {
+				return response
+			}
+		}
+	}
+
+	// Special-use names never leak upstream
+	if s.LocalZones {
+		if response := s.localZoneResponse(request); response != nil {
+			return response
+		}
+	}
+
+	// If resolver is set (and no zone to answer from), or the name is in
+	// a forward zone, forward the query - unless the client asked us not
+	// to recurse (RD=0), which we can't answer with a referral, so
+	// refuse it instead
+	if s.forwards(request) {
+		if !request.Header.RecursionDesired() {
+			return errorResponse(request, dns.RCodeRefused)
+		}
+		return s.forwardQuery(ctx, request)
+	}
+
+	// Build response from the zone (or dummy answers without one)
+	response := request.BuildResponse(s.zone, s.DefaultAnswerIP)
+	if s.zone != nil {
+		setAnswerSource(ctx, sourceZone)
+	}
+
+	// Names the zone doesn't have can be looked up through the system
+	if s.SystemFallback && s.zone != nil && response.Header.RCode() == dns.RCodeNXDomain {
+		if fallback, ok := s.systemResponse(ctx, request); ok {
+			setAnswerSource(ctx, sourceSystem)
+			return fallback
+		}
+	}
+	return &response
+}
+
+// forwards reports whether a request goes to a resolver rather than
+// being answered locally
+func (s *DNSServer) forwards(request *dns.DNSMessage) bool {
+	if s.AuthoritativeOnly {
+		return false
+	}
+	if _, ok := s.forwardZoneResolver(request.Questions[0].QName); ok {
+		return true
+	}
+	return s.zone == nil && s.resolver != nil
+}
+
+// ownsName reports whether name is at or below one of OwnedZones
+func (s *DNSServer) ownsName(name []byte) bool {
+	for _, zone := range s.OwnedZones {
+		apex, err := dns.EncodeName(zone)
+		if err == nil && dns.IsSubdomain(name, apex) {
+			return true
+		}
+	}
+	return false
+}
+
+// addOPT attaches our OPT record to the response of an EDNS query,
+// echoing the DO bit and the client cookie (if any) followed by our
+// server cookie
+func (s *DNSServer) addOPT(ctx context.Context, response *dns.DNSMessage, requestOPT *dns.OPT, clientCookie []byte) {
+	opt, err := response.OPT()
+	if err != nil || opt == nil {
+		opt = &dns.OPT{UDPSize: uint16(s.packetSize())}
+	}
+
+	// RFC 3225: the DO bit is copied from the query
+	if requestOPT.DO() {
+		opt.Flags |= dns.FlagDO
+	}
+
+	// A forwarded response may carry the upstream's cookie, padding and
+	// NSID, which aren't ours to pass on
+	opt.RemoveOption(dns.OptionCookie)
+	opt.RemoveOption(dns.OptionPadding)
+	opt.RemoveOption(dns.OptionNSID)
+	if clientCookie != nil {
+		cookie := append([]byte{}, clientCookie...)
+		cookie = append(cookie, s.serverCookie(clientCookie, clientAddr(ctx))...)
+		opt.SetOption(dns.OptionCookie, cookie)
+	}
+
+	response.SetOPT(opt)
+}
+
+// addNSID adds our server identifier to the response's OPT record, for
+// a query that asked for it (RFC 5001)
+func (s *DNSServer) addNSID(response *dns.DNSMessage) {
+	opt, err := response.OPT()
+	if err != nil || opt == nil {
+		return
+	}
+	opt.SetOption(dns.OptionNSID, []byte(s.NSID))
+	response.SetOPT(opt)
+}
+
+// wantsPadding reports whether the request carries an EDNS PADDING
+// option asking for a padded response
+func wantsPadding(request *dns.DNSMessage) bool {
+	opt, err := request.OPT()
+	if err != nil || opt == nil {
+		return false
+	}
+	_, ok := opt.Option(dns.OptionPadding)
+	return ok
+}
+
+// pad pads the response to the configured block size, unless that
+// would take it over limit bytes
+func (s *DNSServer) pad(response *dns.DNSMessage, limit int) {
+	blockSize := s.PaddingBlockSize
+	if blockSize <= 0 {
+		blockSize = defaultPaddingBlockSize
+	}
+
+	// Pad replaces the additional section, so the old one can be restored
+	unpadded := response.Additionals
+	response.Pad(blockSize)
+	if len(response.Encode()) > limit {
+		response.Additionals = unpadded
+	}
+}
+
+// setup prepares the state queries are handled with: the read buffers
+// for inbound UDP queries and, when CacheSize asks for one, the
+// MemoryCache. NewDNSServer calls it, so queries handled before Run
+// (e.g. by the self-test) take the same path, and Run calls it again
+// for settings changed in between.
+func (s *DNSServer) setup() error {
+	if err := s.checkSizes(); err != nil {
+		return err
+	}
+
+	bufSize := s.packetSize()
+	s.packets.New = func() any {
+		buf := make([]byte, bufSize)
+		return &buf
+	}
+
+	if s.Cache == nil && s.CacheSize > 0 {
+		s.Cache = NewMemoryCache(s.CacheSize)
+	}
+	return nil
 }
 
 // Run starts the DNS server
 func (s *DNSServer) Run() error {
 	defer s.conn.Close()
 
-	buf := make([]byte, 512)
+	if err := s.setup(); err != nil {
+		return err
+	}
 
+	if s.MetricsAddr != "" {
+		metricsServer, err := s.serveMetrics(s.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		defer metricsServer.Close()
+	}
+
+	if s.APIAddr != "" {
+		apiServer, err := s.serveAPI(s.APIAddr)
+		if err != nil {
+			return err
+		}
+		defer apiServer.Close()
+	}
+
+	var rec *recorder
+	if s.RecordFile != "" {
+		var err error
+		if rec, err = openRecorder(s.RecordFile); err != nil {
+			return err
+		}
+		defer rec.Close()
+	}
+
+	// Each query is handled on its own goroutine, holding a slot in
+	// inFlight so a flood can't spawn unbounded handlers. With every
+	// slot taken, a query may wait briefly for one on a goroutine
+	// holding a slot in waiting, so the read loop itself never blocks.
+	inFlight := make(chan struct{}, s.maxInFlight())
+	waiting := make(chan struct{}, s.maxInFlight())
+
+	if s.ListenTCP {
+		listener, err := net.Listen("tcp", s.conn.LocalAddr().String())
+		if err != nil {
+			return fmt.Errorf("failed to listen for TCP: %v", err)
+		}
+		defer listener.Close()
+		go s.serveTCP(listener, inFlight, rec)
+	}
 	for {
-		size, source, err := s.conn.ReadFromUDP(buf)
+		// Each packet gets a pooled buffer of its own, handed back
+		// only once its response is sent, so a handler never sees
+		// its query overwritten by the next read
+		buf := s.packets.Get().(*[]byte)
+		size, source, err := s.conn.ReadFromUDP(*buf)
 		if err != nil {
-			fmt.Printf("Error receiving data: %v\n", err)
+			s.logf("Error receiving data: %v\n", err)
 			break
 		}
 
-		fmt.Printf("Received %d bytes from %s\n", size, source)
+		s.logf("Received %d bytes from %s\n", size, source)
+		packet := (*buf)[:size]
 
-		// Handle the query
-		response, err := s.HandleQuery(buf[:size])
-		if err != nil {
-			fmt.Printf("Error handling query: %v\n", err)
+		handle := func() {
+			defer func() {
+				s.packets.Put(buf)
+				<-inFlight
+			}()
+			s.serve(packet, source, rec)
+		}
+		refuse := func() {
+			s.logf("Too many queries in flight, refusing query from %s\n", source)
+			s.refuse(packet, source)
+			s.packets.Put(buf)
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+			go handle()
 			continue
+		default:
+		}
+		select {
+		case waiting <- struct{}{}:
+			go func() {
+				defer func() { <-waiting }()
+				if acquire(inFlight, inFlightWait) {
+					handle()
+				} else {
+					refuse()
+				}
+			}()
+		default:
+			refuse()
 		}
+	}
 
-		// Send response
-		_, err = s.conn.WriteToUDP(response, source)
-		if err != nil {
-			fmt.Printf("Failed to send response: %v\n", err)
+	return nil
+}
+
+// responseBuffers holds buffers for encoding UDP responses into, each
+// reused once its response has been sent
+var responseBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, defaultPacketSize)
+		return &buf
+	},
+}
+
+// serve handles one query within the configured timeout and sends the
+// response back to source
+func (s *DNSServer) serve(packet []byte, source *net.UDPAddr, rec *recorder) {
+	ctx, cancel := context.WithTimeout(withClientAddr(context.Background(), source), s.timeout())
+	defer cancel()
+
+	buf := responseBuffers.Get().(*[]byte)
+	defer responseBuffers.Put(buf)
+
+	response, err := s.handleQuery(ctx, packet, *buf)
+	if err != nil {
+		s.logf("Error handling query: %v\n", err)
+		return
+	}
+	*buf = response // keep any capacity the encoding grew
+
+	if rec != nil {
+		if err := rec.Record(source, packet, response); err != nil {
+			s.logf("Failed to record exchange: %v\n", err)
 		}
 	}
 
+	s.writeUDP(response, source)
+}
+
+// writeUDP sends a response to source, giving up once the write
+// timeout passes so a congested socket can't stall the handler. The
+// deadline is the shared socket's, not the write's, so writes are
+// serialized to keep one handler's deadline from cutting off another's.
+func (s *DNSServer) writeUDP(response []byte, source *net.UDPAddr) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout()))
+	if _, err := s.conn.WriteToUDP(response, source); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			s.logf("Timed out sending response to %s, dropping it\n", source)
+			return
+		}
+		s.logf("Failed to send response: %v\n", err)
+	}
+}
+
+// refuse answers a query with REFUSED without handling it
+func (s *DNSServer) refuse(packet []byte, source *net.UDPAddr) {
+	response := refusedResponse(packet)
+	if response == nil {
+		return
+	}
+	s.writeUDP(response, source)
+}
+
+// refusedResponse builds an encoded REFUSED response to a query without
+// handling it; packets too malformed to echo (or that are responses
+// themselves) give nil
+func refusedResponse(packet []byte) []byte {
+	var request dns.DNSMessage
+	if err := request.Parse(packet); err != nil || request.Header.IsResponse() {
+		return nil
+	}
+	return errorResponse(&request, dns.RCodeRefused).Encode()
+}
+
+// acquire takes a slot in sem, waiting at most wait for one to free up
+func acquire(sem chan struct{}, wait time.Duration) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// timeout returns the per-query timeout, falling back to the default
+func (s *DNSServer) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return defaultTimeout
+}
+
+// writeTimeout returns the response write timeout, falling back to the
+// default
+func (s *DNSServer) writeTimeout() time.Duration {
+	if s.WriteTimeout > 0 {
+		return s.WriteTimeout
+	}
+	return defaultWriteTimeout
+}
+
+// maxInFlight returns the concurrent query limit, falling back to the default
+func (s *DNSServer) maxInFlight() int {
+	if s.MaxInFlight > 0 {
+		return s.MaxInFlight
+	}
+	return defaultMaxInFlight
+}
+
+// upstreamUDPSize returns the advertised upstream buffer size, or the
+// default when unset
+func (s *DNSServer) upstreamUDPSize() uint16 {
+	if s.UpstreamUDPSize == 0 {
+		return defaultUpstreamUDPSize
+	}
+	return uint16(s.UpstreamUDPSize)
+}
+
+// udpResponseSize returns the largest UDP response to send a client:
+// its EDNS payload size, capped at our own MaxUDPResponseSize, or 512
+// bytes without EDNS
+func (s *DNSServer) udpResponseSize(request *dns.DNSMessage) int {
+	opt, err := request.OPT()
+	if err != nil || opt == nil {
+		return defaultPacketSize
+	}
+	return min(max(int(opt.UDPSize), defaultPacketSize), s.maxUDPResponseSize())
+}
+
+// maxUDPResponseSize returns the UDP response size limit, or the
+// default when unset
+func (s *DNSServer) maxUDPResponseSize() int {
+	if s.MaxUDPResponseSize == 0 {
+		return defaultMaxUDPResponseSize
+	}
+	return s.MaxUDPResponseSize
+}
+
+// packetSize returns the inbound read buffer size, or the default when
+// unset
+func (s *DNSServer) packetSize() int {
+	if s.MaxPacketSize == 0 {
+		return defaultPacketSize
+	}
+	return s.MaxPacketSize
+}
+
+// checkSizes rejects packet size settings outside [512, 65535], rather
+// than quietly replacing them with their defaults; zero selects the
+// default
+func (s *DNSServer) checkSizes() error {
+	sizes := []struct {
+		setting string
+		n       int
+	}{
+		{"max packet size", s.MaxPacketSize},
+		{"max UDP response size", s.MaxUDPResponseSize},
+		{"upstream UDP size", s.UpstreamUDPSize},
+	}
+	for _, size := range sizes {
+		if size.n != 0 && (size.n < defaultPacketSize || size.n > maxPacketSize) {
+			return fmt.Errorf("%s %d out of range [%d, %d]", size.setting, size.n, defaultPacketSize, maxPacketSize)
+		}
+	}
 	return nil
 }
 
 // forwardQuery forwards a DNS query to the resolver and returns the response
-func (s *DNSServer) forwardQuery(request *dns.DNSMessage) ([]byte, error) {
+func (s *DNSServer) forwardQuery(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
 	// If multiple questions, split them and merge responses
 	if len(request.Questions) > 1 {
-		return s.forwardMultipleQuestions(request)
+		return s.forwardMultipleQuestions(ctx, request)
 	}
 
-	// Single question - forward directly
-	return s.forwardSingleQuery(request)
+	// Single question - forward directly, answering SERVFAIL if the
+	// upstream fails so the client isn't left waiting
+	response, err := s.forwardSingleQuery(ctx, request)
+	if err != nil {
+		s.logf("Error forwarding query: %v\n", err)
+		return errorResponse(request, dns.RCodeServFail)
+	}
+	s.chaseCNAME(ctx, request, response)
+
+	if s.MinimalResponses {
+		minimize(response)
+	}
+
+	return response
+}
+
+// minimize drops the authority and additional records a client doesn't
+// need, like BIND's minimal-responses. Negative answers keep their SOA
+// so clients can cache them, and the OPT record is kept.
+func minimize(response *dns.DNSMessage) {
+	if len(response.Answers) > 0 {
+		response.Authorities = nil
+	}
+	opt, _ := response.OPT()
+	response.Additionals = nil
+	response.SetOPT(opt)
+	response.SyncCounts()
+}
+
+// errorResponse builds an answerless response carrying rcode
+func errorResponse(request *dns.DNSMessage, rcode uint16) *dns.DNSMessage {
+	response := dns.DNSMessage{
+		Header:    request.Header.BuildResponse(),
+		Questions: request.Questions,
+	}
+	response.SyncCounts()
+	response.Header.SetRCode(rcode)
+
+	return &response
 }
 
 // forwardSingleQuery forwards a single query to the resolver
-func (s *DNSServer) forwardSingleQuery(request *dns.DNSMessage) ([]byte, error) {
-	// Connect to resolver
-	resolverAddr, err := net.ResolveUDPAddr("udp", s.resolver)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve resolver address: %v", err)
+func (s *DNSServer) forwardSingleQuery(ctx context.Context, request *dns.DNSMessage) (*dns.DNSMessage, error) {
+	if s.Cache != nil && len(request.Questions) > 0 {
+		if response, ok := s.cachedResponse(request); ok {
+			setAnswerSource(ctx, sourceCache)
+			return response, nil
+		}
 	}
 
-	conn, err := net.DialUDP("udp", nil, resolverAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to resolver: %v", err)
+	query := request
+	if s.RandomizeCase && len(request.Questions) > 0 {
+		// Send a copy so the client's question keeps its casing
+		query = request.Clone()
+		query.Questions[0].QName = randomizeCase(request.Questions[0].QName)
+	}
+	clientOPT, _ := request.OPT()
+	if s.StripEDNS {
+		// Plain DNS for legacy upstreams; respond gives EDNS clients
+		// our own OPT record back
+		plain := *query
+		plain.SetOPT(nil)
+		query = &plain
+	} else {
+		// Advertise our own buffer size so upstreams can answer large
+		// responses over UDP
+		opt := &dns.OPT{}
+		if clientOPT != nil {
+			opt = clientOPT
+		}
+		opt.UDPSize = s.upstreamUDPSize()
+		if s.DropUnknownOptions {
+			opt.RemoveUnknownOptions()
+		}
+		advertised := *query
+		advertised.SetOPT(opt)
+		query = &advertised
 	}
-	defer conn.Close()
 
-	// Send query to resolver
-	queryBytes := request.Encode()
-	_, err = conn.Write(queryBytes)
+	resolver := s.upstream(query.Questions[0].QName)
+	if resolver == nil {
+		return nil, fmt.Errorf("no resolver for %s", query.Questions[0].Name())
+	}
+	setAnswerSource(ctx, sourceForward)
+	response, err := resolver.Query(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send query to resolver: %v", err)
+		s.metrics.UpstreamError()
+		if s.Cache != nil && s.ServeStale > 0 {
+			if stale, ok := s.staleResponse(request); ok {
+				s.logf("Upstream failed (%v), serving stale answer\n", err)
+				setAnswerSource(ctx, sourceStale)
+				return stale, nil
+			}
+		}
+		return nil, err
 	}
 
-	// Read response from resolver
-	buf := make([]byte, 512)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response from resolver: %v", err)
+	// Reject responses that don't answer what we asked
+	if err := validateResponse(query, response, s.RandomizeCase); err != nil {
+		return nil, fmt.Errorf("invalid response from resolver: %v", err)
+	}
+
+	if s.RandomizeCase {
+		restoreCase(response, query.Questions[0].QName, request.Questions[0].QName)
+	}
+
+	// Forwarded answers are never authoritative, and an upstream's
+	// reserved Z bit mustn't reach the client
+	response.Header.Flags &^= dns.FlagAA | dns.FlagZ
+
+	// A client that didn't speak EDNS mustn't get the upstream's OPT
+	if clientOPT == nil {
+		response.SetOPT(nil)
 	}
 
-	return buf[:n], nil
+	s.clampTTLs(response)
+
+	if s.Cache != nil {
+		s.cacheResponse(request, response)
+	}
+
+	if s.Debug {
+		for _, answer := range response.Answers {
+			if ip, ok := answer.IP(); ok {
+				s.logf("Resolved %s to %s\n", request.Questions[0].Name(), ip)
+			}
+		}
+	}
+
+	return response, nil
+}
+
+// clampTTLs bounds the TTL of every record (except OPT, whose TTL
+// field holds flags) to [MinTTL, MaxTTL]. A zero TTL means the record
+// must not be cached at all, so MinTTL doesn't raise it.
+func (s *DNSServer) clampTTLs(msg *dns.DNSMessage) {
+	if s.MinTTL == 0 && s.MaxTTL == 0 {
+		return
+	}
+
+	minTTL := uint32(s.MinTTL / time.Second)
+	maxTTL := uint32(s.MaxTTL / time.Second)
+	for _, section := range [][]dns.DNSAnswer{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range section {
+			if section[i].Type == dns.TypeOPT {
+				continue
+			}
+			if section[i].TTL > 0 && section[i].TTL < minTTL {
+				section[i].TTL = minTTL
+			}
+			if s.MaxTTL > 0 && section[i].TTL > maxTTL {
+				section[i].TTL = maxTTL
+			}
+		}
+	}
+}
+
+// validateResponse checks that a resolver response belongs to the query:
+// the IDs must match and the first question must be the one that was sent
+// (compared byte for byte when exactCase is set, for 0x20 encoding)
+func validateResponse(query, response *dns.DNSMessage, exactCase bool) error {
+	if response.Header.ID != query.Header.ID {
+		return fmt.Errorf("ID %d does not match query ID %d", response.Header.ID, query.Header.ID)
+	}
+
+	if len(query.Questions) == 0 {
+		return nil
+	}
+	if len(response.Questions) == 0 {
+		return fmt.Errorf("missing question section")
+	}
+
+	sent, got := query.Questions[0], response.Questions[0]
+	if !bytes.Equal(dns.CanonicalName(sent.QName), dns.CanonicalName(got.QName)) || sent.QType != got.QType || sent.QClass != got.QClass {
+		return fmt.Errorf("question does not match query")
+	}
+	if exactCase && !bytes.Equal(sent.QName, got.QName) {
+		return fmt.Errorf("question casing does not match query")
+	}
+
+	return nil
 }
 
 // forwardMultipleQuestions splits multiple questions into separate queries and merges responses
-func (s *DNSServer) forwardMultipleQuestions(request *dns.DNSMessage) ([]byte, error) {
-	originalID := request.Header.ID
-	var allAnswers []dns.DNSAnswer
+func (s *DNSServer) forwardMultipleQuestions(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+	// Merged response starts with the client's questions and no answers
+	mergedResponse := dns.DNSMessage{
+		Header:    request.Header.BuildResponse(),
+		Questions: request.Questions,
+	}
+	mergedResponse.SyncCounts()
+	failures := 0
+	var mergedOPT *dns.OPT
+
+	// RA and AD hold for the merged response only if they held for
+	// every upstream answer
+	upstreamFlags := dns.FlagRA | dns.FlagAD
 
 	// Process each question separately
 	for _, question := range request.Questions {
-		// Create a new message with single question
+		// Create a new message with single question, carrying the client's
+		// additional section (EDNS OPT) along with it
 		singleQuery := dns.DNSMessage{
 			Header: dns.DNSHeader{
-				ID:      request.Header.ID,
-				Flags:   request.Header.Flags,
-				QDCount: 1,
-				ANCount: 0,
-				NSCount: 0,
-				ARCount: 0,
+				ID:    request.Header.ID,
+				Flags: request.Header.Flags,
 			},
-			Questions: []dns.Question{question},
+			Additionals: request.Additionals,
 		}
+		singleQuery.SyncCounts()
+		singleQuery.AddQuestion(question)
 
 		// Forward the single query
-		responseBytes, err := s.forwardSingleQuery(&singleQuery)
+		response, err := s.forwardSingleQuery(ctx, &singleQuery)
 		if err != nil {
-			fmt.Printf("Error forwarding question: %v\n", err)
+			s.logf("Error forwarding question: %v\n", err)
+			failures++
 			continue
 		}
+		s.chaseCNAME(ctx, &singleQuery, response)
 
-		// Parse the response
-		var response dns.DNSMessage
-		if err := response.ParseComplete(responseBytes); err != nil {
-			fmt.Printf("Error parsing response: %v\n", err)
-			continue
-		}
+		upstreamFlags &= response.Header.Flags
 
 		// Collect answers
-		allAnswers = append(allAnswers, response.Answers...)
+		for _, a := range response.Answers {
+			mergedResponse.AddAnswer(a)
+		}
+
+		// Keep a single OPT for the merged response
+		if mergedOPT == nil {
+			mergedOPT, _ = response.OPT()
+		}
 	}
 
-	// Build merged response
-	mergedResponse := dns.DNSMessage{
-		Header: dns.DNSHeader{
-			ID:      originalID,
-			Flags:   request.Header.BuildResponse().Flags,
-			QDCount: uint16(len(request.Questions)),
-			ANCount: uint16(len(allAnswers)),
-			NSCount: 0,
-			ARCount: 0,
-		},
-		Questions: request.Questions,
-		Answers:   allAnswers,
+	// Nothing could be resolved upstream
+	if failures == len(request.Questions) {
+		return errorResponse(request, dns.RCodeServFail)
+	}
+
+	if mergedOPT != nil {
+		mergedResponse.SetOPT(mergedOPT)
+	}
+	if failures == 0 {
+		mergedResponse.Header.Flags |= upstreamFlags
 	}
 
-	return mergedResponse.Encode(), nil
+	return &mergedResponse
 }
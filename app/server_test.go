@@ -0,0 +1,1286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// resolverFunc adapts a function to the Resolver interface
+type resolverFunc func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error)
+
+// Query calls f
+func (f resolverFunc) Query(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+	return f(ctx, query)
+}
+
+// newTestServer returns a server bound to an ephemeral loopback port
+// that discards its log output
+func newTestServer(t testing.TB, opts ...Option) *DNSServer {
+	t.Helper()
+	server, err := NewDNSServer("127.0.0.1:0", append([]Option{WithLogger(io.Discard)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewDNSServer: %v", err)
+	}
+	t.Cleanup(func() { server.conn.Close() })
+	return server
+}
+
+// newMultiQuestionServer is newTestServer with MaxQuestions lifted, so
+// multi-question queries are split and forwarded rather than refused
+func newMultiQuestionServer(t testing.TB, opts ...Option) *DNSServer {
+	t.Helper()
+	server := newTestServer(t, opts...)
+	server.MaxQuestions = 0
+	return server
+}
+
+// startServer runs the server's serve loop until the test ends
+func startServer(t *testing.T, server *DNSServer) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- server.Run() }()
+	t.Cleanup(func() {
+		server.conn.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+}
+
+// dialServer opens a UDP client socket to the server
+func dialServer(t *testing.T, server *DNSServer) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readResponse reads and parses one response from a client socket
+func readResponse(t *testing.T, conn net.Conn) *dns.DNSMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxPacketSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	var response dns.DNSMessage
+	if err := response.ParseComplete(buf[:n]); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return &response
+}
+
+// testZone parses a zone file's text, failing the test on error
+func testZone(t *testing.T, text string) *dns.Zone {
+	t.Helper()
+	zone, err := dns.ParseZone(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("ParseZone: %v", err)
+	}
+	return zone
+}
+
+// newQuery builds a recursive IN query for name
+func newQuery(t *testing.T, name string, qtype uint16) *dns.DNSMessage {
+	t.Helper()
+	query, err := dns.NewQuery(name, qtype, true)
+	if err != nil {
+		t.Fatalf("NewQuery(%q): %v", name, err)
+	}
+	return query
+}
+
+// exchange runs query through HandleQuery and parses the response
+func exchange(t *testing.T, server *DNSServer, query *dns.DNSMessage) *dns.DNSMessage {
+	t.Helper()
+	data, err := server.HandleQuery(context.Background(), query.Encode())
+	if err != nil {
+		t.Fatalf("HandleQuery: %v", err)
+	}
+	var response dns.DNSMessage
+	if err := response.ParseComplete(data); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return &response
+}
+
+// aRecord returns an A record for name
+func aRecord(t *testing.T, name, ip string, ttl uint32) dns.DNSAnswer {
+	t.Helper()
+	wire, err := dns.EncodeName(name)
+	if err != nil {
+		t.Fatalf("EncodeName(%q): %v", name, err)
+	}
+	rdata := net.ParseIP(ip).To4()
+	return dns.DNSAnswer{Name: wire, Type: dns.TypeA, Class: dns.ClassIN, TTL: ttl, RDLength: 4, RData: rdata}
+}
+
+// reply builds an upstream's response to query carrying answers
+func reply(query *dns.DNSMessage, answers ...dns.DNSAnswer) *dns.DNSMessage {
+	response := &dns.DNSMessage{Header: query.Header.BuildResponse(), Questions: query.Questions}
+	response.Header.Flags |= dns.FlagRA
+	response.SyncCounts()
+	for _, a := range answers {
+		response.AddAnswer(a)
+	}
+	return response
+}
+
+// answerA returns a resolver answering every query with an A record
+// for ip at the queried name
+func answerA(t *testing.T, ip string) resolverFunc {
+	return func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		return reply(query, aRecord(t, query.Questions[0].Name(), ip, 300)), nil
+	}
+}
+
+// failingResolver fails every query
+var failingResolver = resolverFunc(func(context.Context, *dns.DNSMessage) (*dns.DNSMessage, error) {
+	return nil, errors.New("upstream unreachable")
+})
+
+// answerIPs returns the addresses in a response's answer section
+func answerIPs(response *dns.DNSMessage) []string {
+	var ips []string
+	for _, a := range response.Answers {
+		if ip, ok := a.IP(); ok {
+			ips = append(ips, ip.String())
+		}
+	}
+	return ips
+}
+
+func TestHandleQueryForwardsToResolver(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = answerA(t, "192.0.2.1")
+
+	query := newQuery(t, "example.com", dns.TypeA)
+	response := exchange(t, server, query)
+
+	if response.Header.ID != query.Header.ID {
+		t.Errorf("response ID = %d, want %d", response.Header.ID, query.Header.ID)
+	}
+	if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+		t.Fatalf("RCODE = %d, want NOERROR", rcode)
+	}
+	if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.1" {
+		t.Errorf("answers = %v, want [192.0.2.1]", ips)
+	}
+}
+
+func TestForwardRejectsAnswerForAnotherName(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		other := newQuery(t, "attacker.example", dns.TypeA)
+		other.Header.ID = query.Header.ID
+		return reply(other, aRecord(t, "attacker.example", "203.0.113.66", 300)), nil
+	})
+
+	response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+	if rcode := response.Header.RCode(); rcode != dns.RCodeServFail {
+		t.Errorf("RCODE = %d, want SERVFAIL", rcode)
+	}
+	if len(response.Answers) != 0 {
+		t.Errorf("got %d answers from a mismatched response", len(response.Answers))
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	query := newQuery(t, "example.com", dns.TypeA)
+
+	tests := []struct {
+		name      string
+		edit      func(response *dns.DNSMessage)
+		exactCase bool
+		wantErr   bool
+	}{
+		{"matching", func(*dns.DNSMessage) {}, false, false},
+		{"other ID", func(r *dns.DNSMessage) { r.Header.ID++ }, false, true},
+		{"no question", func(r *dns.DNSMessage) { r.Questions = nil }, false, true},
+		{"other type", func(r *dns.DNSMessage) { r.Questions[0].QType = dns.TypeAAAA }, false, true},
+		{"other case", func(r *dns.DNSMessage) { r.Questions[0].QName = []byte("\x07EXAMPLE\x03com\x00") }, false, false},
+		{"other case, exact", func(r *dns.DNSMessage) { r.Questions[0].QName = []byte("\x07EXAMPLE\x03com\x00") }, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := reply(query.Clone())
+			tt.edit(response)
+			err := validateResponse(query, response, tt.exactCase)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateResponse() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Only ASCII letters fold, so other bytes must match exactly
+	query.Questions[0].QName = []byte("\x03\xff\xfe\xfd\x00")
+	response := reply(query.Clone())
+	response.Questions[0].QName = []byte("\x03\x80\x81\x82\x00")
+	if err := validateResponse(query, response, false); err == nil {
+		t.Error("validateResponse() accepted a different non-ASCII name")
+	}
+}
+
+func TestDebugRejectsInvalidResponse(t *testing.T) {
+	server := newTestServer(t)
+	server.Debug = true
+	server.Handle("broken.test", func(request *dns.DNSMessage) *dns.DNSMessage {
+		response := errorResponse(request, dns.RCodeNoError)
+		response.Header.ANCount = 1 // but no answer record
+		return response
+	})
+
+	query := newQuery(t, "www.broken.test", dns.TypeA)
+	if _, err := server.HandleQuery(context.Background(), query.Encode()); err == nil {
+		t.Error("HandleQuery sent a response whose answer count doesn't match its answers")
+	}
+}
+
+func TestAuthoritativeBit(t *testing.T) {
+	zoneServer := newTestServer(t)
+	zoneServer.zone = testZone(t, "www.example.com A 192.0.2.1\n")
+	response := exchange(t, zoneServer, newQuery(t, "www.example.com", dns.TypeA))
+	if response.Header.Flags&dns.FlagAA == 0 {
+		t.Error("zone answer is not authoritative")
+	}
+
+	forwarder := newTestServer(t)
+	forwarder.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		response := reply(query, aRecord(t, "www.example.com", "192.0.2.1", 300))
+		response.Header.Flags |= dns.FlagAA // the upstream is authoritative, we aren't
+		return response, nil
+	})
+	response = exchange(t, forwarder, newQuery(t, "www.example.com", dns.TypeA))
+	if response.Header.Flags&dns.FlagAA != 0 {
+		t.Error("forwarded answer is marked authoritative")
+	}
+}
+
+func TestLargeQueryIsReadWhole(t *testing.T) {
+	server := newTestServer(t)
+	server.MaxPacketSize = 4096
+	startServer(t, server)
+
+	// An unknown EDNS option pushes the query well past 512 bytes
+	query := newQuery(t, "example.com", dns.TypeA)
+	opt := &dns.OPT{UDPSize: 4096}
+	opt.SetOption(65001, make([]byte, 1500))
+	query.SetOPT(opt)
+	packet := query.Encode()
+	if len(packet) <= 1500 {
+		t.Fatalf("query is only %d bytes", len(packet))
+	}
+
+	conn := dialServer(t, server)
+	conn.Write(packet)
+	response := readResponse(t, conn)
+	if response.Header.ID != query.Header.ID || response.Header.RCode() != dns.RCodeNoError {
+		t.Errorf("response ID %d RCODE %d, want ID %d NOERROR", response.Header.ID, response.Header.RCode(), query.Header.ID)
+	}
+}
+
+func TestServFailWhenUpstreamFails(t *testing.T) {
+	server := newMultiQuestionServer(t)
+	server.resolver = failingResolver
+
+	single := newQuery(t, "example.com", dns.TypeA)
+	multi := newQuery(t, "example.com", dns.TypeA)
+	multi.AddQuestion(dns.Question{QName: []byte("\x07example\x03org\x00"), QType: dns.TypeA, QClass: dns.ClassIN})
+
+	for _, query := range []*dns.DNSMessage{single, multi} {
+		response := exchange(t, server, query)
+		if rcode := response.Header.RCode(); rcode != dns.RCodeServFail {
+			t.Errorf("%d-question query got RCODE %d, want SERVFAIL", len(query.Questions), rcode)
+		}
+		if response.Header.ID != query.Header.ID {
+			t.Errorf("response ID = %d, want %d", response.Header.ID, query.Header.ID)
+		}
+	}
+}
+
+func TestDefaultAnswerIP(t *testing.T) {
+	server := newTestServer(t, WithDefaultAnswerIP(net.ParseIP("192.0.2.5")))
+
+	response := exchange(t, server, newQuery(t, "anything.example", dns.TypeA))
+	if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.5" {
+		t.Errorf("answers = %v, want [192.0.2.5]", ips)
+	}
+	if rdata := response.Answers[0].RData; !net.IP(rdata).Equal(net.IPv4(192, 0, 2, 5)) || len(rdata) != 4 {
+		t.Errorf("RData = %v, want 4-byte 192.0.2.5", rdata)
+	}
+
+	if _, err := NewDNSServer("127.0.0.1:0", WithDefaultAnswerIP(nil)); err == nil {
+		t.Error("NewDNSServer accepted a nil default answer IP")
+	}
+}
+
+func TestDOBitAndSignaturesPassThrough(t *testing.T) {
+	var forwardedDO bool
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		opt, err := query.OPT()
+		forwardedDO = err == nil && opt != nil && opt.DO()
+
+		a := aRecord(t, "example.com", "192.0.2.1", 300)
+		sig := dns.DNSAnswer{Name: a.Name, Type: dns.TypeRRSIG, Class: dns.ClassIN, TTL: 300, RData: []byte("signature")}
+		sig.RDLength = uint16(len(sig.RData))
+		response := reply(query, a, sig)
+		response.SetOPT(&dns.OPT{UDPSize: 1232, Flags: dns.FlagDO})
+		return response, nil
+	})
+
+	query := newQuery(t, "example.com", dns.TypeA)
+	query.SetOPT(&dns.OPT{UDPSize: 1232, Flags: dns.FlagDO})
+	response := exchange(t, server, query)
+
+	if !forwardedDO {
+		t.Error("the upstream query lost the DO bit")
+	}
+	var signed bool
+	for _, a := range response.Answers {
+		signed = signed || a.Type == dns.TypeRRSIG
+	}
+	if !signed {
+		t.Error("the RRSIG didn't reach the client")
+	}
+	if opt, _ := response.OPT(); opt == nil || !opt.DO() {
+		t.Error("the response lacks an OPT record with DO set")
+	}
+}
+
+func TestForwardedTTLsAreClamped(t *testing.T) {
+	server := newTestServer(t, WithTTLBounds(30*time.Second, time.Hour), WithCache(NewMemoryCache(16)))
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		return reply(query,
+			aRecord(t, "example.com", "192.0.2.1", 5),
+			aRecord(t, "example.com", "192.0.2.2", 100000)), nil
+	})
+
+	for _, source := range []string{"forwarded", "cached"} {
+		response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+		if len(response.Answers) != 2 {
+			t.Fatalf("%s: got %d answers, want 2", source, len(response.Answers))
+		}
+		if ttl := response.Answers[0].TTL; ttl != 30 {
+			t.Errorf("%s: TTL 5 became %d, want 30", source, ttl)
+		}
+		if ttl := response.Answers[1].TTL; ttl < 3599 || ttl > 3600 {
+			t.Errorf("%s: TTL 100000 became %d, want 3600", source, ttl)
+		}
+	}
+
+	if _, err := NewDNSServer("127.0.0.1:0", WithTTLBounds(time.Hour, time.Minute)); err == nil {
+		t.Error("NewDNSServer accepted a minimum TTL above the maximum")
+	}
+}
+
+func TestNonRecursiveQueryIsNotForwarded(t *testing.T) {
+	forwarded := false
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		forwarded = true
+		return reply(query), nil
+	})
+
+	query, err := dns.NewQuery("example.com", dns.TypeA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := exchange(t, server, query)
+	if forwarded {
+		t.Error("an RD=0 query was forwarded")
+	}
+	if rcode := response.Header.RCode(); rcode != dns.RCodeRefused {
+		t.Errorf("RCODE = %d, want REFUSED", rcode)
+	}
+}
+
+func TestMultiQuestionQueryKeepsOPT(t *testing.T) {
+	var upstreamOPTs int
+	server := newMultiQuestionServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		if opt, _ := query.OPT(); opt != nil {
+			upstreamOPTs++
+		}
+		response := reply(query, aRecord(t, query.Questions[0].Name(), "192.0.2.1", 300))
+		response.SetOPT(&dns.OPT{UDPSize: 1232})
+		return response, nil
+	})
+
+	query := newQuery(t, "example.com", dns.TypeA)
+	query.AddQuestion(dns.Question{QName: []byte("\x07example\x03org\x00"), QType: dns.TypeA, QClass: dns.ClassIN})
+	query.SetOPT(&dns.OPT{UDPSize: 1232})
+	response := exchange(t, server, query)
+
+	if upstreamOPTs != 2 {
+		t.Errorf("%d of 2 split queries carried the OPT", upstreamOPTs)
+	}
+	if len(response.Answers) != 2 {
+		t.Errorf("got %d answers, want 2", len(response.Answers))
+	}
+	opts := 0
+	for _, rr := range response.Additionals {
+		if rr.Type == dns.TypeOPT {
+			opts++
+		}
+	}
+	if opts != 1 {
+		t.Errorf("merged response has %d OPT records, want 1", opts)
+	}
+}
+
+// startSaturated starts the server with its n in-flight slots taken by
+// queries for slow.test, held until the test ends
+func startSaturated(t *testing.T, server *DNSServer, n int) {
+	t.Helper()
+	entered, release := make(chan struct{}, n), make(chan struct{})
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+			if request.Questions[0].Name() == "slow.test" {
+				entered <- struct{}{}
+				<-release
+			}
+			return next(ctx, request)
+		}
+	})
+	startServer(t, server)
+	t.Cleanup(func() { close(release) })
+
+	for range n {
+		dialServer(t, server).Write(newQuery(t, "slow.test", dns.TypeA).Encode())
+		<-entered
+	}
+}
+
+func TestQueriesBeyondInFlightLimitAreRefused(t *testing.T) {
+	const limit = 2
+	server := newTestServer(t, WithMaxInFlight(limit))
+	startSaturated(t, server, limit)
+
+	conn := dialServer(t, server)
+	for range 3 {
+		query := newQuery(t, "example.com", dns.TypeA)
+		conn.Write(query.Encode())
+		response := readResponse(t, conn)
+		if response.Header.ID != query.Header.ID || response.Header.RCode() != dns.RCodeRefused {
+			t.Errorf("excess query got ID %d RCODE %d, want ID %d REFUSED", response.Header.ID, response.Header.RCode(), query.Header.ID)
+		}
+	}
+}
+
+func TestFloodDoesNotStallTheReadLoop(t *testing.T) {
+	const limit, flood = 2, 40
+	server := newTestServer(t, WithMaxInFlight(limit))
+	startSaturated(t, server, limit)
+
+	// Waiting out a slot for each query in turn would take two seconds
+	start := time.Now()
+	conn := dialServer(t, server)
+	for range flood {
+		conn.Write(newQuery(t, "example.com", dns.TypeA).Encode())
+	}
+	for range flood {
+		if rcode := readResponse(t, conn).Header.RCode(); rcode != dns.RCodeRefused {
+			t.Fatalf("excess query got RCODE %d, want REFUSED", rcode)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("refusing %d queries took %v", flood, elapsed)
+	}
+}
+
+func TestListenOnEphemeralPort(t *testing.T) {
+	server := newTestServer(t)
+	addr, ok := server.LocalAddr().(*net.UDPAddr)
+	if !ok || addr.Port == 0 || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("LocalAddr() = %v, want 127.0.0.1 with the chosen port", server.LocalAddr())
+	}
+
+	for _, bad := range []string{"not-an-address", "127.0.0.1:99999"} {
+		if _, err := NewDNSServer(bad); err == nil {
+			t.Errorf("NewDNSServer(%q) succeeded", bad)
+		}
+	}
+	if _, err := NewDNSServer(addr.String()); err == nil || !strings.Contains(err.Error(), "failed to bind") {
+		t.Errorf("binding a port in use: err = %v, want a bind failure", err)
+	}
+}
+
+func TestResponsesAreNeverAnswered(t *testing.T) {
+	server := newTestServer(t)
+	startServer(t, server)
+
+	response := reply(newQuery(t, "example.com", dns.TypeA))
+	if data, err := server.HandleQuery(context.Background(), response.Encode()); err == nil {
+		t.Errorf("HandleQuery answered a QR=1 message with %d bytes", len(data))
+	}
+
+	conn := dialServer(t, server)
+	conn.Write(response.Encode())
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, maxPacketSize)); err == nil {
+		t.Errorf("the server sent %d bytes back to a QR=1 packet", n)
+	}
+}
+
+func TestPaddingOnlyOverTCP(t *testing.T) {
+	server := newTestServer(t)
+	server.zone = testZone(t, "www.example.com A 192.0.2.1\n")
+
+	query := newQuery(t, "www.example.com", dns.TypeA)
+	opt := &dns.OPT{UDPSize: 1232}
+	opt.SetOption(dns.OptionPadding, nil)
+	query.SetOPT(opt)
+
+	tests := []struct {
+		name   string
+		client net.Addr
+		padded bool
+	}{
+		{"TCP", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5300}, true},
+		{"UDP", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5300}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := server.handleQuery(withClientAddr(context.Background(), tt.client), query.Encode(), nil)
+			if err != nil {
+				t.Fatalf("handleQuery: %v", err)
+			}
+			var response dns.DNSMessage
+			if err := response.ParseComplete(data); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			opt, _ := response.OPT()
+			if opt == nil {
+				t.Fatal("response has no OPT record")
+			}
+			_, padded := opt.Option(dns.OptionPadding)
+			if padded != tt.padded {
+				t.Errorf("padded = %t, want %t", padded, tt.padded)
+			}
+			if tt.padded && len(data)%defaultPaddingBlockSize != 0 {
+				t.Errorf("padded response is %d bytes, not a multiple of %d", len(data), defaultPaddingBlockSize)
+			}
+		})
+	}
+}
+
+func TestQuestionlessEDNSProbe(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = failingResolver
+
+	probe := &dns.DNSMessage{Header: dns.DNSHeader{ID: 99}}
+	probe.SetOPT(&dns.OPT{UDPSize: 1232})
+	response := exchange(t, server, probe)
+	if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+		t.Errorf("probe RCODE = %d, want NOERROR", rcode)
+	}
+	if response.Header.ID != 99 || len(response.Questions) != 0 || len(response.Answers) != 0 {
+		t.Errorf("probe response ID %d with %d questions and %d answers", response.Header.ID, len(response.Questions), len(response.Answers))
+	}
+	if opt, _ := response.OPT(); opt == nil {
+		t.Error("probe response lacks an OPT record")
+	}
+
+	// Without an OPT there is nothing to probe
+	response = exchange(t, server, &dns.DNSMessage{Header: dns.DNSHeader{ID: 100}})
+	if rcode := response.Header.RCode(); rcode != dns.RCodeFormErr {
+		t.Errorf("empty query RCODE = %d, want FORMERR", rcode)
+	}
+}
+
+func TestStripEDNS(t *testing.T) {
+	var upstreamOPT bool
+	server := newTestServer(t)
+	server.StripEDNS = true
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		opt, _ := query.OPT()
+		upstreamOPT = opt != nil || query.Header.ARCount != 0
+		return reply(query, aRecord(t, "example.com", "192.0.2.1", 300)), nil
+	})
+
+	query := newQuery(t, "example.com", dns.TypeA)
+	query.SetOPT(&dns.OPT{UDPSize: 1232, Flags: dns.FlagDO})
+	response := exchange(t, server, query)
+
+	if upstreamOPT {
+		t.Error("the upstream received an OPT record")
+	}
+	if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.1" {
+		t.Errorf("answers = %v, want [192.0.2.1]", ips)
+	}
+	if opt, err := response.OPT(); err != nil || opt == nil {
+		t.Errorf("EDNS client got no OPT record back (err %v)", err)
+	}
+	if err := response.Validate(); err != nil {
+		t.Errorf("response is invalid: %v", err)
+	}
+}
+
+func TestAuthoritativeOnly(t *testing.T) {
+	forwarded := false
+	server := newTestServer(t)
+	server.zone = testZone(t, "www.example.com A 192.0.2.1\n")
+	server.AuthoritativeOnly = true
+	server.OwnedZones = []string{"example.com"}
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		forwarded = true
+		return reply(query), nil
+	})
+
+	response := exchange(t, server, newQuery(t, "www.example.com", dns.TypeA))
+	if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.1" {
+		t.Errorf("in-zone answers = %v, want [192.0.2.1]", ips)
+	}
+
+	response = exchange(t, server, newQuery(t, "www.example.org", dns.TypeA))
+	if rcode := response.Header.RCode(); rcode != dns.RCodeRefused {
+		t.Errorf("out-of-zone RCODE = %d, want REFUSED", rcode)
+	}
+	if forwarded {
+		t.Error("an out-of-zone query was forwarded")
+	}
+}
+
+func TestForwardedQueryAdvertisesUDPSize(t *testing.T) {
+	tests := []struct {
+		configured int
+		want       uint16
+	}{
+		{0, defaultUpstreamUDPSize},
+		{4096, 4096},
+	}
+	for _, tt := range tests {
+		var advertised uint16
+		server := newTestServer(t)
+		server.UpstreamUDPSize = tt.configured
+		server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+			if opt, _ := query.OPT(); opt != nil {
+				advertised = opt.UDPSize
+			}
+			response := reply(query)
+			response.SetOPT(&dns.OPT{UDPSize: 1232})
+			return response, nil
+		})
+
+		response := exchange(t, server, newQuery(t, "example.com", dns.TypeA))
+		if advertised != tt.want {
+			t.Errorf("UpstreamUDPSize %d: advertised %d, want %d", tt.configured, advertised, tt.want)
+		}
+		if opt, _ := response.OPT(); opt != nil {
+			t.Errorf("UpstreamUDPSize %d: a client without EDNS got an OPT record", tt.configured)
+		}
+	}
+}
+
+func TestCDAndADBits(t *testing.T) {
+	var forwardedCD bool
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		forwardedCD = query.Header.Flags&dns.FlagCD != 0
+		response := reply(query, aRecord(t, "example.com", "192.0.2.1", 300))
+		response.Header.Flags |= dns.FlagAD
+		return response, nil
+	})
+
+	query := newQuery(t, "example.com", dns.TypeA)
+	query.Header.Flags |= dns.FlagCD
+	response := exchange(t, server, query)
+
+	if !forwardedCD {
+		t.Error("CD didn't survive forwarding")
+	}
+	if response.Header.Flags&dns.FlagAD == 0 {
+		t.Error("the upstream's AD bit was dropped")
+	}
+	if response.Header.Flags&dns.FlagCD == 0 {
+		t.Error("the response doesn't echo CD")
+	}
+}
+
+func TestMinimalResponses(t *testing.T) {
+	glue := aRecord(t, "ns.example.com", "192.0.2.53", 300)
+	ns := dns.DNSAnswer{Name: []byte("\x07example\x03com\x00"), Type: dns.TypeNS, Class: dns.ClassIN, TTL: 300,
+		RData: []byte("\x02ns\x07example\x03com\x00")}
+	ns.RDLength = uint16(len(ns.RData))
+	upstream := resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		response := reply(query, aRecord(t, "www.example.com", "192.0.2.1", 300))
+		response.AddAuthority(ns)
+		response.Additionals = append(response.Additionals, glue)
+		response.SetOPT(&dns.OPT{UDPSize: 1232})
+		return response, nil
+	})
+
+	for _, minimal := range []bool{false, true} {
+		server := newTestServer(t)
+		server.MinimalResponses = minimal
+		server.resolver = upstream
+
+		query := newQuery(t, "www.example.com", dns.TypeA)
+		query.SetOPT(&dns.OPT{UDPSize: 1232})
+		response := exchange(t, server, query)
+
+		wantExtra := 1
+		if minimal {
+			wantExtra = 0
+		}
+		if len(response.Answers) != 1 || len(response.Authorities) != wantExtra || len(response.Additionals) != wantExtra+1 {
+			t.Errorf("minimal %t: %d answers, %d authorities, %d additionals; want 1, %d, %d",
+				minimal, len(response.Answers), len(response.Authorities), len(response.Additionals), wantExtra, wantExtra+1)
+		}
+		if opt, _ := response.OPT(); opt == nil {
+			t.Errorf("minimal %t: the OPT record was dropped", minimal)
+		}
+	}
+}
+
+func TestMinimalNegativeResponseKeepsSOA(t *testing.T) {
+	var queries int
+	server := newTestServer(t)
+	server.MinimalResponses = true
+	server.resolver = nxdomainResolver(&queries, soaRecord(300, 300))
+
+	response := exchange(t, server, newQuery(t, "missing.example.com", dns.TypeA))
+	if len(response.Authorities) != 1 || response.Authorities[0].Type != dns.TypeSOA {
+		t.Errorf("authorities = %+v, want the SOA", response.Authorities)
+	}
+}
+
+func TestClientSubnetForwarding(t *testing.T) {
+	ecs, err := (&dns.ClientSubnet{Family: dns.FamilyIPv4, SourcePrefix: 24, Address: net.IPv4(198, 51, 100, 0)}).Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for _, forward := range []bool{false, true} {
+		var forwarded []byte
+		var seen bool
+		server := newTestServer(t)
+		server.ForwardClientSubnet = forward
+		server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+			if opt, _ := query.OPT(); opt != nil {
+				forwarded, seen = opt.Option(dns.OptionClientSubnet)
+			}
+			return reply(query), nil
+		})
+
+		query := newQuery(t, "example.com", dns.TypeA)
+		opt := &dns.OPT{UDPSize: 1232}
+		opt.SetOption(dns.OptionClientSubnet, ecs)
+		query.SetOPT(opt)
+		if rcode := exchange(t, server, query).Header.RCode(); rcode != dns.RCodeNoError {
+			t.Fatalf("ForwardClientSubnet %v: RCODE = %d", forward, rcode)
+		}
+		if seen != forward {
+			t.Errorf("ForwardClientSubnet %v: upstream saw the option: %v", forward, seen)
+		}
+		if forward && !bytes.Equal(forwarded, ecs) {
+			t.Errorf("forwarded option %x, want %x", forwarded, ecs)
+		}
+	}
+
+	server := newTestServer(t)
+	query := newQuery(t, "example.com", dns.TypeA)
+	opt := &dns.OPT{UDPSize: 1232}
+	opt.SetOption(dns.OptionClientSubnet, []byte("\x00\x01\x21\x00"))
+	query.SetOPT(opt)
+	if rcode := exchange(t, server, query).Header.RCode(); rcode != dns.RCodeFormErr {
+		t.Errorf("malformed option: RCODE = %d, want FORMERR", rcode)
+	}
+}
+
+func TestMaxQuestions(t *testing.T) {
+	var forwarded int
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		forwarded++
+		return reply(query, aRecord(t, "example.com", "192.0.2.1", 300)), nil
+	})
+	query := newQuery(t, "a.example.com", dns.TypeA)
+	for _, name := range []string{"b.example.com", "c.example.com"} {
+		query.AddQuestion(newQuery(t, name, dns.TypeA).Questions[0])
+	}
+
+	// By default only single-question queries are answered
+	response := exchange(t, server, query)
+	if rcode := response.Header.RCode(); rcode != dns.RCodeRefused {
+		t.Errorf("3 questions with MaxQuestions 1: RCODE = %d, want REFUSED", rcode)
+	}
+	if forwarded != 0 {
+		t.Errorf("a refused query was forwarded %d times", forwarded)
+	}
+	if rcode := exchange(t, server, newQuery(t, "example.com", dns.TypeA)).Header.RCode(); rcode != dns.RCodeNoError {
+		t.Errorf("1 question with MaxQuestions 1: RCODE = %d, want NOERROR", rcode)
+	}
+
+	// Zero answers every question
+	server.MaxQuestions = 0
+	forwarded = 0
+	if rcode := exchange(t, server, query).Header.RCode(); rcode != dns.RCodeNoError {
+		t.Fatalf("unlimited: RCODE = %d, want NOERROR", rcode)
+	}
+	if forwarded != 3 {
+		t.Fatalf("unlimited: forwarded %d questions, want 3", forwarded)
+	}
+}
+
+func TestResponsesNeverSetZ(t *testing.T) {
+	forwarding := newTestServer(t)
+	forwarding.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		response := reply(query, aRecord(t, "www.example.org", "192.0.2.2", 300))
+		response.Header.Flags |= dns.FlagZ
+		return response, nil
+	})
+	authoritative := newTestServer(t)
+	authoritative.zone = testZone(t, "www.example.com A 192.0.2.1\n")
+
+	tests := []struct {
+		server *DNSServer
+		name   string
+	}{
+		{forwarding, "www.example.org"},
+		{forwarding, defaultHealthCheckName},
+		{authoritative, "www.example.com"},
+		{authoritative, "missing.example.com"},
+	}
+	for _, tt := range tests {
+		query := newQuery(t, tt.name, dns.TypeA)
+		query.Header.Flags |= dns.FlagZ
+		response := exchange(t, tt.server, query)
+		if len(response.Answers) == 0 && tt.name == "www.example.org" {
+			t.Fatalf("%s wasn't forwarded", tt.name)
+		}
+		if response.Header.Flags&dns.FlagZ != 0 {
+			t.Errorf("%s: response flags %#04x have Z set", tt.name, response.Header.Flags)
+		}
+	}
+}
+
+func TestForwardedCompressedCNAMESurvivesMerging(t *testing.T) {
+	// The upstream compresses the CNAME target against its question,
+	// which isn't at the same offset in the merged response
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dns.DNSMessage
+			if query.Parse(buf[:n]) != nil {
+				continue
+			}
+			questionEnd := 12 + len(query.Questions[0].QName) + 4
+			packet := []byte{buf[0], buf[1], 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0}
+			packet = append(packet, buf[12:questionEnd]...)
+			// <name> CNAME web.<pointer to the name's parent>
+			parent := byte(12 + 1 + query.Questions[0].QName[0])
+			packet = append(packet, 0xc0, 0x0c, 0, 5, 0, 1, 0, 0, 1, 0x2c, 0, 6, 3, 'w', 'e', 'b', 0xc0, parent)
+			conn.WriteTo(packet, from)
+		}
+	}()
+
+	server := newMultiQuestionServer(t, WithResolver(conn.LocalAddr().String()))
+	query := newQuery(t, "a.example.org", dns.TypeA)
+	query.AddQuestion(newQuery(t, "www.example.com", dns.TypeA).Questions[0])
+
+	data, err := server.HandleQuery(context.Background(), query.Encode())
+	if err != nil {
+		t.Fatalf("HandleQuery: %v", err)
+	}
+	var response dns.DNSMessage
+	if err := response.ParseComplete(data); err != nil {
+		t.Fatalf("merged response doesn't parse: %v", err)
+	}
+	if len(response.Answers) != 2 {
+		t.Fatalf("got %d answers, want a CNAME per question", len(response.Answers))
+	}
+	for i, want := range []string{"web.example.org", "web.example.com"} {
+		if a := response.Answers[i]; a.Type != dns.TypeCNAME || dns.NameString(a.RData) != want {
+			t.Errorf("answer %d = type %d %q, want CNAME %s", i, a.Type, a.RData, want)
+		}
+	}
+}
+
+// logLines is a logger that passes each write on over a channel, so
+// tests can wait for a message from the serve loop's goroutines
+type logLines chan string
+
+// Write sends p as one log message, dropping it if nobody is reading
+func (l logLines) Write(p []byte) (int, error) {
+	select {
+	case l <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+func TestWriteTimeoutDropsResponse(t *testing.T) {
+	logs := make(logLines, 64)
+	server := newTestServer(t, WithLogger(logs))
+	server.WriteTimeout = time.Nanosecond // expired before the write
+	startServer(t, server)
+
+	conn := dialServer(t, server)
+	// The serve loop keeps going after a timed-out write
+	for i := range 2 {
+		conn.Write(newQuery(t, "example.com", dns.TypeA).Encode())
+		timeout := time.After(2 * time.Second)
+	wait:
+		for {
+			select {
+			case line := <-logs:
+				if strings.HasPrefix(line, "Timed out sending response") {
+					break wait
+				}
+			case <-timeout:
+				t.Fatalf("query %d: no write timeout logged", i+1)
+			}
+		}
+	}
+}
+
+func TestPooledBuffersAreNotSharedAcrossHandlers(t *testing.T) {
+	const queries = 64
+	server := newTestServer(t)
+	// Hold each handler a moment so many are in flight at once, each
+	// with its read buffer checked out while later packets arrive
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+			time.Sleep(time.Duration(request.Header.ID%8) * time.Millisecond)
+			return next(ctx, request)
+		}
+	})
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		name := query.Questions[0].Name()
+		var n int
+		fmt.Sscanf(name, "host%d.example.com", &n)
+		return reply(query, aRecord(t, name, fmt.Sprintf("10.0.%d.%d", n/256, n%256), 300)), nil
+	})
+	startServer(t, server)
+
+	sent := make([]*dns.DNSMessage, queries)
+	conns := make([]net.Conn, queries)
+	for i := range queries {
+		sent[i] = newQuery(t, fmt.Sprintf("host%d.example.com", i), dns.TypeA)
+		conns[i] = dialServer(t, server)
+	}
+
+	errs := make(chan error, queries)
+	var wg sync.WaitGroup
+	for i := range queries {
+		wg.Go(func() {
+			conn := conns[i]
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Write(sent[i].Encode()); err != nil {
+				errs <- err
+				return
+			}
+			buf := make([]byte, maxPacketSize)
+			n, err := conn.Read(buf)
+			if err != nil {
+				errs <- fmt.Errorf("query %d: %v", i, err)
+				return
+			}
+			var response dns.DNSMessage
+			if err := response.ParseComplete(buf[:n]); err != nil {
+				errs <- fmt.Errorf("query %d: %v", i, err)
+				return
+			}
+			want := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+			ips := answerIPs(&response)
+			if response.Header.ID != sent[i].Header.ID || response.Questions[0].Name() != sent[i].Questions[0].Name() ||
+				len(ips) != 1 || ips[0] != want {
+				errs <- fmt.Errorf("query %d for %s got ID %d, question %s, answers %v",
+					i, sent[i].Questions[0].Name(), response.Header.ID, response.Questions[0].Name(), ips)
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// BenchmarkQueryBuffers compares handling a UDP query with Run's pooled
+// read and response buffers against allocating both per query
+func BenchmarkQueryBuffers(b *testing.B) {
+	server := newTestServer(b)
+	server.zone = dns.NewZone()
+	server.zone.AddA("www.example.com", net.IPv4(192, 0, 2, 1))
+	query, err := dns.NewQuery("www.example.com", dns.TypeA, true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	packet := query.Encode()
+	ctx := withClientAddr(context.Background(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5300})
+	server.packets.New = func() any {
+		buf := make([]byte, defaultPacketSize)
+		return &buf
+	}
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			in := server.packets.Get().(*[]byte)
+			n := copy(*in, packet)
+			out := responseBuffers.Get().(*[]byte)
+			response, err := server.handleQuery(ctx, (*in)[:n], *out)
+			if err != nil {
+				b.Fatal(err)
+			}
+			*out = response
+			responseBuffers.Put(out)
+			server.packets.Put(in)
+		}
+	})
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			in := make([]byte, defaultPacketSize)
+			n := copy(in, packet)
+			if _, err := server.handleQuery(ctx, in[:n], nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestHeldQueryKeepsItsBuffer(t *testing.T) {
+	server := newTestServer(t)
+	server.RecordFile = filepath.Join(t.TempDir(), "capture")
+
+	// Hold the query for slow.test until another has been answered
+	entered, release := make(chan struct{}), make(chan struct{})
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, request *dns.DNSMessage) *dns.DNSMessage {
+			if request.Questions[0].Name() == "slow.test" {
+				close(entered)
+				<-release
+			}
+			return next(ctx, request)
+		}
+	})
+	startServer(t, server)
+
+	slow := newQuery(t, "slow.test", dns.TypeA)
+	slowConn := dialServer(t, server)
+	slowConn.Write(slow.Encode())
+	<-entered
+
+	fast := newQuery(t, "a-much-longer-name.example", dns.TypeA)
+	fastConn := dialServer(t, server)
+	fastConn.Write(fast.Encode())
+	if response := readResponse(t, fastConn); response.Header.ID != fast.Header.ID {
+		t.Fatalf("fast response ID = %d, want %d", response.Header.ID, fast.Header.ID)
+	}
+
+	close(release)
+	response := readResponse(t, slowConn)
+	if response.Header.ID != slow.Header.ID || response.Questions[0].Name() != "slow.test" {
+		t.Errorf("held query answered as ID %d for %s", response.Header.ID, response.Questions[0].Name())
+	}
+
+	// The held query's packet is recorded after the other was read
+	queries, _ := readCapture(t, server.RecordFile)
+	if len(queries) != 2 {
+		t.Fatalf("captured %d exchanges, want 2", len(queries))
+	}
+	if !bytes.Equal(queries[1], slow.Encode()) {
+		t.Errorf("held query's packet was overwritten: recorded %q", queries[1])
+	}
+}
+
+func TestUnknownEDNSOptionReachesUpstream(t *testing.T) {
+	ecs, err := (&dns.ClientSubnet{Family: dns.FamilyIPv4, SourcePrefix: 24, Address: net.IPv4(198, 51, 100, 0)}).Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for _, drop := range []bool{false, true} {
+		var upstream *dns.OPT
+		server := newTestServer(t)
+		server.ForwardClientSubnet = true
+		server.DropUnknownOptions = drop
+		server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+			upstream, _ = query.OPT()
+			return reply(query), nil
+		})
+
+		query := newQuery(t, "example.com", dns.TypeA)
+		opt := &dns.OPT{UDPSize: 1232}
+		opt.SetOption(65001, []byte("experimental"))
+		opt.SetOption(dns.OptionClientSubnet, ecs)
+		query.SetOPT(opt)
+		exchange(t, server, query)
+
+		if upstream == nil {
+			t.Fatalf("DropUnknownOptions %v: the upstream query has no OPT record", drop)
+		}
+		data, ok := upstream.Option(65001)
+		if drop && ok {
+			t.Error("DropUnknownOptions passed the unknown option on")
+		}
+		if !drop && string(data) != "experimental" {
+			t.Errorf("unknown option reached the upstream as %q, %v", data, ok)
+		}
+		if _, ok := upstream.Option(dns.OptionClientSubnet); !ok {
+			t.Errorf("DropUnknownOptions %v: the known client subnet option was dropped", drop)
+		}
+	}
+}
+
+func TestMergedResponseIsCompressed(t *testing.T) {
+	server := newMultiQuestionServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		name := query.Questions[0].Name()
+		return reply(query, aRecord(t, name, "192.0.2.1", 300), aRecord(t, name, "192.0.2.2", 300)), nil
+	})
+	query := newQuery(t, "www.example.com", dns.TypeA)
+	for _, name := range []string{"mail.example.com", "ftp.example.com"} {
+		query.AddQuestion(newQuery(t, name, dns.TypeA).Questions[0])
+	}
+
+	data, err := server.HandleQuery(context.Background(), query.Encode())
+	if err != nil {
+		t.Fatalf("HandleQuery: %v", err)
+	}
+	var response dns.DNSMessage
+	if err := response.ParseComplete(data); err != nil {
+		t.Fatalf("ParseComplete: %v", err)
+	}
+	if len(response.Answers) != 6 {
+		t.Fatalf("got %d answers, want 6", len(response.Answers))
+	}
+
+	// The same message with every name written out in full
+	uncompressed := len(response.Header.Encode())
+	for _, q := range response.Questions {
+		uncompressed += len(q.Encode())
+	}
+	for _, a := range response.Answers {
+		uncompressed += len(a.Encode())
+	}
+
+	// Every answer's owner name is a 2-byte pointer to its question, and
+	// the later questions point at the first one's example.com
+	saved := 0
+	for _, a := range response.Answers {
+		saved += len(a.Name) - 2
+	}
+	saved += 2 * (len("\x07example\x03com\x00") - 2)
+	if len(data) != uncompressed-saved {
+		t.Errorf("merged response is %d bytes, %d uncompressed; want %d", len(data), uncompressed, uncompressed-saved)
+	}
+}
+
+// The server caps an EDNS client's advertised size at its own default
+// maximum of 1232 bytes
+func TestUDPResponseSizeNegotiation(t *testing.T) {
+	server := newTestServer(t)
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		var n int
+		fmt.Sscanf(query.Questions[0].Name(), "records%d.example.com", &n)
+		response := reply(query)
+		for i := range n {
+			response.AddAnswer(aRecord(t, query.Questions[0].Name(), fmt.Sprintf("10.0.%d.%d", i/256, i%256), 300))
+		}
+		return response, nil
+	})
+	startServer(t, server)
+	conn := dialServer(t, server)
+
+	tests := []struct {
+		advertised uint16 // 0 for no EDNS
+		records    int
+		limit      int
+		truncated  bool
+	}{
+		{4096, 100, 1232, true},
+		{4096, 20, 1232, false},
+		{800, 100, 800, true},
+		{0, 100, 512, true},
+	}
+	for _, tt := range tests {
+		query := newQuery(t, fmt.Sprintf("records%d.example.com", tt.records), dns.TypeA)
+		if tt.advertised > 0 {
+			query.SetOPT(&dns.OPT{UDPSize: tt.advertised})
+		}
+		conn.Write(query.Encode())
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, maxPacketSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		var response dns.DNSMessage
+		if err := response.ParseComplete(buf[:n]); err != nil {
+			t.Fatalf("ParseComplete: %v", err)
+		}
+		if n > tt.limit {
+			t.Errorf("advertised %d: %d-byte response, over the %d-byte limit", tt.advertised, n, tt.limit)
+		}
+		if response.Header.Truncated() != tt.truncated {
+			t.Errorf("advertised %d, %d records: TC %t, want %t", tt.advertised, tt.records, response.Header.Truncated(), tt.truncated)
+		}
+		if !tt.truncated && len(response.Answers) != tt.records {
+			t.Errorf("advertised %d: %d answers, want all %d", tt.advertised, len(response.Answers), tt.records)
+		}
+	}
+}
+
+func TestNSIDIsAnsweredLocally(t *testing.T) {
+	var forwarded bool
+	server := newTestServer(t, WithNSID("ns1.test"))
+	server.resolver = resolverFunc(func(ctx context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+		if opt, _ := query.OPT(); opt != nil {
+			_, forwarded = opt.Option(dns.OptionNSID)
+		}
+		return reply(query, aRecord(t, "example.com", "192.0.2.1", 300)), nil
+	})
+
+	for _, wantsNSID := range []bool{true, false} {
+		query := newQuery(t, "example.com", dns.TypeA)
+		opt := &dns.OPT{UDPSize: 1232}
+		if wantsNSID {
+			opt.SetOption(dns.OptionNSID, nil)
+		}
+		query.SetOPT(opt)
+
+		response := exchange(t, server, query)
+		if forwarded {
+			t.Error("the NSID option reached the upstream")
+		}
+		opt, err := response.OPT()
+		if err != nil || opt == nil {
+			t.Fatalf("response OPT = %v, %v", opt, err)
+		}
+		nsid, ok := opt.Option(dns.OptionNSID)
+		if wantsNSID && string(nsid) != "ns1.test" {
+			t.Errorf("NSID = %q, %t, want ns1.test", nsid, ok)
+		}
+		if !wantsNSID && ok {
+			t.Errorf("a query without NSID got %q back", nsid)
+		}
+	}
+}
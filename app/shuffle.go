@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"math/rand/v2"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// shuffleAnswers randomizes the order of each run of answer records
+// sharing an owner name and type, so clients spread their load over an
+// RRset's addresses. CNAME chains keep their order.
+func (s *DNSServer) shuffleAnswers(response *dns.DNSMessage) {
+	answers := response.Answers
+	for start := 0; start < len(answers); {
+		end := start + 1
+		for end < len(answers) && answers[end].Type == answers[start].Type &&
+			bytes.Equal(dns.CanonicalName(answers[end].Name), dns.CanonicalName(answers[start].Name)) {
+			end++
+		}
+		run := answers[start:end]
+		s.shuffle(len(run), func(i, j int) { run[i], run[j] = run[j], run[i] })
+		start = end
+	}
+}
+
+// shuffle permutes n elements with Rand when set, so tests can fix the
+// order, and with the global source otherwise
+func (s *DNSServer) shuffle(n int, swap func(i, j int)) {
+	if s.Rand == nil {
+		rand.Shuffle(n, swap)
+		return
+	}
+
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+	s.Rand.Shuffle(n, swap)
+}
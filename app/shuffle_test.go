@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestSeededShuffleIsReproducible(t *testing.T) {
+	ips := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"}
+	orders := func(seed uint64) [][]string {
+		server := newTestServer(t)
+		server.ShuffleAnswers = true
+		server.Rand = rand.New(rand.NewPCG(seed, 0))
+		var answers []dns.DNSAnswer
+		for _, ip := range ips {
+			answers = append(answers, aRecord(t, "www.example.com", ip, 300))
+		}
+		server.resolver = resolverFunc(func(_ context.Context, query *dns.DNSMessage) (*dns.DNSMessage, error) {
+			return reply(query, answers...), nil
+		})
+
+		var orders [][]string
+		for range 5 {
+			orders = append(orders, answerIPs(exchange(t, server, newQuery(t, "www.example.com", dns.TypeA))))
+		}
+		return orders
+	}
+
+	first, second := orders(42), orders(42)
+	if !slices.EqualFunc(first, second, slices.Equal) {
+		t.Errorf("the same seed gave different orders:\n%v\n%v", first, second)
+	}
+
+	shuffled := false
+	for _, order := range first {
+		if len(order) != len(ips) {
+			t.Fatalf("order %v lost answers", order)
+		}
+		shuffled = shuffled || !slices.Equal(order, ips)
+	}
+	if !shuffled {
+		t.Error("five responses all kept the upstream's order")
+	}
+}
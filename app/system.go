@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// systemAnswerTTL is the TTL of answers from the system resolver, which
+// doesn't report the real ones
+const systemAnswerTTL = 60
+
+// systemResolver returns the resolver SystemFallback uses
+func (s *DNSServer) systemResolver() *net.Resolver {
+	if s.SystemResolver != nil {
+		return s.SystemResolver
+	}
+	return net.DefaultResolver
+}
+
+// systemResponse answers a single A or AAAA question through the
+// system resolver. ok is false for other queries, or when the lookup
+// fails.
+func (s *DNSServer) systemResponse(ctx context.Context, request *dns.DNSMessage) (*dns.DNSMessage, bool) {
+	if len(request.Questions) != 1 {
+		return nil, false
+	}
+	q := request.Questions[0]
+	if q.QClass != dns.ClassIN || (q.QType != dns.TypeA && q.QType != dns.TypeAAAA) {
+		return nil, false
+	}
+
+	// Fully qualified, so no search domains get appended
+	name := q.Name()
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	addrs, err := s.systemResolver().LookupIPAddr(ctx, name)
+	if err != nil {
+		s.logf("System resolver failed for %s: %v\n", name, err)
+		return nil, false
+	}
+
+	response := dns.DNSMessage{Header: request.Header.BuildResponse()}
+	response.SyncCounts()
+	response.AddQuestion(q)
+	for _, addr := range addrs {
+		rdata := addr.IP.To4()
+		if q.QType == dns.TypeAAAA {
+			if rdata != nil {
+				continue
+			}
+			rdata = addr.IP.To16()
+		}
+		if rdata == nil {
+			continue
+		}
+		response.AddAnswer(dns.DNSAnswer{
+			Name:     q.QName,
+			Type:     q.QType,
+			Class:    q.QClass,
+			TTL:      systemAnswerTTL,
+			RDLength: uint16(len(rdata)),
+			RData:    rdata,
+		})
+	}
+
+	return &response, true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestSystemFallback(t *testing.T) {
+	// The system resolver's queries go to a stub answering every A
+	// query with 192.0.2.77, and nothing else
+	stub, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { stub.Close() })
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := stub.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dns.DNSMessage
+			if query.Parse(buf[:n]) != nil {
+				continue
+			}
+			response := reply(&query)
+			if q := query.Questions[0]; q.QType == dns.TypeA {
+				response.AddAnswer(aRecord(t, q.Name(), "192.0.2.77", 300))
+			}
+			stub.WriteTo(response.Encode(), from)
+		}
+	}()
+
+	server := newTestServer(t)
+	server.zone = testZone(t, "www.example.com A 192.0.2.1\n")
+	server.SystemResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", stub.LocalAddr().String())
+		},
+	}
+
+	tests := []struct {
+		name     string
+		qtype    uint16
+		fallback bool
+		rcode    uint16
+		want     []string
+	}{
+		{"www.example.com", dns.TypeA, true, dns.RCodeNoError, []string{"192.0.2.1"}},
+		{"elsewhere.example.com", dns.TypeA, true, dns.RCodeNoError, []string{"192.0.2.77"}},
+		{"elsewhere.example.com", dns.TypeAAAA, true, dns.RCodeNoError, nil},
+		{"elsewhere.example.com", dns.TypeA, false, dns.RCodeNXDomain, nil},
+	}
+	for _, tt := range tests {
+		server.SystemFallback = tt.fallback
+		response := exchange(t, server, newQuery(t, tt.name, tt.qtype))
+		ips := answerIPs(response)
+		if rcode := response.Header.RCode(); rcode != tt.rcode || len(ips) != len(tt.want) || (len(ips) > 0 && ips[0] != tt.want[0]) {
+			t.Errorf("%s %s (fallback %v): RCODE %d answers %v, want %d %v",
+				tt.name, dns.TypeString(tt.qtype), tt.fallback, rcode, ips, tt.rcode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// tcpIdleTimeout closes client TCP connections that send no query for
+// this long (RFC 7766 suggests seconds, not minutes)
+const tcpIdleTimeout = 10 * time.Second
+
+// readTCPMessage reads one length-prefixed DNS message (RFC 1035
+// section 4.2.2). Both the prefix and the message may arrive split
+// across several reads, so each is read in full.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// writeTCPMessage writes msg with its 2-byte length prefix in a single
+// write
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > maxPacketSize {
+		return fmt.Errorf("message of %d bytes too long for TCP", len(msg))
+	}
+
+	buf := make([]byte, 0, 2+len(msg))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// serveTCP accepts DNS-over-TCP connections until the listener closes
+func (s *DNSServer) serveTCP(listener net.Listener, inFlight chan struct{}, rec *recorder) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logf("Error accepting TCP connection: %v\n", err)
+			}
+			return
+		}
+		go s.serveTCPConn(conn, inFlight, rec)
+	}
+}
+
+// serveTCPConn answers the queries sent on one TCP connection, in
+// order, until the client closes it or goes idle
+func (s *DNSServer) serveTCPConn(conn net.Conn, inFlight chan struct{}, rec *recorder) {
+	defer conn.Close()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.logf("Error reading TCP query from %s: %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		s.logf("Received %d bytes over TCP from %s\n", len(query), conn.RemoteAddr())
+
+		// Every query holds a slot in inFlight, zone transfers included
+		var response []byte
+		if acquire(inFlight, inFlightWait) {
+			// Zone transfers answer with a stream of messages
+			messages, transfer := s.transfer(query)
+			if transfer {
+				err = s.writeTransfer(conn, messages)
+			} else {
+				response, err = s.handleTCPQuery(conn.RemoteAddr(), query)
+			}
+			<-inFlight
+			if transfer {
+				if err != nil {
+					s.logf("Failed to send zone transfer: %v\n", err)
+					return
+				}
+				continue
+			}
+		} else {
+			s.logf("Too many queries in flight, refusing query from %s\n", conn.RemoteAddr())
+			response = refusedResponse(query)
+		}
+		if err != nil {
+			s.logf("Error handling query: %v\n", err)
+			continue
+		}
+		if response == nil {
+			continue
+		}
+
+		if rec != nil {
+			if err := rec.Record(conn.RemoteAddr(), query, response); err != nil {
+				s.logf("Failed to record exchange: %v\n", err)
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout()))
+		if err := writeTCPMessage(conn, response); err != nil {
+			s.logf("Failed to send response: %v\n", err)
+			return
+		}
+	}
+}
+
+// writeTransfer sends the messages of a zone transfer on conn
+func (s *DNSServer) writeTransfer(conn net.Conn, messages [][]byte) error {
+	for _, message := range messages {
+		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout()))
+		if err := writeTCPMessage(conn, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleTCPQuery handles one query received over TCP within the
+// configured timeout
+func (s *DNSServer) handleTCPQuery(source net.Addr, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(withClientAddr(context.Background(), source), s.timeout())
+	defer cancel()
+	return s.HandleQuery(ctx, query)
+}
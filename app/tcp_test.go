@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// dialTCP connects to the server's TCP listener, which Run opens
+// shortly after it starts
+func dialTCP(t *testing.T, server *DNSServer) net.Conn {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", server.LocalAddr().String())
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dial: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readTCPResponse reads and parses one length-prefixed response
+func readTCPResponse(t *testing.T, conn net.Conn) *dns.DNSMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, err := readTCPMessage(conn)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	var response dns.DNSMessage
+	if err := response.ParseComplete(data); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return &response
+}
+
+func TestReadTCPMessageFromPieces(t *testing.T) {
+	msg := bytes.Repeat([]byte{0xAB}, 3000)
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	framed = append(framed, msg...)
+
+	got, err := readTCPMessage(iotest.OneByteReader(bytes.NewReader(framed)))
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("readTCPMessage = %d bytes, %v; want the %d-byte message", len(got), err, len(msg))
+	}
+
+	if _, err := readTCPMessage(bytes.NewReader(framed[:100])); err == nil {
+		t.Error("readTCPMessage accepted a message cut short")
+	}
+}
+
+func TestTCPQuerySplitAcrossWrites(t *testing.T) {
+	server := newTestServer(t)
+	server.ListenTCP = true
+	startServer(t, server)
+
+	// An unknown EDNS option makes the query large
+	query := newQuery(t, "example.com", dns.TypeA)
+	opt := &dns.OPT{UDPSize: 1232}
+	opt.SetOption(65001, make([]byte, 2000))
+	query.SetOPT(opt)
+	packet := query.Encode()
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(packet)))
+	framed = append(framed, packet...)
+
+	conn := dialTCP(t, server)
+	conn.Write(framed[:1])
+	time.Sleep(20 * time.Millisecond)
+	conn.Write(framed[1:1000])
+	time.Sleep(20 * time.Millisecond)
+	conn.Write(framed[1000:])
+
+	response := readTCPResponse(t, conn)
+	if response.Header.ID != query.Header.ID || response.Header.RCode() != dns.RCodeNoError {
+		t.Errorf("response ID %d RCODE %d, want ID %d NOERROR", response.Header.ID, response.Header.RCode(), query.Header.ID)
+	}
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	server := newTestServer(t)
+	server.ListenTCP = true
+	server.resolver = answerA(t, "192.0.2.1")
+	startServer(t, server)
+
+	// A 2KB query, well past any UDP assumption, parses over TCP
+	query := newQuery(t, "big.example.com", dns.TypeA)
+	opt := &dns.OPT{UDPSize: 1232}
+	opt.SetOption(65001, make([]byte, 2048))
+	query.SetOPT(opt)
+	conn := dialTCP(t, server)
+	if err := writeTCPMessage(conn, query.Encode()); err != nil {
+		t.Fatalf("writeTCPMessage: %v", err)
+	}
+	response := readTCPResponse(t, conn)
+	if ips := answerIPs(response); response.Questions[0].Name() != "big.example.com" || len(ips) != 1 {
+		t.Errorf("2KB query answered for %s with %v", response.Questions[0].Name(), ips)
+	}
+
+	// Nothing can carry more than 65535 bytes, so a bigger message from
+	// another source (the API, a replay file) is FORMERR
+	huge := append(newQuery(t, "example.com", dns.TypeA).Encode(), make([]byte, maxPacketSize)...)
+	data, err := server.HandleQuery(context.Background(), huge)
+	if err != nil {
+		t.Fatalf("HandleQuery: %v", err)
+	}
+	var formErr dns.DNSMessage
+	if err := formErr.Parse(data); err != nil || formErr.Header.RCode() != dns.RCodeFormErr {
+		t.Errorf("oversized message: RCODE %d (%v), want FORMERR", formErr.Header.RCode(), err)
+	}
+}
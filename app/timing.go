@@ -0,0 +1,30 @@
+package main
+
+import "context"
+
+// Answer sources, as logged with each query
+const (
+	sourceLocal   = "local" // health checks, handlers, errors and local zones
+	sourceZone    = "zone"
+	sourceSystem  = "system"
+	sourceCache   = "cache"
+	sourceStale   = "stale"
+	sourceForward = "forward"
+)
+
+// answerSourceKey carries where a query's answer came from in a context
+type answerSourceKey struct{}
+
+// withAnswerSource returns a context the answer's source can be
+// recorded in, and where it will be found
+func withAnswerSource(ctx context.Context) (context.Context, *string) {
+	source := sourceLocal
+	return context.WithValue(ctx, answerSourceKey{}, &source), &source
+}
+
+// setAnswerSource records where the answer being built came from
+func setAnswerSource(ctx context.Context, source string) {
+	if p, ok := ctx.Value(answerSourceKey{}).(*string); ok {
+		*p = source
+	}
+}
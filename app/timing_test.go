@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestTimingLogNamesDurationAndSource(t *testing.T) {
+	var log bytes.Buffer
+	forwarding := newTestServer(t, WithLogger(&log), WithCache(NewMemoryCache(16)))
+	forwarding.resolver = answerA(t, "192.0.2.1")
+	authoritative := newTestServer(t, WithLogger(&log))
+	authoritative.zone = testZone(t, "www.example.com A 192.0.2.1\n")
+
+	timing := regexp.MustCompile(`Request ID: (\d+), .* answered in (\S+) from (\w+)\n`)
+	tests := []struct {
+		server *DNSServer
+		name   string
+		source string
+	}{
+		{forwarding, "example.com", sourceForward},
+		{forwarding, "example.com", sourceCache},
+		{forwarding, defaultHealthCheckName, sourceLocal},
+		{authoritative, "www.example.com", sourceZone},
+	}
+	for _, tt := range tests {
+		log.Reset()
+		query := newQuery(t, tt.name, dns.TypeA)
+		exchange(t, tt.server, query)
+
+		match := timing.FindStringSubmatch(log.String())
+		if match == nil {
+			t.Errorf("%s: no timing line in %q", tt.name, log.String())
+			continue
+		}
+		if elapsed, err := time.ParseDuration(match[2]); err != nil || elapsed <= 0 {
+			t.Errorf("%s: logged duration %q isn't one", tt.name, match[2])
+		}
+		if match[3] != tt.source {
+			t.Errorf("%s: logged source %q, want %q", tt.name, match[3], tt.source)
+		}
+		if match[1] != fmt.Sprint(query.Header.ID) {
+			t.Errorf("%s: logged ID %s, want %d", tt.name, match[1], query.Header.ID)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "github.com/codecrafters-io/dns-server-starter-go/app/dns"
+
+// UpdateHandler returns a handler applying DNS UPDATE (RFC 2136)
+// messages to zone. The response echoes the zone section and carries
+// the outcome in its RCODE.
+func (s *DNSServer) UpdateHandler(zone *dns.Zone) HandlerFunc {
+	return func(request *dns.DNSMessage) *dns.DNSMessage {
+		rcode := zone.Update(request)
+		if rcode == dns.RCodeNoError && s.Debug {
+			s.logf("Applied update with %d prerequisites, %d changes\n", len(request.Answers), len(request.Authorities))
+		}
+		return errorResponse(request, rcode)
+	}
+}
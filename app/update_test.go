@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// updateMessage builds an UPDATE for the example.com zone carrying
+// updates in its authority section
+func updateMessage(t *testing.T, updates ...dns.DNSAnswer) *dns.DNSMessage {
+	t.Helper()
+	msg := &dns.DNSMessage{Header: dns.DNSHeader{ID: 42, Flags: dns.OpcodeUpdate << 11}}
+	msg.AddQuestion(dns.Question{QName: []byte("\x07example\x03com\x00"), QType: dns.TypeSOA, QClass: dns.ClassIN})
+	for _, rr := range updates {
+		msg.AddAuthority(rr)
+	}
+	return msg
+}
+
+func TestUpdateAddsAndDeletesRecords(t *testing.T) {
+	var log bytes.Buffer
+	server := newTestServer(t, WithLogger(&log))
+	server.zone = transferZone(t, 0)
+	server.AllowUpdates = true
+
+	record := aRecord(t, "host.example.com", "192.0.2.7", 300)
+	response := exchange(t, server, updateMessage(t, record))
+	if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+		t.Fatalf("add: RCODE = %d, want NOERROR", rcode)
+	}
+	response = exchange(t, server, newQuery(t, "host.example.com", dns.TypeA))
+	if ips := answerIPs(response); len(ips) != 1 || ips[0] != "192.0.2.7" {
+		t.Fatalf("after add: answers = %v, want [192.0.2.7]", ips)
+	}
+
+	// Deleting a single RR uses class NONE and a zero TTL
+	record.Class, record.TTL = dns.ClassNONE, 0
+	response = exchange(t, server, updateMessage(t, record))
+	if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+		t.Fatalf("delete: RCODE = %d, want NOERROR", rcode)
+	}
+	response = exchange(t, server, newQuery(t, "host.example.com", dns.TypeA))
+	if len(response.Answers) != 0 || response.Header.RCode() != dns.RCodeNXDomain {
+		t.Errorf("after delete: RCODE %d with %d answers, want NXDOMAIN", response.Header.RCode(), len(response.Answers))
+	}
+
+	if strings.Contains(log.String(), "Applied update") {
+		t.Errorf("updates were logged without Debug: %q", log.String())
+	}
+	server.Debug = true
+	exchange(t, server, updateMessage(t, aRecord(t, "host.example.com", "192.0.2.8", 300)))
+	if !strings.Contains(log.String(), "Applied update") {
+		t.Errorf("debug log lacks the update: %q", log.String())
+	}
+}
+
+func TestUpdateRefusedUnlessAllowed(t *testing.T) {
+	server := newTestServer(t)
+	server.zone = testZone(t, "example.com NS ns.example.com\n")
+
+	response := exchange(t, server, updateMessage(t, aRecord(t, "host.example.com", "192.0.2.7", 300)))
+	if rcode := response.Header.RCode(); rcode != dns.RCodeRefused {
+		t.Errorf("RCODE = %d, want REFUSED", rcode)
+	}
+}
+
+func TestUpdateRejectsForeignZone(t *testing.T) {
+	server := newTestServer(t)
+	server.zone = transferZone(t, 0)
+	server.AllowUpdates = true
+
+	update := updateMessage(t, aRecord(t, "www.bank.com", "203.0.113.66", 300))
+	update.Questions[0].QName = []byte("\x04bank\x03com\x00")
+	response := exchange(t, server, update)
+	if rcode := response.Header.RCode(); rcode != dns.RCodeNotAuth {
+		t.Fatalf("RCODE = %d, want NOTAUTH", rcode)
+	}
+	response = exchange(t, server, newQuery(t, "www.bank.com", dns.TypeA))
+	if len(response.Answers) != 0 {
+		t.Errorf("foreign name answered with %v", answerIPs(response))
+	}
+}
+
+func TestUpdateKeepsApexSOAAndNS(t *testing.T) {
+	server := newTestServer(t)
+	server.zone = transferZone(t, 0)
+	server.AllowUpdates = true
+
+	apex := []byte("\x07example\x03com\x00")
+	ns, err := dns.EncodeName("ns.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	soa := soaRecord(3600, 300).RData
+	deletes := []dns.DNSAnswer{
+		{Name: apex, Type: dns.TypeNS, Class: dns.ClassANY},
+		{Name: apex, Type: dns.TypeSOA, Class: dns.ClassANY},
+		{Name: apex, Type: dns.TypeANY, Class: dns.ClassANY},
+		{Name: apex, Type: dns.TypeNS, Class: dns.ClassNONE, RDLength: uint16(len(ns)), RData: ns},
+		{Name: apex, Type: dns.TypeSOA, Class: dns.ClassNONE, RDLength: uint16(len(soa)), RData: soa},
+	}
+	for _, rr := range deletes {
+		response := exchange(t, server, updateMessage(t, rr))
+		if rcode := response.Header.RCode(); rcode != dns.RCodeNoError {
+			t.Fatalf("delete type %d class %d: RCODE = %d, want NOERROR", rr.Type, rr.Class, rcode)
+		}
+	}
+
+	for _, qtype := range []uint16{dns.TypeSOA, dns.TypeNS} {
+		response := exchange(t, server, newQuery(t, "example.com", qtype))
+		if len(response.Answers) != 1 {
+			t.Errorf("type %d: %d answers after deletes, want 1", qtype, len(response.Answers))
+		}
+	}
+}
+
+func TestUpdateValueDependentPrerequisiteMatchesWholeRRset(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string // owner of each prerequisite
+		ips   []string
+		rcode uint16
+	}{
+		{"whole RRset", []string{"www.example.com", "www.example.com"}, []string{"192.0.2.1", "192.0.2.2"}, dns.RCodeNoError},
+		{"mixed case", []string{"WWW.example.com", "www.EXAMPLE.com"}, []string{"192.0.2.2", "192.0.2.1"}, dns.RCodeNoError},
+		{"subset", []string{"www.example.com"}, []string{"192.0.2.1"}, dns.RCodeNXRRSet},
+		{"superset", []string{"www.example.com", "www.example.com", "www.example.com"}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, dns.RCodeNXRRSet},
+	}
+	for _, tt := range tests {
+		server := newTestServer(t)
+		server.zone = transferZone(t, 0)
+		server.AllowUpdates = true
+		for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+			if err := server.zone.AddA("www.example.com", net.ParseIP(ip)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		update := updateMessage(t, aRecord(t, "host.example.com", "192.0.2.7", 300))
+		for i, ip := range tt.ips {
+			update.AddAnswer(aRecord(t, tt.names[i], ip, 0))
+		}
+		response := exchange(t, server, update)
+		if rcode := response.Header.RCode(); rcode != tt.rcode {
+			t.Errorf("%s: RCODE = %d, want %d", tt.name, rcode, tt.rcode)
+		}
+		response = exchange(t, server, newQuery(t, "host.example.com", dns.TypeA))
+		if applied := len(response.Answers) > 0; applied != (tt.rcode == dns.RCodeNoError) {
+			t.Errorf("%s: update applied %t with RCODE %d", tt.name, applied, tt.rcode)
+		}
+	}
+}